@@ -0,0 +1,212 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"nft-event/model"
+	"nft-event/service"
+	"strconv"
+	"strings"
+)
+
+// contractRequest body of adding a contract
+type contractRequest struct {
+	ChainId uint64 `json:"chainId"`
+	Address string `json:"address"`
+	model.NftSettings
+}
+
+// contracts serves /admin/contracts, listing and adding contracts
+func (s *Server) contracts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var chainId uint64
+		if value := r.URL.Query().Get("chainId"); value != "" {
+			id, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid chainId %q", value))
+				return
+			}
+			chainId = id
+		}
+		nfts, err := service.ListNfts(r.Context(), s.client, s.config, chainId)
+		if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, nfts)
+	case http.MethodPost:
+		var request contractRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		chainId, address, ok := s.parseContract(w, strconv.FormatUint(request.ChainId, 10), request.Address)
+		if !ok {
+			return
+		}
+		if err := request.NftSettings.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		nft, err := service.AddNft(r.Context(), s.chains[chainId], s.client, s.config, chainId, address, request.NftSettings)
+		switch {
+		case errors.Is(err, service.ErrNotErc721):
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, service.ErrNftExists):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			s.internalError(w, err)
+		default:
+			log.Infof("contract %s added on chain %d", nft.Address, chainId)
+			writeJSON(w, http.StatusCreated, nft)
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// contract serves /admin/contracts/{chainId}/{address}, removing a contract or replacing its settings,
+// and its pause, resume and refresh actions
+func (s *Server) contract(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/contracts/"), "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+	if action == "refresh" {
+		s.refresh(w, r, parts[0], parts[1])
+		return
+	}
+
+	var status string
+	var settings *model.NftSettings
+	switch {
+	case action == "" && r.Method == http.MethodDelete:
+	case action == "" && r.Method == http.MethodPut:
+		settings = &model.NftSettings{}
+		if err := json.NewDecoder(r.Body).Decode(settings); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+		if err := settings.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case action == "pause" && r.Method == http.MethodPost:
+		status = model.NftPaused
+	case action == "resume" && r.Method == http.MethodPost:
+		status = model.NftActive
+	case action == "":
+		methodNotAllowed(w, http.MethodPut, http.MethodDelete)
+		return
+	case action == "pause" || action == "resume":
+		methodNotAllowed(w, http.MethodPost)
+		return
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	chainId, address, ok := s.parseContract(w, parts[0], parts[1])
+	if !ok {
+		return
+	}
+
+	var err error
+	switch {
+	case settings != nil:
+		err = service.SetNftSettings(r.Context(), s.client, s.config, chainId, address, *settings)
+	case status == "":
+		err = service.RemoveNft(r.Context(), s.client, s.config, chainId, address)
+	default:
+		err = service.SetNftStatus(r.Context(), s.client, s.config, chainId, address, status)
+	}
+	if errors.Is(err, service.ErrNftNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	if settings != nil {
+		log.Infof("contract %s settings changed on chain %d: %+v", model.Address(address), chainId, *settings)
+		writeJSON(w, http.StatusOK, settings)
+		return
+	}
+	if status == "" {
+		log.Infof("contract %s removed on chain %d", model.Address(address), chainId)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	log.Infof("contract %s %s on chain %d", model.Address(address), status, chainId)
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// refreshRequest body of requesting a metadata refresh, all tokens of the contract without token ids
+type refreshRequest struct {
+	TokenIds []string `json:"tokenIds"`
+}
+
+// refresh serves /admin/contracts/{chainId}/{address}/refresh, queuing a metadata refresh of tokens for the job
+func (s *Server) refresh(w http.ResponseWriter, r *http.Request, chain, hex string) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var request refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	for _, tokenId := range request.TokenIds {
+		if !model.ValidTokenId(tokenId) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid token id %q", tokenId))
+			return
+		}
+	}
+	chainId, address, ok := s.parseContract(w, chain, hex)
+	if !ok {
+		return
+	}
+	requested, err := service.RequestMetadataRefresh(r.Context(), s.client, s.config, chainId, address, request.TokenIds)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	log.Infof("metadata refresh of %d tokens of %s requested on chain %d", requested, model.Address(address), chainId)
+	writeJSON(w, http.StatusAccepted, map[string]int64{"requested": requested})
+}
+
+// parseContract parses the chain id and address of a contract, answering the request when they are invalid
+func (s *Server) parseContract(w http.ResponseWriter, chain, hex string) (uint64, common.Address, bool) {
+	chainId, err := strconv.ParseUint(chain, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid chainId %q", chain))
+		return 0, common.Address{}, false
+	}
+	if _, ok := s.chains[chainId]; !ok {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("chain %d is not configured", chainId))
+		return 0, common.Address{}, false
+	}
+	if !common.IsHexAddress(hex) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid address %q", hex))
+		return 0, common.Address{}, false
+	}
+	return chainId, common.HexToAddress(hex), true
+}
+
+func (s *Server) internalError(w http.ResponseWriter, err error) {
+	log.Error(err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"net/http"
+	"nft-event/indexer"
+	"nft-event/model"
+	"nft-event/service"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageSize items per page when the request does not ask for a limit
+	DefaultPageSize int64 = 100
+	// MaxPageSize largest limit a request may ask for
+	MaxPageSize int64 = 1000
+)
+
+// wallet serves the read endpoints of a wallet below /wallets/{chainId}/{address}
+//   - /holdings the holdings, of one contract with ?contract=
+//   - /activity the activity feed, latest first or oldest first with ?order=asc,
+//     of one contract with ?contract= and of some types with ?type=mint,sale
+func (s *Server) wallet(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/wallets/"), "/"), "/")
+	if len(parts) != 3 || (parts[2] != "holdings" && parts[2] != "activity") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	chainId, wallet, ok := s.parseContract(w, parts[0], parts[1])
+	if !ok {
+		return
+	}
+	var contract common.Address
+	if hex := r.URL.Query().Get("contract"); hex != "" {
+		if !common.IsHexAddress(hex) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid contract %q", hex))
+			return
+		}
+		contract = common.HexToAddress(hex)
+	}
+
+	if parts[2] == "holdings" {
+		holdings, err := service.WalletHoldings(r.Context(), s.client, s.config, chainId, wallet, contract)
+		if err != nil {
+			s.internalError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, holdings)
+		return
+	}
+
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	filter := service.ActivityFilter{Contract: contract}
+	switch order := r.URL.Query().Get("order"); order {
+	case "", "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid order %q, asc or desc", order))
+		return
+	}
+	if types := r.URL.Query().Get("type"); types != "" {
+		for _, activityType := range strings.Split(types, ",") {
+			if !validActivityType(activityType) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid type %q, one of %s", activityType, strings.Join(model.ActivityTypes, ", ")))
+				return
+			}
+			filter.Types = append(filter.Types, activityType)
+		}
+	}
+
+	activities, err := service.WalletActivity(r.Context(), s.client, s.config, chainId, wallet, filter, limit, offset)
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, activities)
+}
+
+func validActivityType(activityType string) bool {
+	for _, t := range model.ActivityTypes {
+		if t == activityType {
+			return true
+		}
+	}
+	return false
+}
+
+// collection serves the read endpoints of a contract below /contracts/{chainId}/{address}
+//   - /holders the holder count and largest holders
+//   - /holders/stats the current holder distribution, with ?top= largest holders
+//   - /holders/history the holder reports between ?since= and ?until=, RFC 3339 times
+//   - /alerts the whale alerts, latest first
+//   - /tokens/{tokenId}/metadata the metadata versions of a token, latest first
+func (s *Server) collection(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/contracts/"), "/"), "/")
+	if len(parts) < 3 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	endpoint := strings.Join(parts[2:], "/")
+	var tokenId string
+	if len(parts) == 5 && parts[2] == "tokens" && parts[4] == "metadata" {
+		endpoint, tokenId = "tokens/metadata", parts[3]
+	}
+	switch endpoint {
+	case "holders", "holders/stats", "holders/history", "alerts", "tokens/metadata":
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	chainId, address, ok := s.parseContract(w, parts[0], parts[1])
+	if !ok {
+		return
+	}
+	limit, offset, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch endpoint {
+	case "holders":
+		result, err = service.TopHolders(r.Context(), s.client, s.config, chainId, address, limit, offset)
+	case "holders/stats":
+		top := indexer.ReportTop
+		if value := r.URL.Query().Get("top"); value != "" {
+			top, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || top < 1 || top > MaxPageSize {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("top must be between 1 and %d", MaxPageSize))
+				return
+			}
+		}
+		result, err = service.HolderStats(r.Context(), s.client, s.config, chainId, address, top)
+	case "holders/history":
+		since, until, ok := parseTimeRange(w, r)
+		if !ok {
+			return
+		}
+		result, err = service.HolderHistory(r.Context(), s.client, s.config, chainId, address, since, until, limit)
+	case "alerts":
+		result, err = service.WhaleAlerts(r.Context(), s.client, s.config, chainId, address, limit, offset)
+	case "tokens/metadata":
+		if !model.ValidTokenId(tokenId) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid token id %q", tokenId))
+			return
+		}
+		result, err = service.MetadataHistory(r.Context(), s.client, s.config, chainId, address, tokenId, limit, offset)
+	}
+	if err != nil {
+		s.internalError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// parseTimeRange parses the since and until query parameters, the last 30 days when missing,
+// answering the request when they are invalid
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	until := time.Now()
+	since := until.AddDate(0, 0, -30)
+	for name, target := range map[string]*time.Time{"since": &since, "until": &until} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", name, value))
+			return time.Time{}, time.Time{}, false
+		}
+		*target = t
+	}
+	return since, until, true
+}
+
+// parsePage parses the limit and offset query parameters, answering the request when they are invalid
+func parsePage(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	limit, offset := DefaultPageSize, int64(0)
+	for name, target := range map[string]*int64{"limit": &limit, "offset": &offset} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s %q", name, value))
+			return 0, 0, false
+		}
+		*target = n
+	}
+	if limit == 0 || limit > MaxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize))
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"nft-event/eth"
+	"nft-event/util"
+	"strings"
+)
+
+// Server http api of the indexed data and the admin endpoints
+type Server struct {
+	client *mongo.Client
+	config *util.Config
+	// chains rpc client of every configured chain by chain id
+	chains map[uint64]*eth.Client
+	mux    *http.ServeMux
+}
+
+func NewServer(client *mongo.Client, config *util.Config, chains map[uint64]*eth.Client) *Server {
+	s := &Server{client: client, config: config, chains: chains, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/admin/contracts", s.admin(s.contracts))
+	s.mux.HandleFunc("/admin/contracts/", s.admin(s.contract))
+	s.mux.HandleFunc("/contracts/", s.collection)
+	s.mux.HandleFunc("/wallets/", s.wallet)
+	return s
+}
+
+// Handler handler serving all endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// admin only lets requests with the admin token through, admin endpoints are disabled without ADMIN_TOKEN
+func (s *Server) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken == "" {
+			writeError(w, http.StatusForbidden, "admin endpoints are disabled, set ADMIN_TOKEN")
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// methodNotAllowed answers a request with a method the endpoint does not serve
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"nft-event/eth"
+	"nft-event/util"
+	"strings"
+	"testing"
+)
+
+func request(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestAdminAuth(t *testing.T) {
+	s := NewServer(nil, &util.Config{}, nil)
+	assert.Equal(t, http.StatusForbidden, request(s, http.MethodGet, "/admin/contracts", "secret", "").Code)
+
+	s = NewServer(nil, &util.Config{AdminToken: "secret"}, nil)
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodGet, "/admin/contracts", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(s, http.MethodGet, "/admin/contracts", "wrong", "").Code)
+}
+
+func TestAdminContractValidation(t *testing.T) {
+	s := NewServer(nil, &util.Config{AdminToken: "secret"}, map[uint64]*eth.Client{1: nil})
+	address := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/admin/contracts", `{"chainId": 1, "address": "0x123"}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/contracts", `{"chainId": 5, "address": "` + address + `"}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/contracts", `not json`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/contracts", ``, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/contracts?chainId=x", ``, http.StatusBadRequest},
+		{http.MethodPost, "/admin/contracts/1/" + address + "/stop", ``, http.StatusNotFound},
+		{http.MethodGet, "/admin/contracts/1/" + address + "/pause", ``, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/contracts/x/" + address + "/pause", ``, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/contracts/1/0x123", ``, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/contracts/1", ``, http.StatusNotFound},
+		{http.MethodPost, "/admin/contracts", `{"chainId": 1, "address": "` + address + `", "metadata": "sometimes"}`, http.StatusBadRequest},
+		{http.MethodPut, "/admin/contracts/1/" + address, `{"metadata": "refresh"}`, http.StatusBadRequest},
+		{http.MethodPatch, "/admin/contracts/1/" + address, `{}`, http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/contracts/1/" + address + "/refresh", ``, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/contracts/1/" + address + "/refresh", `{"tokenIds": ["x"]}`, http.StatusBadRequest},
+		{http.MethodPost, "/admin/contracts/1/0x123/refresh", ``, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		w := request(s, test.method, test.path, "secret", test.body)
+		assert.Equal(t, test.status, w.Code, "%s %s", test.method, test.path)
+		assert.Contains(t, w.Body.String(), "error")
+	}
+}
+
+func TestHoldingsValidation(t *testing.T) {
+	s := NewServer(nil, &util.Config{}, map[uint64]*eth.Client{1: nil})
+	address := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/wallets/1/" + address, http.StatusNotFound},
+		{http.MethodPost, "/wallets/1/" + address + "/holdings", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/wallets/5/" + address + "/holdings", http.StatusBadRequest},
+		{http.MethodGet, "/wallets/1/" + address + "/holdings?contract=0x123", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/0x123/holders", http.StatusBadRequest},
+		{http.MethodGet, "/wallets/1/" + address + "/activity?type=mint,swap", http.StatusBadRequest},
+		{http.MethodGet, "/wallets/1/" + address + "/activity?order=newest", http.StatusBadRequest},
+		{http.MethodGet, "/wallets/1/" + address + "/activity?limit=x", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/holders?limit=0", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/holders?limit=5000", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/holders?offset=-1", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/holders/stats?top=0", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/holders/history?since=yesterday", http.StatusBadRequest},
+		{http.MethodPost, "/contracts/1/" + address + "/alerts", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/contracts/1/" + address + "/whales", http.StatusNotFound},
+		{http.MethodGet, "/contracts/1/" + address + "/tokens/01/metadata", http.StatusBadRequest},
+		{http.MethodGet, "/contracts/1/" + address + "/tokens/1", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		w := request(s, test.method, test.path, "", "")
+		assert.Equal(t, test.status, w.Code, "%s %s", test.method, test.path)
+		assert.Contains(t, w.Body.String(), "error")
+	}
+}
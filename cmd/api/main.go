@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"nft-event/api"
+	"nft-event/db"
+	"nft-event/eth"
+	"nft-event/migration"
+	"nft-event/util"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// shutdownTimeout time allowed to finish running requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	config, err := util.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := util.NewLog().SetUp(config, log.DebugLevel)
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Error("failed to close file")
+		}
+	}(file)
+
+	log.Info("start nft event api")
+	mongoClient, ctx, cancel, err := db.Connect(config.MongoUri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close(mongoClient, ctx, cancel)
+	migration.Check(context.Background(), mongoClient, config)
+
+	chains := make(map[uint64]*eth.Client)
+	for _, chain := range config.ChainConfigs() {
+		ethClient, err := eth.DialChain(context.Background(), config, chain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ethClient.Close()
+		ethClient.StartHealthCheck(context.Background(), eth.DefaultHealthInterval)
+		chains[chain.ChainId] = ethClient
+	}
+	if config.AdminToken == "" {
+		log.Warn("ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+
+	server := &http.Server{
+		Addr:    config.ApiAddr,
+		Handler: api.NewServer(mongoClient, config, chains).Handler(),
+	}
+	go func() {
+		log.Infof("listening on %s", config.ApiAddr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt)
+	<-quit
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error(err)
+	}
+}
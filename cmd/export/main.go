@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"nft-event/db"
+	"nft-event/export"
+	"nft-event/model"
+	"nft-event/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	dataset := pflag.String("dataset", "events", "dataset to export: "+strings.Join(export.DatasetNames(), ", "))
+	format := pflag.String("format", "", "output format: "+strings.Join(export.Formats, ", ")+", defaults to the extension of the output or csv")
+	columns := pflag.StringSlice("columns", nil, "columns to export in order, all columns by default")
+	chain := pflag.Uint64("chain", 0, "chain id to export, all chains by default")
+	contract := pflag.String("contract", "", "contract address to export, all contracts by default")
+	fromBlock := pflag.Uint64("from-block", 0, "first block to export")
+	toBlock := pflag.Uint64("to-block", 0, "last block to export")
+	since := pflag.String("since", "", "RFC3339 time to export from")
+	until := pflag.String("until", "", "RFC3339 time to export until")
+	output := pflag.String("output", "-", "file to write, - for stdout")
+	compress := pflag.Bool("gzip", false, "gzip the output, implied by a .gz output")
+	snapshot := pflag.String("snapshot", "", "directory to write a gzipped jsonl snapshot of "+strings.Join(export.Snapshot, ", ")+" to, for the import command")
+
+	config, err := util.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := util.NewLog().SetUp(config, log.InfoLevel)
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Error("failed to close file")
+		}
+	}(file)
+	// stdout is the export, logs go to stderr
+	if *output == "-" && *snapshot == "" {
+		if file != nil {
+			log.SetOutput(io.MultiWriter(file, os.Stderr))
+		} else {
+			log.SetOutput(os.Stderr)
+		}
+	}
+
+	set, ok := export.Datasets[*dataset]
+	if !ok {
+		log.Fatalf("unknown dataset %q, expected one of %s", *dataset, strings.Join(export.DatasetNames(), ", "))
+	}
+	selected, err := set.Select(*columns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter := export.Filter{ChainId: *chain, FromBlock: *fromBlock, ToBlock: *toBlock}
+	if *contract != "" {
+		if !common.IsHexAddress(*contract) {
+			log.Fatalf("invalid contract %q", *contract)
+		}
+		filter.Contract = model.HexAddress(*contract)
+	}
+	if filter.Since, err = parseTime(*since); err != nil {
+		log.Fatal(err)
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := filter.Query(set); err != nil {
+		log.Fatal(err)
+	}
+
+	mongoClient, ctx, cancel, err := db.Connect(config.MongoUri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close(mongoClient, ctx, cancel)
+
+	// a snapshot holds whole datasets, only the chain and contract filter them
+	if *snapshot != "" {
+		if err := os.MkdirAll(*snapshot, 0755); err != nil {
+			log.Fatal(err)
+		}
+		filter = export.Filter{ChainId: filter.ChainId, Contract: filter.Contract}
+		for _, name := range export.SnapshotExport {
+			path := filepath.Join(*snapshot, name+".jsonl.gz")
+			rows, err := exportFile(mongoClient, config, export.Datasets[name], filter, export.Datasets[name].Columns, export.FormatJsonl, path, true)
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Infof("exported %d %s to %s", rows, name, path)
+		}
+		return
+	}
+
+	if *format == "" {
+		*format = export.FormatCsv
+		if ext := strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(*output, ".gz")), "."); ext != "" && *output != "-" {
+			*format = ext
+		}
+	}
+	rows, err := exportFile(mongoClient, config, set, filter, selected, *format, *output, *compress || strings.HasSuffix(*output, ".gz"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("exported %d %s as %s", rows, set.Name, *format)
+}
+
+// exportFile exports the columns of dataset to the file at path, stdout for -
+func exportFile(client *mongo.Client, config *util.Config, dataset export.Dataset, filter export.Filter, columns []export.Column, format, path string, compress bool) (int64, error) {
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return 0, err
+		}
+		defer func(f *os.File) {
+			if err := f.Close(); err != nil {
+				log.Error(err)
+			}
+		}(f)
+		out = f
+	}
+	var zipped *gzip.Writer
+	if compress {
+		zipped = gzip.NewWriter(out)
+		out = zipped
+	}
+	w, err := export.NewWriter(format, out, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := export.Export(context.Background(), client, config, dataset, filter, columns, w)
+	if err != nil {
+		return rows, err
+	}
+	if err := w.Close(); err != nil {
+		return rows, err
+	}
+	if zipped != nil {
+		return rows, zipped.Close()
+	}
+	return rows, nil
+}
+
+// parseTime parses an RFC3339 time, the zero time when it is empty
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
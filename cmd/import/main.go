@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"nft-event/db"
+	"nft-event/export"
+	"nft-event/indexer"
+	"nft-event/migration"
+	"nft-event/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// snapshotFile file of a snapshot and the dataset it holds, named after it like events.jsonl.gz
+type snapshotFile struct {
+	path    string
+	dataset export.Dataset
+}
+
+func main() {
+	validateOnly := pflag.Bool("validate", false, "only validate the files without importing anything")
+
+	config, err := util.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := util.NewLog().SetUp(config, log.InfoLevel)
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Error("failed to close file")
+		}
+	}(file)
+
+	files, err := snapshotFiles(pflag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// nothing is written unless every file is valid
+	for _, f := range files {
+		lines, err := read(f, func(r io.Reader) (int64, error) {
+			return export.Validate(f.dataset, config, r)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("%s: %d valid lines of %s", f.path, lines, f.dataset.Name)
+	}
+	if *validateOnly {
+		return
+	}
+
+	mongoClient, ctx, cancel, err := db.Connect(config.MongoUri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close(mongoClient, ctx, cancel)
+	migration.Check(context.Background(), mongoClient, config)
+
+	transactional, err := db.SupportsTransactions(mongoClient, context.Background())
+	if err != nil {
+		log.Error(err)
+	}
+
+	for _, f := range files {
+		lines, err := read(f, func(r io.Reader) (int64, error) {
+			return export.Import(context.Background(), mongoClient, config, f.dataset, r, transactional)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("%s: imported %d lines of %s", f.path, lines, f.dataset.Name)
+	}
+
+	// holdings and supply follow the imported tokens
+	if err := rebuild(context.Background(), mongoClient, config); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("import finished, the job resumes indexing from the restored checkpoints")
+}
+
+// snapshotFiles the files of paths in the order of export.Snapshot, the dataset of each taken from its name
+func snapshotFiles(paths []string) ([]snapshotFile, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no files given, expected the files of a snapshot like events.jsonl.gz")
+	}
+	order := make(map[string]int, len(export.Snapshot))
+	for i, name := range export.Snapshot {
+		order[name] = i
+	}
+	files := make([]snapshotFile, len(paths))
+	for i, path := range paths {
+		name := strings.SplitN(filepath.Base(path), ".", 2)[0]
+		if _, ok := order[name]; !ok {
+			return nil, fmt.Errorf("%s is not a file of %s", path, strings.Join(export.Snapshot, ", "))
+		}
+		files[i] = snapshotFile{path: path, dataset: export.Datasets[name]}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return order[files[i].dataset.Name] < order[files[j].dataset.Name]
+	})
+	return files, nil
+}
+
+// read opens the file, gunzipping it when it ends in .gz, and passes it to fn
+func read(f snapshotFile, fn func(r io.Reader) (int64, error)) (int64, error) {
+	opened, err := os.Open(f.path)
+	if err != nil {
+		return 0, err
+	}
+	defer func(opened *os.File) {
+		err := opened.Close()
+		if err != nil {
+			return
+		}
+	}(opened)
+
+	var r io.Reader = opened
+	if strings.HasSuffix(f.path, ".gz") {
+		zipped, err := gzip.NewReader(opened)
+		if err != nil {
+			return 0, err
+		}
+		r = zipped
+	}
+	return fn(r)
+}
+
+func rebuild(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	if err := indexer.RebuildHoldings(ctx, client, config); err != nil {
+		return err
+	}
+	return indexer.CountSupply(ctx, client, config)
+}
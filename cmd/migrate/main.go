@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"nft-event/db"
+	"nft-event/migration"
+	"nft-event/util"
+	"os"
+)
+
+func main() {
+	status := pflag.Bool("status", false, "show the schema version and verify indexes without changing anything")
+
+	config, err := util.LoadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := util.NewLog().SetUp(config, log.InfoLevel)
+	defer func(file *os.File) {
+		err := file.Close()
+		if err != nil {
+			log.Error("failed to close file")
+		}
+	}(file)
+
+	mongoClient, ctx, cancel, err := db.Connect(config.MongoUri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close(mongoClient, ctx, cancel)
+
+	version, err := migration.Version(context.Background(), mongoClient, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("schema version %d, latest %d", version, migration.Latest())
+
+	if *status {
+		for _, m := range migration.Pending(version) {
+			log.Infof("pending migration %d: %s", m.Version, m.Description)
+		}
+		if err := migration.VerifyIndexes(context.Background(), mongoClient, config.MongoDb, migration.Indexes(config)); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// data first, unique indexes can only be built on migrated data
+	if err := migration.Migrate(context.Background(), mongoClient, config); err != nil {
+		log.Fatal(err)
+	}
+	if err := migration.EnsureIndexes(context.Background(), mongoClient, config.MongoDb, migration.Indexes(config)); err != nil {
+		log.Fatal(err)
+	}
+	log.Info("migration finished")
+}
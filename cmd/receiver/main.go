@@ -3,19 +3,25 @@ package main
 import (
 	"context"
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	log "github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
-	"math/big"
+	"go.mongodb.org/mongo-driver/mongo"
+	"nft-event/contracts"
 	"nft-event/db"
+	"nft-event/eth"
+	"nft-event/indexer"
+	"nft-event/migration"
+	"nft-event/model"
 	"nft-event/service"
 	"nft-event/util"
+	"sync"
 	"time"
 )
 
+// resubscribeDelay wait time before retrying a failed subscription
+const resubscribeDelay = 5 * time.Second
+
 func main() {
 	log.SetFormatter(&log.TextFormatter{
 		FullTimestamp: true,
@@ -28,128 +34,188 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ethClient, err := ethclient.Dial(config.EthUri)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	log.Info("start nft event receiver")
 
-	// current block
-	header, err := ethClient.HeaderByNumber(context.Background(), nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Infof("current block number: %s\n", header.Number.String())
-
 	mongoClient, ctx, cancel, err := db.Connect(config.MongoUri)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	defer db.Close(mongoClient, ctx, cancel)
+	migration.Check(context.Background(), mongoClient, config)
 
 	log.Info("connected to db successfully")
 
-	addresses, nftMap, err := service.GetApprovedNfts(ethClient, mongoClient, config)
+	transactional, err := db.SupportsTransactions(mongoClient, context.Background())
 	if err != nil {
-		log.Fatal(err)
+		log.Error(err)
 	}
 
-	// subscribe event
-	query := ethereum.FilterQuery{
-		Addresses: addresses,
+	// every chain is received on its own subscription
+	var wg sync.WaitGroup
+	for _, chain := range config.ChainConfigs() {
+		ethClient, err := eth.DialChain(context.Background(), config, chain)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ethClient.Close()
+		ethClient.StartHealthCheck(context.Background(), eth.DefaultHealthInterval)
+		ethClient.StartUsageReport(context.Background(), eth.DefaultUsageInterval)
+
+		wg.Add(1)
+		go func(ethClient *eth.Client, chain util.ChainConfig) {
+			defer wg.Done()
+			receive(ethClient, mongoClient, config, chain, transactional)
+		}(ethClient, chain)
 	}
+	wg.Wait()
+}
 
-	logs := make(chan types.Log)
-	sub, err := ethClient.SubscribeFilterLogs(context.Background(), query, logs)
+// receive stores the events of the contracts of chain as they are emitted
+func receive(ethClient *eth.Client, mongoClient *mongo.Client, config *util.Config, chain util.ChainConfig, transactional bool) {
+	// current block
+	header, err := ethClient.HeaderByNumber(context.Background(), nil)
 	if err != nil {
-		log.Fatal(err)
+		log.Error(err)
+	} else {
+		log.Infof("%s: current block number: %s\n", chain.Label(), header.Number.String())
 	}
 
-	// Transfer(from, to, tokenId)
-	nftTransferSig := []byte("Transfer(address,address,uint256)")
-	nftTransferSigHash := crypto.Keccak256Hash(nftTransferSig)
+	// contracts are reloaded when the approved collection changes, the subscription follows them
+	var addresses []common.Address
+	var nftMap map[common.Address]*contracts.Token
+	var settings map[common.Address]model.NftSettings
+	interval := time.Duration(config.ReloadSeconds) * time.Second
+	updates := service.WatchApprovedNfts(context.Background(), ethClient, mongoClient, config, chain, interval)
 
-	// Approval(owner, approved, tokenId)
-	nftApproveSig := []byte("Approval(address,address,uint256)")
-	nftApproveSigHash := crypto.Keccak256Hash(nftApproveSig)
+	logs := make(chan types.Log)
+	var sub ethereum.Subscription
+	var subErr <-chan error
 
-	var doc interface{}
 	for {
 		select {
-		case err := <-sub.Err():
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			// contracts with a confirmation depth are left to the job, the receiver stores unconfirmed blocks
+			addresses, nftMap, settings = nil, make(map[common.Address]*contracts.Token), update.Settings
+			for _, address := range update.Addresses {
+				if update.Settings[address].ConfirmationDepth(chain.Confirmations) == 0 {
+					addresses = append(addresses, address)
+					nftMap[address] = update.NftMap[address]
+				}
+			}
+			log.Infof("%s: %d of %d contracts", chain.Label(), len(addresses), len(update.Addresses))
+			if sub != nil {
+				sub.Unsubscribe()
+				sub, subErr = nil, nil
+			}
+			// a query without addresses would match every log of the chain
+			if len(addresses) > 0 {
+				sub = subscribe(ethClient, ethereum.FilterQuery{Addresses: addresses}, logs)
+				subErr = sub.Err()
+			}
+		case err := <-subErr:
 			log.Error(err)
+			sub.Unsubscribe()
+			sub = subscribe(ethClient, ethereum.FilterQuery{Addresses: addresses}, logs)
+			subErr = sub.Err()
 		case vLog := <-logs:
 
-			log.Infof("block number: %d\n", vLog.BlockNumber)
+			log.Infof("%s: block number: %d\n", chain.Label(), vLog.BlockNumber)
 
 			nftAddress := vLog.Address
 			log.Infof("nft address: %s\n", nftAddress.String())
 
 			switch vLog.Topics[0].Hex() {
-			case nftTransferSigHash.Hex():
+			case model.TransferSigHash.Hex():
 				log.Infof("transfer event\n")
 				log.Infof("tx: %s\n", vLog.TxHash.String())
 
-				from := "0x" + vLog.Topics[1].Hex()[26:]
-				to := "0x" + vLog.Topics[2].Hex()[26:]
-
-				tokenId, err := util.ConvertHexToInt(vLog.Topics[3].Hex())
+				transfer, err := model.NewTransfer(chain.ChainId, vLog)
 				if err != nil {
-					log.Info("failed to convert")
+					log.Info(err)
+					break
 				}
 
-				doc = bson.D{
-					{"tx", vLog.TxHash.String()},
-					{"from", from},
-					{"to", to},
-					{"tokenId", tokenId},
-					{"createdAt", time.Now()},
+				if _, ok := nftMap[nftAddress]; !ok {
+					log.Infof("address is not in nft map: %s\n", nftAddress.String())
+					break
+				}
+				if vLog.BlockNumber < settings[nftAddress].StartAt(chain.StartBlock) {
+					log.Infof("block %d is before the start block of %s\n", vLog.BlockNumber, nftAddress.String())
+					break
 				}
 
-				_, err = db.InsertOne(mongoClient, context.Background(), config.MongoDb, config.MongoEvent, doc)
+				// the receiver of this transfer owns the token once the logs before it are applied
+				writes, err := indexer.TransferWrites(transfer, model.NewToken(transfer), config)
 				if err != nil {
 					log.Error(err)
+					break
 				}
 
-				// check owner of this token
-				if instance, ok := nftMap[nftAddress]; ok {
-					owner, err := instance.OwnerOf(&bind.CallOpts{}, big.NewInt(tokenId))
-					if err != nil {
-						log.Infof("failed to owner of token: %s, id: %d", addresses[0], 1)
-						break
-					}
-
-					doc = bson.D{
-						{"nftAddress", nftAddress.String()},
-						{"tokenId", tokenId},
-						{"owner", owner.String()},
-						{"updatedAt", time.Now()},
-					}
-
-					_, err = db.UpsertOne(mongoClient, context.Background(), config.MongoDb, config.MongoNft, doc, bson.M{"nftAddress": nftAddress, "tokenId": tokenId})
-					if err != nil {
-						log.Info(err)
-					}
-				} else {
-					log.Infof("address is not in nft map: %s\n", nftAddress.String())
+				batch := db.NewBatch()
+				batch.Add(writes...)
+				batch.Add(indexer.StaleHoldingWrites(chain.ChainId, model.TransferHoldings(transfer), config)...)
+				if err := db.Commit(mongoClient, context.Background(), config.MongoDb, batch, transactional); err != nil {
+					log.Error(err)
+					break
+				}
+				if err := indexer.RefreshStaleHoldings(context.Background(), mongoClient, config, chain.ChainId); err != nil {
+					log.Error(err)
 				}
-			case nftApproveSigHash.Hex():
+			case model.ApprovalSigHash.Hex(), model.ApprovalForAllSigHash.Hex():
 				log.Infof("approval event\n")
 				log.Infof("tx: %s\n", vLog.TxHash.String())
 
-				ownerAddress := "0x" + vLog.Topics[1].Hex()[26:]
-				log.Infof("owner address: %s\n", ownerAddress)
+				approval, err := model.NewApproval(chain.ChainId, vLog)
+				if err != nil {
+					log.Info(err)
+					break
+				}
+				if _, ok := nftMap[nftAddress]; !ok {
+					log.Infof("address is not in nft map: %s\n", nftAddress.String())
+					break
+				}
+				log.Infof("owner address: %s\n", approval.Wallet)
+				log.Infof("approved address: %s\n", approval.Counterparty)
 
-				approvedAddress := "0x" + vLog.Topics[2].Hex()[26:]
-				log.Infof("approved address: %s\n", approvedAddress)
+				writes, err := indexer.ActivityWrites([]model.Activity{*approval}, config)
+				if err != nil {
+					log.Error(err)
+					break
+				}
+				batch := db.NewBatch()
+				batch.Add(writes...)
+				if err := db.Commit(mongoClient, context.Background(), config.MongoDb, batch, transactional); err != nil {
+					log.Error(err)
+				}
+			case model.MetadataUpdateSigHash.Hex(), model.BatchMetadataUpdateSigHash.Hex():
+				log.Infof("metadata update event\n")
+				log.Infof("tx: %s\n", vLog.TxHash.String())
 
-				value, err := util.ConvertHexToInt(vLog.Topics[3].Hex())
+				update, err := model.NewMetadataUpdate(chain.ChainId, vLog)
 				if err != nil {
-					log.Info("failed to convert")
+					log.Info(err)
+					break
+				}
+				if _, ok := nftMap[nftAddress]; !ok {
+					log.Infof("address is not in nft map: %s\n", nftAddress.String())
+					break
+				}
+
+				// the job refetches the metadata of the tokens on its next run
+				writes, err := indexer.MetadataUpdateWrites(update, config)
+				if err != nil {
+					log.Error(err)
+					break
+				}
+				batch := db.NewBatch()
+				batch.Add(writes...)
+				if err := db.Commit(mongoClient, context.Background(), config.MongoDb, batch, transactional); err != nil {
+					log.Error(err)
 				}
-				log.Infof("tokenId: %d\n", value)
 			default:
 				log.Infof("other event\n")
 				log.Infof("event Hash: %v\n", vLog.Topics[0].Hex())
@@ -157,3 +223,16 @@ func main() {
 		}
 	}
 }
+
+// subscribe subscribes to the filter query, retrying on the next healthy endpoint until it succeeds
+func subscribe(ethClient *eth.Client, query ethereum.FilterQuery, logs chan types.Log) ethereum.Subscription {
+	for {
+		sub, err := ethClient.SubscribeFilterLogs(context.Background(), query, logs)
+		if err == nil {
+			log.Info("subscribed to event logs")
+			return sub
+		}
+		log.Error(err)
+		time.Sleep(resubscribeDelay)
+	}
+}
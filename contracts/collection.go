@@ -0,0 +1,287 @@
+// Code generated - DO NOT EDIT.
+// This file is a generated binding and any manual changes will be lost.
+
+package contracts
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var (
+	_ = errors.New
+	_ = big.NewInt
+	_ = strings.NewReader
+	_ = ethereum.NotFound
+	_ = bind.Bind
+	_ = common.Big1
+	_ = types.BloomLookup
+	_ = event.NewSubscription
+)
+
+// CollectionMetaData contains all meta data concerning the Collection contract.
+var CollectionMetaData = &bind.MetaData{
+	ABI: "[{\"inputs\":[],\"name\":\"contractURI\",\"outputs\":[{\"internalType\":\"string\",\"name\":\"\",\"type\":\"string\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[],\"name\":\"totalSupply\",\"outputs\":[{\"internalType\":\"uint256\",\"name\":\"\",\"type\":\"uint256\"}],\"stateMutability\":\"view\",\"type\":\"function\"},{\"inputs\":[{\"internalType\":\"uint256\",\"name\":\"tokenId\",\"type\":\"uint256\"},{\"internalType\":\"uint256\",\"name\":\"salePrice\",\"type\":\"uint256\"}],\"name\":\"royaltyInfo\",\"outputs\":[{\"internalType\":\"address\",\"name\":\"receiver\",\"type\":\"address\"},{\"internalType\":\"uint256\",\"name\":\"royaltyAmount\",\"type\":\"uint256\"}],\"stateMutability\":\"view\",\"type\":\"function\"}]",
+}
+
+// CollectionABI is the input ABI used to generate the binding from.
+// Deprecated: Use CollectionMetaData.ABI instead.
+var CollectionABI = CollectionMetaData.ABI
+
+// Collection is an auto generated Go binding around an Ethereum contract.
+type Collection struct {
+	CollectionCaller     // Read-only binding to the contract
+	CollectionTransactor // Write-only binding to the contract
+	CollectionFilterer   // Log filterer for contract events
+}
+
+// CollectionCaller is an auto generated read-only Go binding around an Ethereum contract.
+type CollectionCaller struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// CollectionTransactor is an auto generated write-only Go binding around an Ethereum contract.
+type CollectionTransactor struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// CollectionFilterer is an auto generated log filtering Go binding around an Ethereum contract events.
+type CollectionFilterer struct {
+	contract *bind.BoundContract // Generic contract wrapper for the low level calls
+}
+
+// CollectionSession is an auto generated Go binding around an Ethereum contract,
+// with pre-set call and transact options.
+type CollectionSession struct {
+	Contract     *Collection       // Generic contract binding to set the session for
+	CallOpts     bind.CallOpts     // Call options to use throughout this session
+	TransactOpts bind.TransactOpts // Transaction auth options to use throughout this session
+}
+
+// CollectionCallerSession is an auto generated read-only Go binding around an Ethereum contract,
+// with pre-set call options.
+type CollectionCallerSession struct {
+	Contract *CollectionCaller // Generic contract caller binding to set the session for
+	CallOpts bind.CallOpts     // Call options to use throughout this session
+}
+
+// CollectionTransactorSession is an auto generated write-only Go binding around an Ethereum contract,
+// with pre-set transact options.
+type CollectionTransactorSession struct {
+	Contract     *CollectionTransactor // Generic contract transactor binding to set the session for
+	TransactOpts bind.TransactOpts     // Transaction auth options to use throughout this session
+}
+
+// CollectionRaw is an auto generated low-level Go binding around an Ethereum contract.
+type CollectionRaw struct {
+	Contract *Collection // Generic contract binding to access the raw methods on
+}
+
+// CollectionCallerRaw is an auto generated low-level read-only Go binding around an Ethereum contract.
+type CollectionCallerRaw struct {
+	Contract *CollectionCaller // Generic read-only contract binding to access the raw methods on
+}
+
+// CollectionTransactorRaw is an auto generated low-level write-only Go binding around an Ethereum contract.
+type CollectionTransactorRaw struct {
+	Contract *CollectionTransactor // Generic write-only contract binding to access the raw methods on
+}
+
+// NewCollection creates a new instance of Collection, bound to a specific deployed contract.
+func NewCollection(address common.Address, backend bind.ContractBackend) (*Collection, error) {
+	contract, err := bindCollection(address, backend, backend, backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Collection{CollectionCaller: CollectionCaller{contract: contract}, CollectionTransactor: CollectionTransactor{contract: contract}, CollectionFilterer: CollectionFilterer{contract: contract}}, nil
+}
+
+// NewCollectionCaller creates a new read-only instance of Collection, bound to a specific deployed contract.
+func NewCollectionCaller(address common.Address, caller bind.ContractCaller) (*CollectionCaller, error) {
+	contract, err := bindCollection(address, caller, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionCaller{contract: contract}, nil
+}
+
+// NewCollectionTransactor creates a new write-only instance of Collection, bound to a specific deployed contract.
+func NewCollectionTransactor(address common.Address, transactor bind.ContractTransactor) (*CollectionTransactor, error) {
+	contract, err := bindCollection(address, nil, transactor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionTransactor{contract: contract}, nil
+}
+
+// NewCollectionFilterer creates a new log filterer instance of Collection, bound to a specific deployed contract.
+func NewCollectionFilterer(address common.Address, filterer bind.ContractFilterer) (*CollectionFilterer, error) {
+	contract, err := bindCollection(address, nil, nil, filterer)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionFilterer{contract: contract}, nil
+}
+
+// bindCollection binds a generic wrapper to an already deployed contract.
+func bindCollection(address common.Address, caller bind.ContractCaller, transactor bind.ContractTransactor, filterer bind.ContractFilterer) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(CollectionABI))
+	if err != nil {
+		return nil, err
+	}
+	return bind.NewBoundContract(address, parsed, caller, transactor, filterer), nil
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Collection *CollectionRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Collection.Contract.CollectionCaller.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Collection *CollectionRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Collection.Contract.CollectionTransactor.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Collection *CollectionRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Collection.Contract.CollectionTransactor.contract.Transact(opts, method, params...)
+}
+
+// Call invokes the (constant) contract method with params as input values and
+// sets the output to result. The result type might be a single field for simple
+// returns, a slice of interfaces for anonymous returns and a struct for named
+// returns.
+func (_Collection *CollectionCallerRaw) Call(opts *bind.CallOpts, result *[]interface{}, method string, params ...interface{}) error {
+	return _Collection.Contract.contract.Call(opts, result, method, params...)
+}
+
+// Transfer initiates a plain transaction to move funds to the contract, calling
+// its default method if one is available.
+func (_Collection *CollectionTransactorRaw) Transfer(opts *bind.TransactOpts) (*types.Transaction, error) {
+	return _Collection.Contract.contract.Transfer(opts)
+}
+
+// Transact invokes the (paid) contract method with params as input values.
+func (_Collection *CollectionTransactorRaw) Transact(opts *bind.TransactOpts, method string, params ...interface{}) (*types.Transaction, error) {
+	return _Collection.Contract.contract.Transact(opts, method, params...)
+}
+
+// ContractURI is a free data retrieval call binding the contract method 0xe8a3d485.
+//
+// Solidity: function contractURI() view returns(string)
+func (_Collection *CollectionCaller) ContractURI(opts *bind.CallOpts) (string, error) {
+	var out []interface{}
+	err := _Collection.contract.Call(opts, &out, "contractURI")
+
+	if err != nil {
+		return *new(string), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(string)).(*string)
+
+	return out0, err
+
+}
+
+// ContractURI is a free data retrieval call binding the contract method 0xe8a3d485.
+//
+// Solidity: function contractURI() view returns(string)
+func (_Collection *CollectionSession) ContractURI() (string, error) {
+	return _Collection.Contract.ContractURI(&_Collection.CallOpts)
+}
+
+// ContractURI is a free data retrieval call binding the contract method 0xe8a3d485.
+//
+// Solidity: function contractURI() view returns(string)
+func (_Collection *CollectionCallerSession) ContractURI() (string, error) {
+	return _Collection.Contract.ContractURI(&_Collection.CallOpts)
+}
+
+// RoyaltyInfo is a free data retrieval call binding the contract method 0x2a55205a.
+//
+// Solidity: function royaltyInfo(uint256 tokenId, uint256 salePrice) view returns(address receiver, uint256 royaltyAmount)
+func (_Collection *CollectionCaller) RoyaltyInfo(opts *bind.CallOpts, tokenId *big.Int, salePrice *big.Int) (struct {
+	Receiver      common.Address
+	RoyaltyAmount *big.Int
+}, error) {
+	var out []interface{}
+	err := _Collection.contract.Call(opts, &out, "royaltyInfo", tokenId, salePrice)
+
+	outstruct := new(struct {
+		Receiver      common.Address
+		RoyaltyAmount *big.Int
+	})
+	if err != nil {
+		return *outstruct, err
+	}
+
+	outstruct.Receiver = *abi.ConvertType(out[0], new(common.Address)).(*common.Address)
+	outstruct.RoyaltyAmount = *abi.ConvertType(out[1], new(*big.Int)).(**big.Int)
+
+	return *outstruct, err
+
+}
+
+// RoyaltyInfo is a free data retrieval call binding the contract method 0x2a55205a.
+//
+// Solidity: function royaltyInfo(uint256 tokenId, uint256 salePrice) view returns(address receiver, uint256 royaltyAmount)
+func (_Collection *CollectionSession) RoyaltyInfo(tokenId *big.Int, salePrice *big.Int) (struct {
+	Receiver      common.Address
+	RoyaltyAmount *big.Int
+}, error) {
+	return _Collection.Contract.RoyaltyInfo(&_Collection.CallOpts, tokenId, salePrice)
+}
+
+// RoyaltyInfo is a free data retrieval call binding the contract method 0x2a55205a.
+//
+// Solidity: function royaltyInfo(uint256 tokenId, uint256 salePrice) view returns(address receiver, uint256 royaltyAmount)
+func (_Collection *CollectionCallerSession) RoyaltyInfo(tokenId *big.Int, salePrice *big.Int) (struct {
+	Receiver      common.Address
+	RoyaltyAmount *big.Int
+}, error) {
+	return _Collection.Contract.RoyaltyInfo(&_Collection.CallOpts, tokenId, salePrice)
+}
+
+// TotalSupply is a free data retrieval call binding the contract method 0x18160ddd.
+//
+// Solidity: function totalSupply() view returns(uint256)
+func (_Collection *CollectionCaller) TotalSupply(opts *bind.CallOpts) (*big.Int, error) {
+	var out []interface{}
+	err := _Collection.contract.Call(opts, &out, "totalSupply")
+
+	if err != nil {
+		return *new(*big.Int), err
+	}
+
+	out0 := *abi.ConvertType(out[0], new(*big.Int)).(**big.Int)
+
+	return out0, err
+
+}
+
+// TotalSupply is a free data retrieval call binding the contract method 0x18160ddd.
+//
+// Solidity: function totalSupply() view returns(uint256)
+func (_Collection *CollectionSession) TotalSupply() (*big.Int, error) {
+	return _Collection.Contract.TotalSupply(&_Collection.CallOpts)
+}
+
+// TotalSupply is a free data retrieval call binding the contract method 0x18160ddd.
+//
+// Solidity: function totalSupply() view returns(uint256)
+func (_Collection *CollectionCallerSession) TotalSupply() (*big.Int, error) {
+	return _Collection.Contract.TotalSupply(&_Collection.CallOpts)
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Write single write of a batch
+type Write struct {
+	Collection string
+	Model      mongo.WriteModel
+}
+
+// Batch writes committed together, in order within each collection
+type Batch struct {
+	writes []Write
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Add(writes ...Write) {
+	b.writes = append(b.writes, writes...)
+}
+
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
+
+// SupportsTransactions reports whether the server is a replica set member or a mongos, which can run multi-document transactions
+func SupportsTransactions(client *mongo.Client, ctx context.Context) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{"isMaster", 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// Commit writes the batch with one bulk write per collection.
+// With transactional the whole batch is committed in a single transaction, otherwise collections are written one after another.
+func Commit(client *mongo.Client, ctx context.Context, dataBase string, batch *Batch, transactional bool) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	var order []string
+	models := make(map[string][]mongo.WriteModel)
+	for _, w := range batch.writes {
+		if _, ok := models[w.Collection]; !ok {
+			order = append(order, w.Collection)
+		}
+		models[w.Collection] = append(models[w.Collection], w.Model)
+	}
+
+	write := func(ctx context.Context) error {
+		for _, col := range order {
+			collection := client.Database(dataBase).Collection(col)
+			if _, err := collection.BulkWrite(ctx, models[col], options.BulkWrite().SetOrdered(true)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !transactional {
+		return write(ctx)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, write(sc)
+	})
+	return err
+}
@@ -61,3 +61,53 @@ func UpsertOne(client *mongo.Client, ctx context.Context, dataBase, col string,
 	result, err := collection.UpdateOne(ctx, filter, update, opts)
 	return result, err
 }
+
+// NewerPipeline update pipeline setting the fields of doc only if the log at blockNumber and logIndex is not older than the stored one.
+// Documents keep the position of the log that last wrote them in blockNumber and logIndex,
+// a createdAt field in doc is only set when the document is created.
+func NewerPipeline(doc bson.D, blockNumber uint64, logIndex uint) mongo.Pipeline {
+	isNewer := bson.M{"$or": bson.A{
+		bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$blockNumber", -1}}, int64(blockNumber)}},
+		bson.M{"$and": bson.A{
+			bson.M{"$eq": bson.A{"$blockNumber", int64(blockNumber)}},
+			bson.M{"$lte": bson.A{bson.M{"$ifNull": bson.A{"$logIndex", -1}}, int64(logIndex)}},
+		}},
+	}}
+
+	set := bson.D{}
+	for _, e := range doc {
+		if e.Key == "createdAt" {
+			set = append(set, bson.E{Key: e.Key, Value: bson.M{"$ifNull": bson.A{"$createdAt", e.Value}}})
+			continue
+		}
+		set = append(set, bson.E{Key: e.Key, Value: bson.M{"$cond": bson.A{isNewer, bson.M{"$literal": e.Value}, "$" + e.Key}}})
+	}
+	set = append(set,
+		bson.E{Key: "blockNumber", Value: bson.M{"$cond": bson.A{isNewer, int64(blockNumber), "$blockNumber"}}},
+		bson.E{Key: "logIndex", Value: bson.M{"$cond": bson.A{isNewer, int64(logIndex), "$logIndex"}}},
+	)
+	return mongo.Pipeline{{{Key: "$set", Value: set}}}
+}
+
+func DeleteOne(client *mongo.Client, ctx context.Context, dataBase, col string, filter interface{}) (*mongo.DeleteResult, error) {
+	collection := client.Database(dataBase).Collection(col)
+	result, err := collection.DeleteOne(ctx, filter)
+	return result, err
+}
+
+func DeleteMany(client *mongo.Client, ctx context.Context, dataBase, col string, filter interface{}) (*mongo.DeleteResult, error) {
+	collection := client.Database(dataBase).Collection(col)
+	result, err := collection.DeleteMany(ctx, filter)
+	return result, err
+}
+
+// ToDoc converts a model struct into an ordered document, honoring its bson tags
+func ToDoc(v interface{}) (bson.D, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.D
+	err = bson.Unmarshal(data, &doc)
+	return doc, err
+}
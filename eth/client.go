@@ -0,0 +1,393 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxHeadLag number of blocks an endpoint may fall behind the best known head before it is demoted
+	DefaultMaxHeadLag uint64 = 5
+	// DefaultHealthInterval how often endpoints are probed
+	DefaultHealthInterval = 15 * time.Second
+	// DefaultUsageInterval how often the rpc usage is reported
+	DefaultUsageInterval = time.Hour
+	// healthTimeout timeout of a single health probe
+	healthTimeout = 5 * time.Second
+)
+
+// ErrNoEndpoint is returned when no endpoint was configured
+var ErrNoEndpoint = errors.New("no rpc endpoint configured")
+
+// Client ethereum rpc client spreading calls over several endpoints.
+// Calls go to the best scored endpoint and fail over to the next one on node errors.
+type Client struct {
+	endpoints  []*endpoint
+	maxHeadLag uint64
+	policy     *Policy
+}
+
+// Dial creates a client for the given endpoints. Unreachable endpoints do not fail the dial,
+// they are retried by the health check and on every call.
+func Dial(ctx context.Context, uris []string, maxHeadLag uint64) (*Client, error) {
+	if len(uris) == 0 {
+		return nil, ErrNoEndpoint
+	}
+
+	c := &Client{maxHeadLag: maxHeadLag}
+	for _, uri := range uris {
+		c.endpoints = append(c.endpoints, newEndpoint(uri))
+	}
+
+	c.CheckHealth(ctx)
+	return c, nil
+}
+
+// Close closes all endpoint connections
+func (c *Client) Close() {
+	for _, e := range c.endpoints {
+		e.close()
+	}
+}
+
+// StartHealthCheck probes all endpoints every interval until ctx is done
+func (c *Client) StartHealthCheck(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.CheckHealth(ctx)
+			}
+		}
+	}()
+}
+
+// StartUsageReport logs the rpc usage per method every interval until ctx is done
+func (c *Client) StartUsageReport(ctx context.Context, interval time.Duration) {
+	if c.policy == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.policy.LogUsage()
+			}
+		}
+	}()
+}
+
+// CheckHealth queries the head of every endpoint and demotes the ones lagging behind
+func (c *Client) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range c.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+			defer cancel()
+
+			client, err := e.conn(ctx)
+			if err != nil {
+				log.Warnf("rpc endpoint %s unreachable: %v", e.uri, err)
+				return
+			}
+
+			start := time.Now()
+			head, err := client.BlockNumber(ctx)
+			if err != nil {
+				log.Warnf("rpc endpoint %s unhealthy: %v", e.uri, err)
+				e.failure(client, err)
+				return
+			}
+			e.success(time.Since(start))
+			e.setHead(head)
+		}(e)
+	}
+	wg.Wait()
+
+	var best uint64
+	for _, e := range c.endpoints {
+		if s := e.status(); s.Healthy && s.Head > best {
+			best = s.Head
+		}
+	}
+	for _, e := range c.endpoints {
+		s := e.status()
+		lagging := s.Healthy && best-s.Head > c.maxHeadLag
+		if lagging && !s.Lagging {
+			log.Warnf("rpc endpoint %s is %d blocks behind, demoted", e.uri, best-s.Head)
+		}
+		e.setLagging(lagging)
+	}
+}
+
+// SetPolicy applies rate limits, retries and the compute unit budget of policy to all calls
+func (c *Client) SetPolicy(policy *Policy) {
+	c.policy = policy
+}
+
+// Policy returns the policy of the client, nil if calls are unlimited
+func (c *Client) Policy() *Policy {
+	return c.policy
+}
+
+// Status returns the health of all endpoints, best first
+func (c *Client) Status() []EndpointStatus {
+	var statuses []EndpointStatus
+	for _, e := range c.ranked() {
+		statuses = append(statuses, e.status())
+	}
+	return statuses
+}
+
+// ranked endpoints ordered by score
+func (c *Client) ranked() []*endpoint {
+	ranked := make([]*endpoint, len(c.endpoints))
+	copy(ranked, c.endpoints)
+	scores := make(map[*endpoint]float64, len(ranked))
+	for _, e := range ranked {
+		scores[e] = e.score()
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i]] < scores[ranked[j]]
+	})
+	return ranked
+}
+
+// do runs fn under the client policy
+func (c *Client) do(ctx context.Context, method string, fn func(*ethclient.Client) error) error {
+	if c.policy == nil {
+		return c.try(ctx, method, fn)
+	}
+	return c.policy.run(ctx, method, func() error {
+		return c.try(ctx, method, fn)
+	})
+}
+
+// try runs fn against endpoints in score order until one answers
+func (c *Client) try(ctx context.Context, method string, fn func(*ethclient.Client) error) error {
+	var lastErr error
+	for _, e := range c.ranked() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		client, err := e.conn(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		start := time.Now()
+		err = fn(client)
+		// http endpoints can not serve subscriptions, try the next one
+		if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+			lastErr = err
+			continue
+		}
+		if err == nil || !isEndpointError(ctx, err) {
+			e.success(time.Since(start))
+			return err
+		}
+
+		log.Warnf("%s failed on %s: %v", method, e.uri, err)
+		e.failure(client, err)
+		lastErr = err
+	}
+	return fmt.Errorf("%s failed on all endpoints: %w", method, lastErr)
+}
+
+// isEndpointError reports whether err was caused by the node rather than by the request itself
+func isEndpointError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return true
+	}
+
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.ErrorCode() {
+		// limit exceeded, internal error
+		case -32005, -32603:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// ChainID retrieves the chain id
+func (c *Client) ChainID(ctx context.Context) (id *big.Int, err error) {
+	err = c.do(ctx, "eth_chainId", func(client *ethclient.Client) error {
+		id, err = client.ChainID(ctx)
+		return err
+	})
+	return id, err
+}
+
+// BlockNumber returns the most recent block number
+func (c *Client) BlockNumber(ctx context.Context) (number uint64, err error) {
+	err = c.do(ctx, "eth_blockNumber", func(client *ethclient.Client) error {
+		number, err = client.BlockNumber(ctx)
+		return err
+	})
+	return number, err
+}
+
+// HeaderByNumber returns a block header, latest if number is nil
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (header *types.Header, err error) {
+	err = c.do(ctx, "eth_getBlockByNumber", func(client *ethclient.Client) error {
+		header, err = client.HeaderByNumber(ctx, number)
+		return err
+	})
+	return header, err
+}
+
+// HeaderByHash returns the header of the block with the given hash
+func (c *Client) HeaderByHash(ctx context.Context, hash common.Hash) (header *types.Header, err error) {
+	err = c.do(ctx, "eth_getBlockByHash", func(client *ethclient.Client) error {
+		header, err = client.HeaderByHash(ctx, hash)
+		return err
+	})
+	return header, err
+}
+
+// BlockByNumber returns a full block, latest if number is nil
+func (c *Client) BlockByNumber(ctx context.Context, number *big.Int) (block *types.Block, err error) {
+	err = c.do(ctx, "eth_getBlockByNumber", func(client *ethclient.Client) error {
+		block, err = client.BlockByNumber(ctx, number)
+		return err
+	})
+	return block, err
+}
+
+// TransactionByHash returns the transaction with the given hash
+func (c *Client) TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
+	err = c.do(ctx, "eth_getTransactionByHash", func(client *ethclient.Client) error {
+		tx, isPending, err = client.TransactionByHash(ctx, hash)
+		return err
+	})
+	return tx, isPending, err
+}
+
+// TransactionReceipt returns the receipt of a mined transaction
+func (c *Client) TransactionReceipt(ctx context.Context, txHash common.Hash) (receipt *types.Receipt, err error) {
+	err = c.do(ctx, "eth_getTransactionReceipt", func(client *ethclient.Client) error {
+		receipt, err = client.TransactionReceipt(ctx, txHash)
+		return err
+	})
+	return receipt, err
+}
+
+// FilterLogs executes a filter query
+func (c *Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) (logs []types.Log, err error) {
+	err = c.do(ctx, "eth_getLogs", func(client *ethclient.Client) error {
+		logs, err = client.FilterLogs(ctx, query)
+		return err
+	})
+	return logs, err
+}
+
+// SubscribeFilterLogs subscribes to the results of a streaming filter query on the best endpoint supporting subscriptions
+func (c *Client) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (sub ethereum.Subscription, err error) {
+	err = c.do(ctx, "eth_subscribe", func(client *ethclient.Client) error {
+		sub, err = client.SubscribeFilterLogs(ctx, query, ch)
+		return err
+	})
+	return sub, err
+}
+
+// CodeAt returns the contract code of the given account
+func (c *Client) CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) (code []byte, err error) {
+	err = c.do(ctx, "eth_getCode", func(client *ethclient.Client) error {
+		code, err = client.CodeAt(ctx, account, blockNumber)
+		return err
+	})
+	return code, err
+}
+
+// CallContract executes a message call transaction
+func (c *Client) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) (result []byte, err error) {
+	err = c.do(ctx, "eth_call", func(client *ethclient.Client) error {
+		result, err = client.CallContract(ctx, msg, blockNumber)
+		return err
+	})
+	return result, err
+}
+
+// PendingCodeAt returns the contract code of the given account in the pending state
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) (code []byte, err error) {
+	err = c.do(ctx, "eth_getCode", func(client *ethclient.Client) error {
+		code, err = client.PendingCodeAt(ctx, account)
+		return err
+	})
+	return code, err
+}
+
+// PendingNonceAt returns the account nonce of the given account in the pending state
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (nonce uint64, err error) {
+	err = c.do(ctx, "eth_getTransactionCount", func(client *ethclient.Client) error {
+		nonce, err = client.PendingNonceAt(ctx, account)
+		return err
+	})
+	return nonce, err
+}
+
+// SuggestGasPrice retrieves the currently suggested gas price
+func (c *Client) SuggestGasPrice(ctx context.Context) (price *big.Int, err error) {
+	err = c.do(ctx, "eth_gasPrice", func(client *ethclient.Client) error {
+		price, err = client.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+// SuggestGasTipCap retrieves the currently suggested gas tip cap
+func (c *Client) SuggestGasTipCap(ctx context.Context) (tip *big.Int, err error) {
+	err = c.do(ctx, "eth_maxPriorityFeePerGas", func(client *ethclient.Client) error {
+		tip, err = client.SuggestGasTipCap(ctx)
+		return err
+	})
+	return tip, err
+}
+
+// EstimateGas estimates the gas needed to execute a transaction
+func (c *Client) EstimateGas(ctx context.Context, msg ethereum.CallMsg) (gas uint64, err error) {
+	err = c.do(ctx, "eth_estimateGas", func(client *ethclient.Client) error {
+		gas, err = client.EstimateGas(ctx, msg)
+		return err
+	})
+	return gas, err
+}
+
+// SendTransaction injects a signed transaction into the pending pool
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	return c.do(ctx, "eth_sendRawTransaction", func(client *ethclient.Client) error {
+		return client.SendTransaction(ctx, tx)
+	})
+}
@@ -0,0 +1,142 @@
+package eth
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeEth stand-in for the eth namespace of a node
+type fakeEth struct {
+	head  uint64
+	calls int64
+}
+
+func (f *fakeEth) BlockNumber() hexutil.Uint64 {
+	atomic.AddInt64(&f.calls, 1)
+	return hexutil.Uint64(f.head)
+}
+
+func (f *fakeEth) ChainId() *hexutil.Big {
+	atomic.AddInt64(&f.calls, 1)
+	return (*hexutil.Big)(hexutil.MustDecodeBig("0x1"))
+}
+
+func newNode(t *testing.T, head uint64) (*httptest.Server, *fakeEth) {
+	service := &fakeEth{head: head}
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("eth", service))
+	node := httptest.NewServer(server)
+	t.Cleanup(node.Close)
+	return node, service
+}
+
+func newBrokenNode(t *testing.T) *httptest.Server {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func TestDialWithoutEndpoint(t *testing.T) {
+	_, err := Dial(context.Background(), nil, DefaultMaxHeadLag)
+	assert.ErrorIs(t, err, ErrNoEndpoint)
+}
+
+func TestDialUnreachableEndpoint(t *testing.T) {
+	node, _ := newNode(t, 100)
+	client, err := Dial(context.Background(), []string{"ws://127.0.0.1:1", node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	head, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), head)
+}
+
+func TestFailover(t *testing.T) {
+	broken := newBrokenNode(t)
+	node, service := newNode(t, 100)
+	client, err := Dial(context.Background(), []string{broken.URL, node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	id, err := client.ChainID(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), id.Int64())
+	assert.Equal(t, int64(2), atomic.LoadInt64(&service.calls))
+
+	statuses := client.Status()
+	assert.Equal(t, node.URL, statuses[0].Uri)
+	assert.True(t, statuses[0].Healthy)
+	assert.False(t, statuses[1].Healthy)
+	assert.NotEmpty(t, statuses[1].LastError)
+}
+
+func TestAllEndpointsFail(t *testing.T) {
+	broken := newBrokenNode(t)
+	client, err := Dial(context.Background(), []string{broken.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.BlockNumber(context.Background())
+	assert.Error(t, err)
+}
+
+func TestHeadLagDemotion(t *testing.T) {
+	behind, _ := newNode(t, 100)
+	ahead, _ := newNode(t, 200)
+	client, err := Dial(context.Background(), []string{behind.URL, ahead.URL}, 5)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	statuses := client.Status()
+	assert.Equal(t, ahead.URL, statuses[0].Uri)
+	assert.False(t, statuses[0].Lagging)
+	assert.Equal(t, behind.URL, statuses[1].Uri)
+	assert.True(t, statuses[1].Lagging)
+
+	head, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(200), head)
+}
+
+func TestRedialAfterFailure(t *testing.T) {
+	service := &fakeEth{head: 100}
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("eth", service))
+	// the next request fails once like a dropped connection, the node answers afterwards
+	var fail int32
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.CompareAndSwapInt32(&fail, 1, 0) {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(node.Close)
+
+	client, err := Dial(context.Background(), []string{node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+	e := client.endpoints[0]
+	failed, err := e.conn(context.Background())
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(&fail, 1)
+	_, err = client.BlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, e.client)
+
+	head, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), head)
+	assert.NotSame(t, failed, e.client)
+	assert.True(t, client.Status()[0].Healthy)
+}
@@ -0,0 +1,40 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"nft-event/util"
+)
+
+// DialChain dials the rpc endpoints of chain and applies the rate limits, retries and budget of config.
+// It fails when the endpoints answer with another chain id than configured.
+func DialChain(ctx context.Context, config *util.Config, chain util.ChainConfig) (*Client, error) {
+	limits, err := ParseRateLimits(config.RpcRateLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := Dial(ctx, chain.EthUris, config.EthMaxHeadLag)
+	if err != nil {
+		return nil, err
+	}
+
+	client.SetPolicy(NewPolicy(PolicyConfig{
+		RateLimits:  limits,
+		DefaultRate: config.RpcDefaultRate,
+		MaxRetries:  config.RpcMaxRetries,
+		DailyBudget: config.RpcDailyBudget,
+	}))
+
+	id, err := client.ChainID(ctx)
+	if err != nil {
+		log.Warnf("%s: failed to verify chain id: %v", chain.Label(), err)
+		return client, nil
+	}
+	if id.Uint64() != chain.ChainId {
+		client.Close()
+		return nil, fmt.Errorf("%s: endpoints serve chain id %d, expected %d", chain.Label(), id.Uint64(), chain.ChainId)
+	}
+	return client, nil
+}
@@ -0,0 +1,147 @@
+package eth
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"sync"
+	"time"
+)
+
+const (
+	// latencyWeight smoothing factor of the latency moving average
+	latencyWeight = 0.3
+	// errorDecay how much of the error rate is kept on every successful call
+	errorDecay = 0.8
+)
+
+// endpoint single rpc node with its health statistics
+type endpoint struct {
+	uri string
+
+	mu      sync.Mutex
+	client  *ethclient.Client
+	head    uint64
+	latency time.Duration
+	errRate float64
+	lastErr error
+	healthy bool
+	lagging bool
+}
+
+func newEndpoint(uri string) *endpoint {
+	return &endpoint{uri: uri}
+}
+
+// conn returns the underlying client, dialing it first if needed
+func (e *endpoint) conn(ctx context.Context) (*ethclient.Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		return e.client, nil
+	}
+
+	client, err := ethclient.DialContext(ctx, e.uri)
+	if err != nil {
+		e.lastErr = err
+		e.healthy = false
+		return nil, err
+	}
+	e.client = client
+	return client, nil
+}
+
+func (e *endpoint) success(latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.latency == 0 {
+		e.latency = latency
+	} else {
+		e.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(e.latency))
+	}
+	e.errRate *= errorDecay
+	e.healthy = true
+}
+
+// failure records an endpoint error of client and closes it, so the next call dials the endpoint again
+// instead of reusing a connection which may have dropped
+func (e *endpoint) failure(client *ethclient.Client, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.errRate = e.errRate*errorDecay + (1 - errorDecay)
+	e.lastErr = err
+	e.healthy = false
+	// another call may have dialed again already
+	if e.client == client && client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+}
+
+func (e *endpoint) setHead(head uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.head = head
+}
+
+func (e *endpoint) setLagging(lagging bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lagging = lagging
+}
+
+// score lower is better, lagging and unhealthy endpoints are always ranked last
+func (e *endpoint) score() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	score := float64(e.latency.Milliseconds()+1) * (1 + 10*e.errRate)
+	if e.lagging {
+		score += 1e9
+	}
+	if !e.healthy {
+		score += 1e12
+	}
+	return score
+}
+
+func (e *endpoint) status() EndpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	status := EndpointStatus{
+		Uri:       e.uri,
+		Head:      e.head,
+		Latency:   e.latency,
+		ErrorRate: e.errRate,
+		Healthy:   e.healthy,
+		Lagging:   e.lagging,
+	}
+	if e.lastErr != nil {
+		status.LastError = e.lastErr.Error()
+	}
+	return status
+}
+
+func (e *endpoint) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.client != nil {
+		e.client.Close()
+		e.client = nil
+	}
+}
+
+// EndpointStatus snapshot of the health of one endpoint
+type EndpointStatus struct {
+	Uri       string
+	Head      uint64
+	Latency   time.Duration
+	ErrorRate float64
+	Healthy   bool
+	Lagging   bool
+	LastError string
+}
@@ -0,0 +1,325 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries retries of a call failing with a transient error
+	DefaultMaxRetries = 3
+	// DefaultRetryDelay base delay of the exponential retry backoff
+	DefaultRetryDelay = 500 * time.Millisecond
+	// maxRetryDelay upper bound of a single retry backoff
+	maxRetryDelay = 30 * time.Second
+	// defaultCost compute units of a method missing in the cost table
+	defaultCost int64 = 20
+)
+
+// DefaultCosts compute units charged per method, modeled after hosted provider pricing
+var DefaultCosts = map[string]int64{
+	"eth_chainId":               0,
+	"eth_blockNumber":           10,
+	"eth_getBlockByNumber":      16,
+	"eth_getBlockByHash":        16,
+	"eth_getTransactionByHash":  17,
+	"eth_getTransactionReceipt": 15,
+	"eth_getLogs":               75,
+	"eth_subscribe":             10,
+	"eth_getCode":               26,
+	"eth_call":                  26,
+	"eth_getTransactionCount":   26,
+	"eth_gasPrice":              20,
+	"eth_maxPriorityFeePerGas":  10,
+	"eth_estimateGas":           87,
+	"eth_sendRawTransaction":    250,
+}
+
+// PolicyConfig limits applied to the rpc calls of a client
+type PolicyConfig struct {
+	// RateLimits calls per second allowed per method
+	RateLimits map[string]float64
+	// DefaultRate calls per second for methods without an own limit, 0 is unlimited
+	DefaultRate float64
+	// MaxRetries retries of a call failing with a transient error
+	MaxRetries int
+	// RetryDelay base delay of the exponential backoff between retries
+	RetryDelay time.Duration
+	// DailyBudget compute units that may be spent per day, 0 is unlimited
+	DailyBudget int64
+	// Costs compute units per method, DefaultCosts when nil
+	Costs map[string]int64
+}
+
+// MethodUsage calls and compute units spent on one method during the current day
+type MethodUsage struct {
+	Method       string
+	Calls        int64
+	Errors       int64
+	Retries      int64
+	ComputeUnits int64
+	Throttled    time.Duration
+}
+
+// Policy per method rate limits, retries and a daily compute unit budget
+type Policy struct {
+	config  PolicyConfig
+	limits  map[string]*bucket
+	budget  *bucket
+	random  *rand.Rand
+	randMu  sync.Mutex
+	usageMu sync.Mutex
+	usage   map[string]*MethodUsage
+	day     time.Time
+}
+
+// NewPolicy creates a policy from config
+func NewPolicy(config PolicyConfig) *Policy {
+	if config.Costs == nil {
+		config.Costs = DefaultCosts
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = DefaultRetryDelay
+	}
+
+	p := &Policy{
+		config: config,
+		limits: make(map[string]*bucket),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		usage:  make(map[string]*MethodUsage),
+		day:    today(),
+	}
+	for method, rate := range config.RateLimits {
+		p.limits[method] = newBucket(rate, rate)
+	}
+	if config.DailyBudget > 0 {
+		// up to one hour of the budget in a burst, the rest spread evenly over the day,
+		// so no 24 hours spend more than the budget
+		burst := float64(config.DailyBudget) / 24
+		perSecond := (float64(config.DailyBudget) - burst) / (24 * time.Hour).Seconds()
+		p.budget = newBucket(perSecond, burst)
+		// the burst of tiny budgets stays below one unit, newBucket would raise it
+		p.budget.burst, p.budget.tokens = burst, burst
+	}
+	return p
+}
+
+// limit returns the rate limiter of method, creating it from the default rate
+func (p *Policy) limit(method string) *bucket {
+	p.usageMu.Lock()
+	defer p.usageMu.Unlock()
+
+	if b, ok := p.limits[method]; ok {
+		return b
+	}
+	if p.config.DefaultRate <= 0 {
+		return nil
+	}
+	b := newBucket(p.config.DefaultRate, p.config.DefaultRate)
+	p.limits[method] = b
+	return b
+}
+
+func (p *Policy) cost(method string) int64 {
+	if cost, ok := p.config.Costs[method]; ok {
+		return cost
+	}
+	return defaultCost
+}
+
+// run calls fn under the limits of method, retrying transient errors with jittered exponential backoff
+func (p *Policy) run(ctx context.Context, method string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		if b := p.limit(method); b != nil {
+			if err := b.wait(ctx, 1); err != nil {
+				return err
+			}
+		}
+		cost := p.cost(method)
+		if p.budget != nil && cost > 0 {
+			if err := p.budget.wait(ctx, float64(cost)); err != nil {
+				return err
+			}
+		}
+		throttled := time.Since(start)
+
+		err = fn()
+		p.record(method, cost, attempt > 0, err != nil, throttled)
+		if err == nil || !isEndpointError(ctx, err) || attempt >= p.config.MaxRetries {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		log.Warnf("%s retry %d in %s: %v", method, attempt+1, delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// backoff full jitter exponential delay for the given attempt
+func (p *Policy) backoff(attempt int) time.Duration {
+	max := p.config.RetryDelay << uint(attempt)
+	if max > maxRetryDelay || max <= 0 {
+		max = maxRetryDelay
+	}
+	p.randMu.Lock()
+	defer p.randMu.Unlock()
+	return time.Duration(p.random.Int63n(int64(max))) + 1
+}
+
+func (p *Policy) record(method string, cost int64, retry, failed bool, throttled time.Duration) {
+	p.usageMu.Lock()
+	defer p.usageMu.Unlock()
+
+	if day := today(); !day.Equal(p.day) {
+		p.usage = make(map[string]*MethodUsage)
+		p.day = day
+	}
+
+	usage, ok := p.usage[method]
+	if !ok {
+		usage = &MethodUsage{Method: method}
+		p.usage[method] = usage
+	}
+	usage.Calls++
+	usage.ComputeUnits += cost
+	usage.Throttled += throttled
+	if retry {
+		usage.Retries++
+	}
+	if failed {
+		usage.Errors++
+	}
+}
+
+// Usage returns the usage of every method called today
+func (p *Policy) Usage() []MethodUsage {
+	p.usageMu.Lock()
+	defer p.usageMu.Unlock()
+
+	var usage []MethodUsage
+	for _, u := range p.usage {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].Method < usage[j].Method
+	})
+	return usage
+}
+
+// LogUsage writes the usage of today to the log
+func (p *Policy) LogUsage() {
+	var total int64
+	for _, u := range p.Usage() {
+		total += u.ComputeUnits
+		log.Infof("rpc usage %s: calls %d, errors %d, retries %d, compute units %d, throttled %.2fs",
+			u.Method, u.Calls, u.Errors, u.Retries, u.ComputeUnits, u.Throttled.Seconds())
+	}
+	if p.config.DailyBudget > 0 {
+		log.Infof("rpc compute units today: %d of %d", total, p.config.DailyBudget)
+	} else {
+		log.Infof("rpc compute units today: %d", total)
+	}
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// ParseRateLimits parses a comma separated list of method=calls per second, e.g. "eth_call=20,eth_getLogs=5"
+func ParseRateLimits(s string) (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid rate limit %q, expected method=rate", pair)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid rate of %s: %q", kv[0], kv[1])
+		}
+		limits[strings.TrimSpace(kv[0])] = rate
+	}
+	return limits, nil
+}
+
+// bucket token bucket refilled at rate tokens per second up to burst
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now(), now: time.Now}
+}
+
+// reserve takes n tokens and returns how long to wait until they are available
+func (b *bucket) reserve(n float64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund gives back n tokens which were reserved but not used
+func (b *bucket) refund(n float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += n
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// wait blocks until n tokens are available, they are given back when ctx is done first
+func (b *bucket) wait(ctx context.Context, n float64) error {
+	delay := b.reserve(n)
+	if delay == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.refund(n)
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,131 @@
+package eth
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	b := newBucket(2, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+	assert.Equal(t, 500*time.Millisecond, b.reserve(1))
+
+	now = now.Add(time.Second)
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+}
+
+func TestBucketRefund(t *testing.T) {
+	now := time.Now()
+	b := newBucket(1, 1)
+	b.now = func() time.Time { return now }
+	b.last = now
+	assert.Equal(t, time.Duration(0), b.reserve(1))
+
+	// a wait given up gives its tokens back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, b.wait(ctx, 5), context.Canceled)
+	assert.Equal(t, time.Second, b.reserve(1))
+}
+
+func TestPolicyDailyBudget(t *testing.T) {
+	// the burst and the refill of a day add up to the budget
+	budget := NewPolicy(PolicyConfig{DailyBudget: 2400}).budget
+	assert.Equal(t, float64(100), budget.burst)
+	assert.InDelta(t, 2400, budget.burst+budget.rate*(24*time.Hour).Seconds(), 1e-6)
+	budget = NewPolicy(PolicyConfig{DailyBudget: 1}).budget
+	assert.InDelta(t, 1, budget.burst+budget.rate*(24*time.Hour).Seconds(), 1e-6)
+}
+
+func TestParseRateLimits(t *testing.T) {
+	limits, err := ParseRateLimits("eth_call=20, eth_getLogs=0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"eth_call": 20, "eth_getLogs": 0.5}, limits)
+
+	limits, err = ParseRateLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits)
+
+	_, err = ParseRateLimits("eth_call")
+	assert.Error(t, err)
+	_, err = ParseRateLimits("eth_call=fast")
+	assert.Error(t, err)
+}
+
+// newFlakyNode fails the first n requests with a gateway error
+func newFlakyNode(t *testing.T, n int64, head uint64) *httptest.Server {
+	server := rpc.NewServer()
+	assert.NoError(t, server.RegisterName("eth", &fakeEth{head: head}))
+
+	var requests int64
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= n {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func TestPolicyRetry(t *testing.T) {
+	// the health check of Dial consumes the first failure
+	node := newFlakyNode(t, 3, 100)
+	client, err := Dial(context.Background(), []string{node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.SetPolicy(NewPolicy(PolicyConfig{MaxRetries: 3, RetryDelay: time.Millisecond}))
+	head, err := client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), head)
+
+	usage := client.Policy().Usage()
+	assert.Len(t, usage, 1)
+	assert.Equal(t, "eth_blockNumber", usage[0].Method)
+	assert.Equal(t, int64(3), usage[0].Calls)
+	assert.Equal(t, int64(2), usage[0].Errors)
+	assert.Equal(t, int64(2), usage[0].Retries)
+	assert.Equal(t, int64(30), usage[0].ComputeUnits)
+}
+
+func TestPolicyGivesUp(t *testing.T) {
+	node := newFlakyNode(t, 100, 100)
+	client, err := Dial(context.Background(), []string{node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	client.SetPolicy(NewPolicy(PolicyConfig{MaxRetries: 2, RetryDelay: time.Millisecond}))
+	_, err = client.BlockNumber(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, int64(3), client.Policy().Usage()[0].Calls)
+}
+
+func TestPolicyBudgetThrottles(t *testing.T) {
+	node, _ := newNode(t, 100)
+	client, err := Dial(context.Background(), []string{node.URL}, DefaultMaxHeadLag)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// one hour of a 240 unit budget is 10 units, a single eth_blockNumber
+	client.SetPolicy(NewPolicy(PolicyConfig{DailyBudget: 240}))
+	_, err = client.BlockNumber(context.Background())
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.BlockNumber(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,278 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"nft-event/db"
+	"nft-event/model"
+	"nft-event/util"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Column kinds, the type of the values of a column
+const (
+	KindString = "string"
+	KindInt    = "int"
+	KindBool   = "bool"
+	KindTime   = "time"
+	// KindList list of strings, joined with commas in csv and parquet files
+	KindList = "list"
+	// KindDocument embedded document, json text in csv and parquet files
+	KindDocument = "document"
+)
+
+// Column exported field of a dataset, Name is the field name in the documents
+type Column struct {
+	Name string
+	Kind string
+}
+
+// Dataset collection which can be exported
+type Dataset struct {
+	Name       string
+	Collection func(config *util.Config) string
+	// Columns all columns in their default order
+	Columns []Column
+	// BlockField field filtered by block ranges, datasets without one cannot be filtered by block
+	BlockField string
+	// TimeField field filtered by time ranges
+	TimeField string
+	// Sort order of the exported documents
+	Sort bson.D
+	// Decode the writes storing a jsonl line of the dataset, nil for datasets which cannot be imported
+	Decode func(line []byte, config *util.Config) ([]db.Write, error)
+}
+
+// Datasets exportable datasets by name
+var Datasets = map[string]Dataset{
+	"events": {
+		Name:       "events",
+		Collection: func(config *util.Config) string { return config.MongoEvent },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"blockNumber", KindInt},
+			{"logIndex", KindInt},
+			{"tx", KindString},
+			{"type", KindString},
+			{"nftAddress", KindString},
+			{"from", KindString},
+			{"to", KindString},
+			{"tokenId", KindString},
+			{"toTokenId", KindString},
+			{"blockTime", KindTime},
+			{"operator", KindString},
+			{"txTo", KindString},
+			{"gasUsed", KindInt},
+			{"gasPrice", KindString},
+			{"createdAt", KindTime},
+		},
+		BlockField: "blockNumber",
+		TimeField:  "blockTime",
+		Sort:       bson.D{{"blockNumber", 1}, {"logIndex", 1}},
+		Decode:     decodeEvent,
+	},
+	"tokens": {
+		Name:       "tokens",
+		Collection: func(config *util.Config) string { return config.MongoNft },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"nftAddress", KindString},
+			{"tokenId", KindString},
+			{"owner", KindString},
+			{"burned", KindBool},
+			{"minter", KindString},
+			{"mintTx", KindString},
+			{"mintBlock", KindInt},
+			{"mintTime", KindTime},
+			{"burner", KindString},
+			{"burnTx", KindString},
+			{"burnBlock", KindInt},
+			{"burnTime", KindTime},
+			{"tokenUri", KindString},
+			{"name", KindString},
+			{"description", KindString},
+			{"image", KindString},
+			{"mimeType", KindString},
+			{"metadataHash", KindString},
+			{"metadataRefreshedAt", KindTime},
+			{"royalty", KindDocument},
+			{"blockNumber", KindInt},
+			{"logIndex", KindInt},
+			{"createdAt", KindTime},
+			{"updatedAt", KindTime},
+		},
+		// the block of the last transfer of the token
+		BlockField: "blockNumber",
+		TimeField:  "updatedAt",
+		Sort:       bson.D{{"nftAddress", 1}, {"tokenId", 1}},
+		Decode:     decodeToken,
+	},
+	"holdings": {
+		Name:       "holdings",
+		Collection: func(config *util.Config) string { return config.MongoHolding },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"nftAddress", KindString},
+			{"owner", KindString},
+			{"count", KindInt},
+			{"tokenIds", KindList},
+			{"updatedAt", KindTime},
+		},
+		TimeField: "updatedAt",
+		Sort:      bson.D{{"nftAddress", 1}, {"count", -1}, {"owner", 1}},
+	},
+	"sales": {
+		Name:       "sales",
+		Collection: func(config *util.Config) string { return config.MongoSale },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"blockNumber", KindInt},
+			{"logIndex", KindInt},
+			{"tx", KindString},
+			{"nftAddress", KindString},
+			{"tokenId", KindString},
+			{"seller", KindString},
+			{"buyer", KindString},
+			{"price", KindString},
+			{"currency", KindString},
+			{"marketplace", KindString},
+			{"blockTime", KindTime},
+			{"createdAt", KindTime},
+		},
+		BlockField: "blockNumber",
+		TimeField:  "blockTime",
+		Sort:       bson.D{{"blockNumber", 1}, {"logIndex", 1}},
+		Decode:     decodeSale,
+	},
+	"metadata": {
+		Name:       "metadata",
+		Collection: func(config *util.Config) string { return config.MongoMetadata },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"nftAddress", KindString},
+			{"tokenId", KindString},
+			{"hash", KindString},
+			{"tokenUri", KindString},
+			{"name", KindString},
+			{"description", KindString},
+			{"image", KindString},
+			{"metadata", KindDocument},
+			{"observedAt", KindTime},
+		},
+		TimeField: "observedAt",
+		Sort:      bson.D{{"nftAddress", 1}, {"tokenId", 1}, {"observedAt", 1}},
+		Decode:    decodeMetadata,
+	},
+	"checkpoints": {
+		Name:       "checkpoints",
+		Collection: func(config *util.Config) string { return config.MongoBlock },
+		Columns: []Column{
+			{"chainId", KindInt},
+			{"nftAddress", KindString},
+			{"current", KindInt},
+			{"createdAt", KindTime},
+			{"updatedAt", KindTime},
+		},
+		TimeField: "updatedAt",
+		Sort:      bson.D{{"chainId", 1}, {"nftAddress", 1}},
+		Decode:    decodeCheckpoint,
+	},
+}
+
+// DatasetNames names of the exportable datasets, sorted
+func DatasetNames() []string {
+	names := make([]string, 0, len(Datasets))
+	for name := range Datasets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select the columns with names in their order, all columns without names
+func (d Dataset) Select(names []string) ([]Column, error) {
+	if len(names) == 0 {
+		return d.Columns, nil
+	}
+	columns := make([]Column, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("column %q is selected twice", name)
+		}
+		seen[name] = true
+		column, ok := d.column(name)
+		if !ok {
+			return nil, fmt.Errorf("dataset %s has no column %q", d.Name, name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+func (d Dataset) column(name string) (Column, bool) {
+	for _, column := range d.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return Column{}, false
+}
+
+// Filter documents to export, zero values do not filter. Block and time ranges include both ends.
+type Filter struct {
+	ChainId   uint64
+	Contract  string
+	FromBlock uint64
+	ToBlock   uint64
+	Since     time.Time
+	Until     time.Time
+}
+
+// Query query of the documents of dataset matching the filter
+func (f Filter) Query(d Dataset) (bson.D, error) {
+	query := bson.D{}
+	if f.ChainId != 0 {
+		query = append(query, bson.E{Key: "chainId", Value: f.ChainId})
+	}
+	if f.Contract != "" {
+		if !model.ValidAddress(f.Contract) {
+			return nil, fmt.Errorf("invalid contract %q", f.Contract)
+		}
+		query = append(query, bson.E{Key: "nftAddress", Value: f.Contract})
+	}
+
+	if f.ToBlock != 0 && f.FromBlock > f.ToBlock {
+		return nil, fmt.Errorf("from block %d is after to block %d", f.FromBlock, f.ToBlock)
+	}
+	if f.FromBlock != 0 || f.ToBlock != 0 {
+		if d.BlockField == "" {
+			return nil, errors.New(d.Name + " cannot be filtered by block")
+		}
+		query = append(query, bson.E{Key: d.BlockField, Value: bounds(f.FromBlock, f.ToBlock, f.FromBlock != 0, f.ToBlock != 0)})
+	}
+
+	if !f.Until.IsZero() && f.Since.After(f.Until) {
+		return nil, fmt.Errorf("since %s is after until %s", f.Since.Format(time.RFC3339), f.Until.Format(time.RFC3339))
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		query = append(query, bson.E{Key: d.TimeField, Value: bounds(f.Since, f.Until, !f.Since.IsZero(), !f.Until.IsZero())})
+	}
+	return query, nil
+}
+
+// bounds range condition between from and to, each only when it is set
+func bounds(from, to interface{}, hasFrom, hasTo bool) bson.D {
+	condition := bson.D{}
+	if hasFrom {
+		condition = append(condition, bson.E{Key: "$gte", Value: from})
+	}
+	if hasTo {
+		condition = append(condition, bson.E{Key: "$lte", Value: to})
+	}
+	return condition
+}
@@ -0,0 +1,115 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/util"
+	"time"
+)
+
+// Export streams the documents of dataset matching filter to w, one row of columns per document.
+// Documents are read one cursor batch at a time, so nothing is held in memory but the batch and what w buffers.
+// It returns the number of rows written, w is not closed.
+func Export(ctx context.Context, client *mongo.Client, config *util.Config, dataset Dataset, filter Filter, columns []Column, w Writer) (int64, error) {
+	query, err := filter.Query(dataset)
+	if err != nil {
+		return 0, err
+	}
+	projection := bson.D{{"_id", 0}}
+	for _, column := range columns {
+		projection = append(projection, bson.E{Key: column.Name, Value: 1})
+	}
+	opts := options.Find().SetProjection(projection).SetSort(dataset.Sort).SetAllowDiskUse(true)
+	cur, err := client.Database(config.MongoDb).Collection(dataset.Collection(config)).Find(ctx, query, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cur, ctx)
+
+	var rows int64
+	row := make([]interface{}, len(columns))
+	for cur.Next(ctx) {
+		if err := Row(cur.Current, columns, row); err != nil {
+			return rows, err
+		}
+		if err := w.Write(row); err != nil {
+			return rows, err
+		}
+		rows++
+	}
+	return rows, cur.Err()
+}
+
+// Row fills row with the values of columns in document, nil for missing values
+func Row(document bson.Raw, columns []Column, row []interface{}) error {
+	for i, column := range columns {
+		value, err := Value(document.Lookup(column.Name), column.Kind)
+		if err != nil {
+			return fmt.Errorf("column %s: %w", column.Name, err)
+		}
+		row[i] = value
+	}
+	return nil
+}
+
+// Value go value of a bson value of kind: string, int64, bool, time.Time, []string, json.RawMessage or nil when missing
+func Value(raw bson.RawValue, kind string) (interface{}, error) {
+	if raw.Type == 0 || raw.Type == bsontype.Null {
+		return nil, nil
+	}
+	var value interface{}
+	ok := false
+	switch kind {
+	case KindString:
+		value, ok = raw.StringValueOK()
+	case KindInt:
+		value, ok = raw.AsInt64OK()
+	case KindBool:
+		value, ok = raw.BooleanOK()
+	case KindTime:
+		var t time.Time
+		t, ok = raw.TimeOK()
+		value = t.UTC()
+	case KindList:
+		var array bson.Raw
+		if array, ok = raw.ArrayOK(); ok {
+			values, err := array.Values()
+			if err != nil {
+				return nil, err
+			}
+			list := make([]string, len(values))
+			for i, item := range values {
+				if list[i], ok = item.StringValueOK(); !ok {
+					return nil, fmt.Errorf("unexpected %s in list", item.Type)
+				}
+			}
+			value = list
+		}
+	case KindDocument:
+		var document bson.Raw
+		if document, ok = raw.DocumentOK(); ok {
+			text, err := bson.MarshalExtJSON(document, false, false)
+			if err != nil {
+				return nil, err
+			}
+			value = json.RawMessage(text)
+		}
+	default:
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s for %s", raw.Type, kind)
+	}
+	return value, nil
+}
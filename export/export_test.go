@@ -0,0 +1,137 @@
+package export
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+var holding = bson.D{
+	{"chainId", uint64(1)},
+	{"nftAddress", "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"},
+	{"owner", "0xab5801a7d398351b8be11c439e05c5b3259aec9b"},
+	{"count", int64(2)},
+	{"tokenIds", bson.A{"7", "13"}},
+	{"updatedAt", time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)},
+}
+
+func holdingRow(t *testing.T, columns []Column) []interface{} {
+	document, err := bson.Marshal(holding)
+	assert.NoError(t, err)
+	row := make([]interface{}, len(columns))
+	assert.NoError(t, Row(document, columns, row))
+	return row
+}
+
+func TestSelect(t *testing.T) {
+	holdings := Datasets["holdings"]
+	columns, err := holdings.Select(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, holdings.Columns, columns)
+
+	columns, err = holdings.Select([]string{"owner", " count"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Column{{"owner", KindString}, {"count", KindInt}}, columns)
+
+	_, err = holdings.Select([]string{"price"})
+	assert.Error(t, err)
+	_, err = holdings.Select([]string{"owner", "owner"})
+	assert.Error(t, err)
+}
+
+func TestFilterQuery(t *testing.T) {
+	since := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC)
+	filter := Filter{ChainId: 1, Contract: "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", FromBlock: 100, Since: since}
+	query, err := filter.Query(Datasets["events"])
+	assert.NoError(t, err)
+	assert.Equal(t, bson.D{
+		{"chainId", uint64(1)},
+		{"nftAddress", "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"},
+		{"blockNumber", bson.D{{"$gte", uint64(100)}}},
+		{"blockTime", bson.D{{"$gte", since}}},
+	}, query)
+
+	// holdings have no block
+	_, err = filter.Query(Datasets["holdings"])
+	assert.Error(t, err)
+	_, err = Filter{FromBlock: 200, ToBlock: 100}.Query(Datasets["events"])
+	assert.Error(t, err)
+	_, err = Filter{Contract: "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"}.Query(Datasets["events"])
+	assert.Error(t, err)
+}
+
+func TestRow(t *testing.T) {
+	row := holdingRow(t, Datasets["holdings"].Columns)
+	assert.Equal(t, []interface{}{
+		int64(1),
+		"0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d",
+		"0xab5801a7d398351b8be11c439e05c5b3259aec9b",
+		int64(2),
+		[]string{"7", "13"},
+		time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC),
+	}, row)
+
+	// missing values are nil, values of another type fail
+	document, err := bson.Marshal(bson.D{{"count", "2"}})
+	assert.NoError(t, err)
+	row = make([]interface{}, 2)
+	assert.NoError(t, Row(document, []Column{{"owner", KindString}}, row))
+	assert.Nil(t, row[0])
+	assert.Error(t, Row(document, []Column{{"count", KindInt}}, row))
+}
+
+func TestCsvWriter(t *testing.T) {
+	columns, err := Datasets["holdings"].Select([]string{"owner", "count", "tokenIds", "updatedAt"})
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	w, err := NewWriter(FormatCsv, &out, columns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write(holdingRow(t, columns)))
+	assert.NoError(t, w.Write([]interface{}{nil, int64(0), nil, nil}))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, "owner,count,tokenIds,updatedAt\n"+
+		"0xab5801a7d398351b8be11c439e05c5b3259aec9b,2,\"7,13\",2022-04-01T12:00:00Z\n"+
+		",0,,\n", out.String())
+}
+
+func TestJsonlWriter(t *testing.T) {
+	columns, err := Datasets["holdings"].Select([]string{"owner", "count", "tokenIds", "updatedAt"})
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	w, err := NewWriter(FormatJsonl, &out, columns)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Write(holdingRow(t, columns)))
+	assert.NoError(t, w.Write([]interface{}{nil, int64(0), nil, nil}))
+	assert.NoError(t, w.Close())
+	assert.Equal(t, `{"owner":"0xab5801a7d398351b8be11c439e05c5b3259aec9b","count":2,"tokenIds":["7","13"],"updatedAt":"2022-04-01T12:00:00Z"}`+"\n"+
+		`{"count":0}`+"\n", out.String())
+}
+
+func TestParquetWriter(t *testing.T) {
+	columns := Datasets["holdings"].Columns
+	var out bytes.Buffer
+	w, err := NewWriter(FormatParquet, &out, columns)
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, w.Write(holdingRow(t, columns)))
+	}
+	assert.NoError(t, w.Write(make([]interface{}, len(columns))))
+	assert.NoError(t, w.Close())
+
+	file, err := buffer.NewBufferFile(out.Bytes())
+	assert.NoError(t, err)
+	pr, err := reader.NewParquetReader(file, nil, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), pr.GetNumRows())
+	owners, _, _, err := pr.ReadColumnByPath("Parquet_go_root\x01Owner", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{holding[2].Value, holding[2].Value, holding[2].Value, nil}, owners)
+	pr.ReadStop()
+
+	_, err = NewWriter("xml", &out, columns)
+	assert.Error(t, err)
+}
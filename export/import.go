@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"nft-event/db"
+	"nft-event/indexer"
+	"nft-event/model"
+	"nft-event/util"
+	"time"
+)
+
+// Snapshot datasets of a snapshot in the order they are imported.
+// Checkpoints come last, indexing only resumes from them once the documents they cover are stored.
+var Snapshot = []string{"events", "sales", "tokens", "metadata", "checkpoints"}
+
+// SnapshotExport datasets of a snapshot in the order they are exported.
+// Checkpoints come first, so while the job keeps indexing the checkpoints of a snapshot trail its documents
+// and a restored job indexes the blocks in between again instead of skipping them.
+var SnapshotExport = []string{"checkpoints", "events", "sales", "tokens", "metadata"}
+
+// ImportBatch lines written per commit by Import
+const ImportBatch = 1000
+
+// MaxLineSize size of the longest line of a jsonl export which can be read
+const MaxLineSize = 16 * 1024 * 1024
+
+// Read decodes every line of a jsonl export of dataset into the writes storing it, passing them to fn.
+// It stops at the first invalid line and returns the number of lines read.
+func Read(dataset Dataset, config *util.Config, r io.Reader, fn func(writes []db.Write) error) (int64, error) {
+	if dataset.Decode == nil {
+		return 0, fmt.Errorf("%s cannot be imported", dataset.Name)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
+	var lines int64
+	for scanner.Scan() {
+		lines++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		writes, err := dataset.Decode(line, config)
+		if err != nil {
+			return lines, fmt.Errorf("%s line %d: %w", dataset.Name, lines, err)
+		}
+		if err := fn(writes); err != nil {
+			return lines, err
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// Validate checks every line of a jsonl export of dataset without writing anything
+func Validate(dataset Dataset, config *util.Config, r io.Reader) (int64, error) {
+	return Read(dataset, config, r, func([]db.Write) error { return nil })
+}
+
+// Import stores every line of a jsonl export of dataset, ImportBatch lines per commit.
+// Documents are keyed like the indexer keys them, so importing again or over indexed data is safe.
+func Import(ctx context.Context, client *mongo.Client, config *util.Config, dataset Dataset, r io.Reader, transactional bool) (int64, error) {
+	batch := db.NewBatch()
+	pending := 0
+	lines, err := Read(dataset, config, r, func(writes []db.Write) error {
+		batch.Add(writes...)
+		pending++
+		if pending < ImportBatch {
+			return nil
+		}
+		err := db.Commit(client, ctx, config.MongoDb, batch, transactional)
+		batch, pending = db.NewBatch(), 0
+		return err
+	})
+	if err != nil {
+		return lines, err
+	}
+	return lines, db.Commit(client, ctx, config.MongoDb, batch, transactional)
+}
+
+// decodeEvent an event with the activities of transfers, metadata updates have none
+func decodeEvent(line []byte, config *util.Config) ([]db.Write, error) {
+	event := model.Event{}
+	if err := json.Unmarshal(line, &event); err != nil {
+		return nil, err
+	}
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	writes := []db.Write{indexer.EventWrite(&event, config)}
+	if event.Type != "" {
+		return writes, nil
+	}
+	activityWrites, err := indexer.ActivityWrites(model.TransferActivities(&event), config)
+	if err != nil {
+		return nil, err
+	}
+	return append(writes, activityWrites...), nil
+}
+
+func decodeSale(line []byte, config *util.Config) ([]db.Write, error) {
+	sale := model.Sale{}
+	if err := json.Unmarshal(line, &sale); err != nil {
+		return nil, err
+	}
+	return indexer.SaleWrites(&sale, config)
+}
+
+// decodeToken a token, only replacing a stored one changed by an older log
+func decodeToken(line []byte, config *util.Config) ([]db.Write, error) {
+	token := model.Token{}
+	if err := json.Unmarshal(line, &token); err != nil {
+		return nil, err
+	}
+	if err := token.Validate(); err != nil {
+		return nil, err
+	}
+	tokenDoc, err := db.ToDoc(&token)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.D{
+		{"chainId", token.ChainId},
+		{"nftAddress", token.NftAddress},
+		{"tokenId", token.TokenId},
+	}
+	return []db.Write{{
+		Collection: config.MongoNft,
+		Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(db.NewerPipeline(tokenDoc, token.BlockNumber, token.LogIndex)).SetUpsert(true),
+	}}, nil
+}
+
+func decodeMetadata(line []byte, config *util.Config) ([]db.Write, error) {
+	version := model.MetadataVersion{}
+	if err := json.Unmarshal(line, &version); err != nil {
+		return nil, err
+	}
+	if err := version.Validate(); err != nil {
+		return nil, err
+	}
+	return []db.Write{indexer.MetadataVersionWrite(&version, config)}, nil
+}
+
+// checkpoint line of the checkpoints dataset
+type checkpoint struct {
+	ChainId    uint64    `json:"chainId"`
+	NftAddress string    `json:"nftAddress"`
+	Current    *int64    `json:"current"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// decodeCheckpoint a checkpoint, which never moves a stored one back
+func decodeCheckpoint(line []byte, config *util.Config) ([]db.Write, error) {
+	restored := checkpoint{}
+	if err := json.Unmarshal(line, &restored); err != nil {
+		return nil, err
+	}
+	if restored.ChainId == 0 {
+		return nil, errors.New("checkpoint without chainId")
+	}
+	if restored.NftAddress == "" {
+		return nil, errors.New("checkpoint without nftAddress")
+	}
+	if restored.Current == nil || *restored.Current < 0 {
+		return nil, errors.New("checkpoint without current block")
+	}
+	if restored.CreatedAt.IsZero() {
+		restored.CreatedAt = time.Now()
+	}
+	filter := bson.D{
+		{"chainId", restored.ChainId},
+		{"nftAddress", restored.NftAddress},
+	}
+	update := bson.D{
+		{"$max", bson.D{{"current", *restored.Current}}},
+		{"$set", bson.D{{"updatedAt", time.Now()}}},
+		{"$setOnInsert", bson.D{{"createdAt", restored.CreatedAt}}},
+	}
+	return []db.Write{{
+		Collection: config.MongoBlock,
+		Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true),
+	}}, nil
+}
@@ -0,0 +1,112 @@
+package export
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"nft-event/db"
+	"nft-event/model"
+	"nft-event/util"
+	"strings"
+	"testing"
+	"time"
+)
+
+var importConfig = &util.Config{MongoEvent: "events", MongoNft: "nfts", MongoActivity: "activities", MongoMetadata: "metadata", MongoBlock: "blocks"}
+
+// jsonl exports documents of dataset as jsonl
+func jsonl(t *testing.T, dataset Dataset, documents ...bson.D) string {
+	var out bytes.Buffer
+	w, err := NewWriter(FormatJsonl, &out, dataset.Columns)
+	assert.NoError(t, err)
+	row := make([]interface{}, len(dataset.Columns))
+	for _, document := range documents {
+		raw, err := bson.Marshal(document)
+		assert.NoError(t, err)
+		assert.NoError(t, Row(raw, dataset.Columns, row))
+		assert.NoError(t, w.Write(row))
+	}
+	assert.NoError(t, w.Close())
+	return out.String()
+}
+
+// collections the collection of every write read from the jsonl of dataset
+func collections(t *testing.T, dataset Dataset, lines string) []string {
+	var written []string
+	_, err := Read(dataset, importConfig, strings.NewReader(lines), func(writes []db.Write) error {
+		for _, write := range writes {
+			written = append(written, write.Collection)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	return written
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	nft := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+	wallet := "0xab5801a7d398351b8be11c439e05c5b3259aec9b"
+	createdAt := time.Date(2022, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	mint := bson.D{
+		{"chainId", int64(1)}, {"blockNumber", int64(100)}, {"logIndex", int64(2)}, {"tx", "0x01"},
+		{"nftAddress", nft}, {"from", model.ZeroAddress}, {"to", wallet}, {"tokenId", "13"}, {"createdAt", createdAt},
+	}
+	update := bson.D{
+		{"chainId", int64(1)}, {"blockNumber", int64(101)}, {"logIndex", int64(0)}, {"tx", "0x02"},
+		{"type", model.EventMetadataUpdate}, {"nftAddress", nft}, {"tokenId", "13"}, {"createdAt", createdAt},
+	}
+	// the mint is stored with the activity of the minter
+	assert.Equal(t, []string{"events", "activities", "events"}, collections(t, Datasets["events"], jsonl(t, Datasets["events"], mint, update)))
+
+	token := bson.D{
+		{"chainId", int64(1)}, {"nftAddress", nft}, {"tokenId", "13"}, {"owner", wallet}, {"burned", false},
+		{"royalty", bson.D{{"receiver", wallet}, {"bps", int64(500)}}}, {"blockNumber", int64(100)}, {"logIndex", int64(2)},
+	}
+	assert.Equal(t, []string{"nfts"}, collections(t, Datasets["tokens"], jsonl(t, Datasets["tokens"], token)))
+
+	version := bson.D{
+		{"chainId", int64(1)}, {"nftAddress", nft}, {"tokenId", "13"}, {"hash", model.MetadataHash("ipfs://13", nil)},
+		{"tokenUri", "ipfs://13"}, {"metadata", bson.D{{"name", "13"}, {"attributes", bson.A{bson.D{{"value", 1.5}}}}}},
+		{"observedAt", createdAt},
+	}
+	lines := jsonl(t, Datasets["metadata"], version)
+	assert.Contains(t, lines, `"metadata":{"name":"13","attributes":[{"value":1.5}]}`)
+	assert.Equal(t, []string{"metadata"}, collections(t, Datasets["metadata"], lines))
+
+	checkpoint := bson.D{{"chainId", int64(1)}, {"nftAddress", nft}, {"current", int64(0)}, {"updatedAt", createdAt}}
+	assert.Equal(t, []string{"blocks"}, collections(t, Datasets["checkpoints"], jsonl(t, Datasets["checkpoints"], checkpoint)))
+}
+
+func TestImportValidation(t *testing.T) {
+	nft := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+	for _, test := range []struct {
+		dataset string
+		lines   string
+	}{
+		{"events", `{"chainId":1,"tx":"0x01","nftAddress":"0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D","tokenId":"1"}`},
+		{"events", `{"chainId":1,`},
+		{"tokens", `{"chainId":1,"nftAddress":"` + nft + `","tokenId":"0x01","owner":"` + nft + `"}`},
+		{"metadata", `{"chainId":1,"nftAddress":"` + nft + `","tokenId":"1","hash":"abc"}`},
+		{"checkpoints", `{"chainId":1,"nftAddress":"` + nft + `"}`},
+		{"checkpoints", `{"nftAddress":"` + nft + `","current":100}`},
+	} {
+		_, err := Validate(Datasets[test.dataset], importConfig, strings.NewReader("\n"+test.lines+"\n"))
+		assert.Error(t, err, test.lines)
+		assert.Contains(t, err.Error(), "line 2", test.lines)
+	}
+
+	// holdings are rebuilt from the tokens
+	_, err := Validate(Datasets["holdings"], importConfig, strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestSnapshotOrder(t *testing.T) {
+	// checkpoints are exported before and imported after the documents they cover
+	assert.ElementsMatch(t, Snapshot, SnapshotExport)
+	assert.Equal(t, "checkpoints", SnapshotExport[0])
+	assert.Equal(t, "checkpoints", Snapshot[len(Snapshot)-1])
+	for _, name := range SnapshotExport {
+		assert.NotNil(t, Datasets[name].Decode, name)
+	}
+}
@@ -0,0 +1,176 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Output formats
+const (
+	FormatCsv     = "csv"
+	FormatJsonl   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Formats all output formats
+var Formats = []string{FormatCsv, FormatJsonl, FormatParquet}
+
+// ParquetRowGroupSize bytes of rows buffered before a row group is written to a parquet file
+const ParquetRowGroupSize = 16 * 1024 * 1024
+
+// Writer writes rows of values in column order, nil for missing values.
+// Close writes what is buffered, it leaves the underlying writer open.
+type Writer interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// NewWriter writer of the columns in format to w
+func NewWriter(format string, w io.Writer, columns []Column) (Writer, error) {
+	switch format {
+	case FormatCsv:
+		return newCsvWriter(w, columns)
+	case FormatJsonl:
+		return &jsonlWriter{w: bufio.NewWriter(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetWriter(w, columns)
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// csvWriter writes a header with the column names, then a record per row
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func newCsvWriter(w io.Writer, columns []Column) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), record: make([]string, len(columns))}
+	for i, column := range columns {
+		c.record[i] = column.Name
+	}
+	return c, c.w.Write(c.record)
+}
+
+func (c *csvWriter) Write(row []interface{}) error {
+	for i, value := range row {
+		c.record[i] = text(value)
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonlWriter writes a json object per row, its fields in column order and without the missing values
+type jsonlWriter struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+func (j *jsonlWriter) Write(row []interface{}) error {
+	line := []byte{'{'}
+	for i, value := range row {
+		if value == nil {
+			continue
+		}
+		if len(line) > 1 {
+			line = append(line, ',')
+		}
+		name, err := json.Marshal(j.columns[i].Name)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		line = append(append(append(line, name...), ':'), encoded...)
+	}
+	line = append(line, '}', '\n')
+	_, err := j.w.Write(line)
+	return err
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+// parquetWriter writes optional columns, times as timestamps in milliseconds, lists and documents as text
+type parquetWriter struct {
+	w       *writer.CSVWriter
+	columns []Column
+}
+
+func newParquetWriter(w io.Writer, columns []Column) (*parquetWriter, error) {
+	schema := make([]string, len(columns))
+	for i, column := range columns {
+		var types string
+		switch column.Kind {
+		case KindInt:
+			types = "type=INT64"
+		case KindBool:
+			types = "type=BOOLEAN"
+		case KindTime:
+			types = "type=INT64, convertedtype=TIMESTAMP_MILLIS"
+		default:
+			types = "type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		schema[i] = fmt.Sprintf("name=%s, %s, repetitiontype=OPTIONAL", column.Name, types)
+	}
+	pw, err := writer.NewCSVWriterFromWriter(schema, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = ParquetRowGroupSize
+	return &parquetWriter{w: pw, columns: columns}, nil
+}
+
+func (p *parquetWriter) Write(row []interface{}) error {
+	record := make([]interface{}, len(row))
+	for i, value := range row {
+		switch v := value.(type) {
+		case time.Time:
+			record[i] = v.UnixMilli()
+		case []string, json.RawMessage:
+			record[i] = text(v)
+		default:
+			record[i] = v
+		}
+	}
+	return p.w.Write(record)
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+// text csv text of a value, empty for missing values
+func text(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case []string:
+		return strings.Join(v, ",")
+	case json.RawMessage:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
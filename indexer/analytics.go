@@ -0,0 +1,161 @@
+package indexer
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+	"time"
+)
+
+// ReportTop largest holders kept in every holder report
+const ReportTop int64 = 10
+
+// ComputeHolderStats the holder distribution of a contract from its holdings, with its top largest holders
+func ComputeHolderStats(ctx context.Context, client *mongo.Client, config *util.Config, chainId uint64, address string, top int64) (model.HolderStats, error) {
+	stats := model.HolderStats{
+		ChainId:    chainId,
+		NftAddress: address,
+		Top:        []model.Holding{},
+		CreatedAt:  time.Now(),
+	}
+	collection := client.Database(config.MongoDb).Collection(config.MongoHolding)
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", address}}
+
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.D{{"count", 1}}))
+	if err != nil {
+		return stats, err
+	}
+	var holdings []model.Holding
+	if err := cur.All(ctx, &holdings); err != nil {
+		return stats, err
+	}
+	counts := make([]int64, len(holdings))
+	for i, holding := range holdings {
+		counts[i] = holding.Count
+		stats.Tokens += holding.Count
+	}
+	stats.Holders = int64(len(counts))
+	stats.Buckets = model.HolderBuckets(counts)
+	stats.Gini = model.Gini(counts)
+
+	opts := options.Find().
+		SetSort(bson.D{{"count", -1}, {"owner", 1}}).
+		SetLimit(top).
+		SetProjection(bson.D{{"tokenIds", 0}})
+	cur, err = collection.Find(ctx, filter, opts)
+	if err != nil {
+		return stats, err
+	}
+	if err := cur.All(ctx, &stats.Top); err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// ReportHolders stores and logs the holder stats of every contract of the chain, building the holder history
+func (j *Job) ReportHolders() {
+	ctx := context.Background()
+	nfts, err := j.contracts(ctx)
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoHolderStats)
+	for _, nft := range nfts {
+		stats, err := ComputeHolderStats(ctx, j.client, j.config, j.chain.ChainId, nft.Address, ReportTop)
+		if err != nil {
+			log.Errorf("%s: failed to compute holder stats of %s: %v", j.chain.Label(), nft.Address, err)
+			continue
+		}
+		if _, err := collection.InsertOne(ctx, stats); err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+			continue
+		}
+		log.Infof("%s: %s has %d holders of %d tokens, gini %.4f", j.chain.Label(), nft.Address, stats.Holders, stats.Tokens, stats.Gini)
+	}
+}
+
+// whaleMove tokens of a contract a holder sent away and received in a block range
+type whaleMove struct {
+	alert    *model.WhaleAlert
+	received int64
+}
+
+// heldBefore the tokens held before the block range from the tokens held after it
+func (m *whaleMove) heldBefore(held int64) int64 {
+	return held + m.alert.Moved - m.received
+}
+
+// whaleMoves the moves of the holders sending tokens in transfers, in the order they first sent one
+func whaleMoves(chainId uint64, transfers []*model.Event) ([]model.HoldingKey, map[model.HoldingKey]*whaleMove) {
+	moves := make(map[model.HoldingKey]*whaleMove)
+	var order []model.HoldingKey
+	for _, transfer := range transfers {
+		if transfer.From == model.ZeroAddress {
+			continue
+		}
+		key := model.HoldingKey{NftAddress: transfer.NftAddress, Owner: transfer.From}
+		move, ok := moves[key]
+		if !ok {
+			move = &whaleMove{alert: &model.WhaleAlert{ChainId: chainId, NftAddress: transfer.NftAddress, Whale: transfer.From}}
+			moves[key] = move
+			order = append(order, key)
+		}
+		move.alert.Moved++
+		move.alert.Receivers = appendNew(move.alert.Receivers, transfer.To)
+		move.alert.Txs = appendNew(move.alert.Txs, transfer.Tx)
+		if transfer.BlockNumber > move.alert.BlockNumber {
+			move.alert.BlockNumber = transfer.BlockNumber
+		}
+	}
+	for _, transfer := range transfers {
+		if move, ok := moves[model.HoldingKey{NftAddress: transfer.NftAddress, Owner: transfer.To}]; ok {
+			move.received++
+		}
+	}
+	return order, moves
+}
+
+// whaleAlerts records an alert for every holder of at least WHALE_THRESHOLD tokens of a contract sending any of them
+// in stored transfers. The tokens held before are derived from the stored tokens, which the transfers already changed,
+// as the holdings may or may not be recomputed yet.
+func (j *Job) whaleAlerts(ctx context.Context, transfers []*model.Event) {
+	order, moves := whaleMoves(j.chain.ChainId, transfers)
+
+	tokens := j.client.Database(j.config.MongoDb).Collection(j.config.MongoNft)
+	alerts := j.client.Database(j.config.MongoDb).Collection(j.config.MongoAlert)
+	for _, key := range order {
+		filter := bson.D{{"chainId", j.chain.ChainId}, {"nftAddress", key.NftAddress}, {"owner", key.Owner}}
+		held, err := tokens.CountDocuments(ctx, filter)
+		if err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+			continue
+		}
+		move := moves[key]
+		if move.heldBefore(held) < j.config.WhaleThreshold {
+			continue
+		}
+
+		alert := move.alert
+		alert.Held = move.heldBefore(held)
+		alert.CreatedAt = time.Now()
+		log.Warnf("%s: whale %s moved %d of %d tokens of %s", j.chain.Label(), alert.Whale, alert.Moved, alert.Held, alert.NftAddress)
+		if _, err := alerts.InsertOne(ctx, alert); err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+		}
+	}
+}
+
+// appendNew appends value to values unless it is already there
+func appendNew(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
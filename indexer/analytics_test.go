@@ -0,0 +1,30 @@
+package indexer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"nft-event/model"
+	"testing"
+)
+
+func TestWhaleMoves(t *testing.T) {
+	third := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	transfers := []*model.Event{
+		saleTransfer(t, nftTransferLog(common.Address{}, saleSeller, 9)),
+		saleTransfer(t, nftTransferLog(saleSeller, saleBuyer, 7)),
+		saleTransfer(t, nftTransferLog(saleSeller, third, 8)),
+		saleTransfer(t, nftTransferLog(saleBuyer, saleSeller, 7)),
+	}
+	order, moves := whaleMoves(1, transfers)
+
+	seller := model.HoldingKey{NftAddress: model.Address(saleNft), Owner: model.Address(saleSeller)}
+	buyer := model.HoldingKey{NftAddress: model.Address(saleNft), Owner: model.Address(saleBuyer)}
+	assert.Equal(t, []model.HoldingKey{seller, buyer}, order)
+	assert.Equal(t, int64(2), moves[seller].alert.Moved)
+	assert.Equal(t, []string{model.Address(saleBuyer), model.Address(third)}, moves[seller].alert.Receivers)
+
+	// the seller holds 10 tokens after minting one, sending two and getting one back: it held 10 before
+	assert.Equal(t, int64(10), moves[seller].heldBefore(10))
+	// the buyer got and sent token 7 within the range
+	assert.Equal(t, int64(0), moves[buyer].heldBefore(0))
+}
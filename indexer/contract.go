@@ -0,0 +1,250 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+	"nft-event/contracts"
+	"nft-event/model"
+	"nft-event/util"
+	"strings"
+	"time"
+)
+
+// ContractRefreshBatch contracts refreshed per run
+const ContractRefreshBatch = 10
+
+// Interfaces erc165 interfaces detected on contracts by name
+var Interfaces = []struct {
+	Name string
+	Id   [4]byte
+}{
+	{"erc165", [4]byte{0x01, 0xff, 0xc9, 0xa7}},
+	{"erc721", HexBytes},
+	{"erc721Metadata", [4]byte{0x5b, 0x5e, 0x13, 0x9f}},
+	{"erc721Enumerable", [4]byte{0x78, 0x0e, 0x9d, 0x63}},
+	{"erc1155", Erc1155InterfaceId},
+	{"erc2981", [4]byte{0x2a, 0x55, 0x20, 0x5a}},
+	{"erc4906", [4]byte{0x49, 0x06, 0x49, 0x06}},
+}
+
+// refreshContracts updates the contract documents of the contracts not refreshed for CONTRACT_REFRESH_SECONDS
+func (j *Job) refreshContracts(ctx context.Context, head int64) {
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoContract)
+	filter := bson.D{
+		{"chainId", j.chain.ChainId},
+		{"refreshedAt", bson.M{"$gte": time.Now().Add(-time.Duration(j.config.RefreshSeconds) * time.Second)}},
+	}
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.D{{"address", 1}}))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	var fresh []model.Contract
+	if err := cur.All(ctx, &fresh); err != nil {
+		log.Error(err)
+		return
+	}
+	skip := make(map[common.Address]bool, len(fresh))
+	for _, contract := range fresh {
+		skip[common.HexToAddress(contract.Address)] = true
+	}
+
+	refreshed := 0
+	for address := range j.nfts {
+		if skip[address] {
+			continue
+		}
+		if refreshed == ContractRefreshBatch {
+			return
+		}
+		refreshed++
+		if err := j.refreshContract(ctx, address, head); err != nil {
+			log.Errorf("%s: failed to refresh contract %s: %v", j.chain.Label(), model.Address(address), err)
+		}
+	}
+}
+
+// refreshContract reads the name, symbol, supply, contract metadata, interfaces and royalty of a contract
+// and finds its deployment when it is not known yet
+func (j *Job) refreshContract(ctx context.Context, address common.Address, head int64) error {
+	contract := model.Contract{
+		ChainId:     j.chain.ChainId,
+		Address:     model.Address(address),
+		RefreshedAt: time.Now(),
+	}
+	opts := &bind.CallOpts{Context: ctx}
+
+	for _, i := range Interfaces {
+		supported, err := j.supportsInterface(ctx, address, i.Id)
+		if err != nil && !NotSupported(err) {
+			return err
+		}
+		if supported {
+			contract.Interfaces = append(contract.Interfaces, i.Name)
+		}
+	}
+
+	token, err := contracts.NewToken(address, j.eth)
+	if err != nil {
+		return err
+	}
+	collection, err := contracts.NewCollection(address, j.eth)
+	if err != nil {
+		return err
+	}
+
+	// every function is optional, a contract not implementing it leaves the field out
+	if contract.Name, err = token.Name(opts); err != nil && !NotSupported(err) {
+		return err
+	}
+	if contract.Symbol, err = token.Symbol(opts); err != nil && !NotSupported(err) {
+		return err
+	}
+	supply, err := collection.TotalSupply(opts)
+	if err != nil && !NotSupported(err) {
+		return err
+	}
+	if supply != nil {
+		contract.TotalSupply = model.TokenId(supply)
+	}
+	if contract.ContractUri, err = collection.ContractURI(opts); err != nil && !NotSupported(err) {
+		return err
+	}
+	contract.Metadata = fetchContractMetadata(contract.ContractUri)
+
+	if contract.Supports("erc2981") {
+		if contract.Royalty, err = j.contractRoyalty(ctx, address); err != nil {
+			return err
+		}
+	}
+
+	known := model.Contract{}
+	err = j.client.Database(j.config.MongoDb).Collection(j.config.MongoContract).
+		FindOne(ctx, bson.D{{"chainId", j.chain.ChainId}, {"address", contract.Address}}).Decode(&known)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if known.DeploymentBlock == 0 {
+		if err := j.findDeployment(ctx, address, head, &contract); err != nil {
+			log.Warnf("%s: deployment of %s not found: %v", j.chain.Label(), contract.Address, err)
+		}
+	}
+
+	doc := bson.D{
+		{"name", contract.Name},
+		{"symbol", contract.Symbol},
+		{"totalSupply", contract.TotalSupply},
+		{"contractUri", contract.ContractUri},
+		{"interfaces", contract.Interfaces},
+		{"refreshedAt", contract.RefreshedAt},
+	}
+	if contract.Metadata != nil {
+		doc = append(doc, bson.E{Key: "metadata", Value: contract.Metadata})
+	}
+	if contract.DeploymentBlock > 0 {
+		doc = append(doc,
+			bson.E{Key: "deploymentBlock", Value: contract.DeploymentBlock},
+			bson.E{Key: "deploymentTx", Value: contract.DeploymentTx},
+			bson.E{Key: "deployer", Value: contract.Deployer},
+		)
+	}
+	update := bson.D{
+		{"$setOnInsert", bson.D{{"createdAt", time.Now()}}},
+	}
+	if contract.Royalty != nil {
+		doc = append(doc, bson.E{Key: "royalty", Value: contract.Royalty})
+	} else {
+		update = append(update, bson.E{Key: "$unset", Value: bson.D{{"royalty", ""}}})
+	}
+	update = append(update, bson.E{Key: "$set", Value: doc})
+	filter := bson.D{{"chainId", j.chain.ChainId}, {"address", contract.Address}}
+	_, err = j.client.Database(j.config.MongoDb).Collection(j.config.MongoContract).
+		UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	// stored tokens keep the royalty they were asked for, only new tokens take the one of the contract
+	return err
+}
+
+// findDeployment finds the first block with code at address by binary search, which needs an archive node,
+// and the transaction creating the contract in it. Contracts created by other contracts only get their block.
+func (j *Job) findDeployment(ctx context.Context, address common.Address, head int64, contract *model.Contract) error {
+	hasCode := func(block int64) (bool, error) {
+		code, err := j.eth.CodeAt(ctx, address, big.NewInt(block))
+		return len(code) > 0, err
+	}
+	deployed, err := hasCode(head)
+	if err != nil {
+		return err
+	}
+	if !deployed {
+		return fmt.Errorf("no code at block %d", head)
+	}
+
+	low, high := int64(0), head
+	for low < high {
+		mid := low + (high-low)/2
+		deployed, err := hasCode(mid)
+		if err != nil {
+			return err
+		}
+		if deployed {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	contract.DeploymentBlock = uint64(low)
+
+	block, err := j.eth.BlockByNumber(ctx, big.NewInt(low))
+	if err != nil {
+		return err
+	}
+	signer := types.LatestSignerForChainID(new(big.Int).SetUint64(j.chain.ChainId))
+	for _, tx := range block.Transactions() {
+		if tx.To() != nil {
+			continue
+		}
+		receipt, err := j.eth.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return err
+		}
+		if receipt.ContractAddress != address {
+			continue
+		}
+		sender, err := types.Sender(signer, tx)
+		if err != nil {
+			return err
+		}
+		contract.DeploymentTx = tx.Hash().Hex()
+		contract.Deployer = model.Address(sender)
+		return nil
+	}
+	return nil
+}
+
+// fetchContractMetadata the json document the contract uri points to, nil when it could not be fetched.
+// Like token metadata only http uris are fetched, ipfs and data uris are kept as they are.
+func fetchContractMetadata(contractUri string) map[string]interface{} {
+	if !strings.HasPrefix(contractUri, "http") {
+		return nil
+	}
+	data, err := util.GetRequest(contractUri)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	var metadata map[string]interface{}
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		log.Error(err)
+		return nil
+	}
+	return metadata
+}
@@ -0,0 +1,152 @@
+package indexer
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/db"
+	"nft-event/model"
+	"time"
+)
+
+// ParkedBatch parked logs retried per run
+const ParkedBatch = 100
+
+// recordFailure stores a failed log in the dead letter collection.
+// It returns true once the log failed JobMaxAttempts times and no longer holds back the checkpoint.
+func (j *Job) recordFailure(ctx context.Context, vLog types.Log, cause error) (bool, error) {
+	var topics []string
+	for _, topic := range vLog.Topics {
+		topics = append(topics, topic.Hex())
+	}
+
+	update := bson.D{
+		{"$set", bson.D{
+			{"chainId", j.chain.ChainId},
+			{"nftAddress", model.Address(vLog.Address)},
+			{"blockNumber", vLog.BlockNumber},
+			{"blockHash", vLog.BlockHash.Hex()},
+			{"tx", vLog.TxHash.Hex()},
+			{"txIndex", vLog.TxIndex},
+			{"logIndex", vLog.Index},
+			{"topics", topics},
+			{"data", hexutil.Encode(vLog.Data)},
+			{"error", cause.Error()},
+			{"updatedAt", time.Now()},
+		}},
+		{"$setOnInsert", bson.D{
+			{"status", model.DeadLetterPending},
+			{"createdAt", time.Now()},
+		}},
+		{"$inc", bson.D{{"attempts", 1}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoDeadLetter)
+	var deadLetter model.DeadLetter
+	err := collection.FindOneAndUpdate(ctx, bson.M{"key": j.deadLetterKey(vLog)}, update, opts).Decode(&deadLetter)
+	if err != nil {
+		return false, err
+	}
+
+	if deadLetter.Status == model.DeadLetterPending && deadLetter.Attempts >= j.config.JobMaxAttempts {
+		log.Warnf("log %s failed %d times, parked", deadLetter.Key, deadLetter.Attempts)
+		_, err = db.UpdateOne(j.client, ctx, j.config.MongoDb, j.config.MongoDeadLetter, bson.M{"status": model.DeadLetterParked}, bson.M{"_id": deadLetter.ID})
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return deadLetter.Status == model.DeadLetterParked, nil
+}
+
+// clearFailures removes the pending dead letters up to the checkpoint which were stored this time
+func (j *Job) clearFailures(nftAddress common.Address, fromBlock, toBlock int64, failedKeys []string) db.Write {
+	filter := bson.D{
+		{"chainId", j.chain.ChainId},
+		{"nftAddress", model.Address(nftAddress)},
+		{"status", model.DeadLetterPending},
+		{"blockNumber", bson.M{"$gte": fromBlock, "$lte": toBlock}},
+		{"key", bson.M{"$nin": failedKeys}},
+	}
+	return db.Write{
+		Collection: j.config.MongoDeadLetter,
+		Model:      mongo.NewDeleteManyModel().SetFilter(filter),
+	}
+}
+
+// retryParked processes parked logs of the chain again, removing the ones that succeed together with their writes
+func (j *Job) retryParked(ctx context.Context) {
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoDeadLetter)
+	filter := bson.D{
+		{"chainId", j.chain.ChainId},
+		{"status", model.DeadLetterParked},
+	}
+	opts := options.Find().SetSort(bson.D{{"blockNumber", 1}, {"logIndex", 1}}).SetLimit(ParkedBatch)
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cur, ctx)
+
+	var logs []types.Log
+	for cur.Next(ctx) {
+		deadLetter := model.DeadLetter{}
+		if err := cur.Decode(&deadLetter); err != nil {
+			log.Error(err)
+			return
+		}
+		logs = append(logs, deadLetter.Log())
+	}
+	if err := cur.Err(); err != nil {
+		log.Error(err)
+		return
+	}
+	if len(logs) == 0 {
+		return
+	}
+
+	log.Infof("%s: retry %d parked logs", j.chain.Label(), len(logs))
+	batch := db.NewBatch()
+	var stored []result
+	for _, r := range j.processLogs(logs) {
+		if r.err != nil {
+			if _, err := j.recordFailure(ctx, r.log, r.err); err != nil {
+				log.Error(err)
+			}
+			continue
+		}
+		batch.Add(r.writes...)
+		stored = append(stored, r)
+		batch.Add(db.Write{
+			Collection: j.config.MongoDeadLetter,
+			Model:      mongo.NewDeleteOneModel().SetFilter(bson.M{"key": j.deadLetterKey(r.log)}),
+		})
+	}
+	transfers := j.transfers(stored)
+	batch.Add(j.staleHoldingWrites(transfers)...)
+	// parked logs which could not be published stay parked and are retried on the next run.
+	// They are published after the later logs of their tokens, consumers order events by block and log index.
+	if err := j.publish(ctx, stored); err != nil {
+		log.Errorf("%s: failed to publish %d parked logs: %v", j.chain.Label(), len(stored), err)
+		return
+	}
+	if err := j.commit(ctx, batch); err != nil {
+		log.Error(err)
+		return
+	}
+	j.whaleAlerts(ctx, transfers)
+	j.refreshHoldings(ctx)
+}
@@ -0,0 +1,290 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math/big"
+	"nft-event/contracts"
+	"nft-event/db"
+	"nft-event/model"
+	"nft-event/worker"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DiscoveryRange number of blocks scanned per discovery run, every transfer of the chain is fetched
+	DiscoveryRange int64 = 10
+	// DiscoveryCheckpoint key of the discovery checkpoint in the blocks collection
+	DiscoveryCheckpoint = "discovery"
+	// ClassifierCacheSize contracts whose classification is cached, the cache starts over when full
+	ClassifierCacheSize = 100000
+)
+
+// Erc1155InterfaceId ERC1155 interface must be compliant with 0xd9b67a26
+var Erc1155InterfaceId = [4]byte{0xd9, 0xb6, 0x7a, 0x26}
+
+// candidate contract seen by discovery, erc20 transfers have 3 topics
+type candidate struct {
+	address    common.Address
+	firstBlock uint64
+	fungible   bool
+	// attempts failed classifications before this run
+	attempts int
+	standard string
+	err      error
+}
+
+// unclassified the candidates whose classification failed, to be retried on the next runs.
+// Candidates failing maxAttempts times are given up.
+func unclassified(candidates []*candidate, maxAttempts int) []model.UnclassifiedContract {
+	var failed []model.UnclassifiedContract
+	for _, c := range candidates {
+		if c.err == nil {
+			continue
+		}
+		if c.attempts+1 >= maxAttempts {
+			log.Warnf("classification of %s failed %d times, given up: %v", model.Address(c.address), c.attempts+1, c.err)
+			continue
+		}
+		failed = append(failed, model.UnclassifiedContract{
+			Address:    model.Address(c.address),
+			FirstBlock: c.firstBlock,
+			Fungible:   c.fungible,
+			Attempts:   c.attempts + 1,
+		})
+	}
+	return failed
+}
+
+// discover scans the transfer logs of the next block range for unknown erc721 contracts
+// and registers them in the approved collection for review. Contracts whose classification fails do not hold back
+// the checkpoint, they are kept with it and classified again on the next runs.
+func (j *Job) discover(ctx context.Context, safeBlock int64) {
+	checkpoint, err := j.checkpointBlock(ctx, DiscoveryCheckpoint, initialBlock(j.chain.StartBlock, safeBlock))
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+	current := checkpoint.Current
+	fromBlock := current + 1
+	toBlock := safeBlock
+	if toBlock-current > DiscoveryRange {
+		toBlock = current + DiscoveryRange
+	}
+	if toBlock < fromBlock {
+		return
+	}
+
+	query := ethereum.FilterQuery{
+		FromBlock: big.NewInt(fromBlock),
+		ToBlock:   big.NewInt(toBlock),
+		Topics:    [][]common.Hash{{model.TransferSigHash}},
+	}
+	logs, err := j.eth.FilterLogs(ctx, query)
+	if err != nil {
+		log.Errorf("%s: discovery: %v", j.chain.Label(), err)
+		return
+	}
+
+	configured := make(map[common.Address]bool, len(j.chain.Contracts))
+	for _, contract := range j.chain.Contracts {
+		configured[common.HexToAddress(contract)] = true
+	}
+
+	// contracts failed before first, then by the block they were first seen in
+	var candidates []*candidate
+	seen := make(map[common.Address]*candidate)
+	for _, failed := range checkpoint.Unclassified {
+		c := &candidate{address: common.HexToAddress(failed.Address), firstBlock: failed.FirstBlock, fungible: failed.Fungible, attempts: failed.Attempts}
+		if configured[c.address] {
+			continue
+		}
+		seen[c.address] = c
+		candidates = append(candidates, c)
+	}
+	for _, vLog := range logs {
+		if configured[vLog.Address] || j.standards.registered(vLog.Address) {
+			continue
+		}
+		c, ok := seen[vLog.Address]
+		if !ok {
+			c = &candidate{address: vLog.Address, firstBlock: vLog.BlockNumber}
+			seen[vLog.Address] = c
+			candidates = append(candidates, c)
+		}
+		if len(vLog.Topics) == 3 {
+			c.fungible = true
+		}
+	}
+
+	pool := worker.NewPool(j.config.JobWorkers, WorkerQueue)
+	for _, c := range candidates {
+		c := c
+		pool.Submit(c.address.Hex(), func() {
+			ctx, cancel := context.WithTimeout(ctx, LogTimeout)
+			defer cancel()
+			c.standard, c.err = j.standards.classify(ctx, c.address, c.fungible)
+		})
+	}
+	pool.Wait()
+
+	batch := db.NewBatch()
+	var discovered []common.Address
+	for _, c := range candidates {
+		if c.err != nil {
+			log.Errorf("%s: failed to classify %s: %v", j.chain.Label(), model.Address(c.address), c.err)
+			continue
+		}
+		if c.standard != model.StandardErc721 {
+			continue
+		}
+		discovered = append(discovered, c.address)
+		filter := bson.D{
+			{"chainId", j.chain.ChainId},
+			{"address", model.Address(c.address)},
+		}
+		update := bson.D{{"$setOnInsert", bson.D{
+			{"status", model.NftDiscovered},
+			{"blockNumber", c.firstBlock},
+			{"createdAt", time.Now()},
+		}}}
+		batch.Add(db.Write{
+			Collection: j.config.MongoApprovedNft,
+			Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(true),
+		})
+	}
+	failed := unclassified(candidates, j.config.JobMaxAttempts)
+	blockDoc := bson.D{
+		{"current", toBlock},
+		{"unclassified", failed},
+		{"updatedAt", time.Now()},
+	}
+	batch.Add(db.Write{
+		Collection: j.config.MongoBlock,
+		Model:      mongo.NewUpdateOneModel().SetFilter(j.blockFilter(DiscoveryCheckpoint)).SetUpdate(bson.M{"$set": blockDoc}),
+	})
+	if err := j.commit(ctx, batch); err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, address := range discovered {
+		j.standards.register(address)
+	}
+	log.Infof("%s: discovery block %d - %d, %d contracts, %d erc721, %d unclassified", j.chain.Label(), fromBlock, toBlock, len(candidates), len(discovered), len(failed))
+}
+
+// supportsInterface asks a contract whether it supports an erc165 interface
+func (j *Job) supportsInterface(ctx context.Context, address common.Address, interfaceId [4]byte) (bool, error) {
+	instance, err := contracts.NewToken(address, j.eth)
+	if err != nil {
+		return false, err
+	}
+	return instance.SupportsInterface(&bind.CallOpts{Context: ctx}, interfaceId)
+}
+
+// classifier classifies contracts by the erc165 interfaces they support, caching the results
+type classifier struct {
+	supports func(ctx context.Context, address common.Address, interfaceId [4]byte) (bool, error)
+
+	mu        sync.Mutex
+	standards map[classification]string
+	// known contracts registered by this process
+	known map[common.Address]bool
+}
+
+// classification key of a cached standard, contracts without erc165 are classified by whether they are fungible
+type classification struct {
+	address  common.Address
+	fungible bool
+}
+
+func newClassifier(supports func(ctx context.Context, address common.Address, interfaceId [4]byte) (bool, error)) *classifier {
+	return &classifier{
+		supports:  supports,
+		standards: make(map[classification]string),
+		known:     make(map[common.Address]bool),
+	}
+}
+
+// classify returns the standard of a contract, fungible tells it emitted transfers with 3 topics.
+// Failed calls are returned and not cached, so the contract is classified again next time.
+func (c *classifier) classify(ctx context.Context, address common.Address, fungible bool) (string, error) {
+	key := classification{address: address, fungible: fungible}
+	c.mu.Lock()
+	standard, ok := c.standards[key]
+	c.mu.Unlock()
+	if ok {
+		return standard, nil
+	}
+
+	standard, err := c.detect(ctx, address, fungible)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.standards) >= ClassifierCacheSize {
+		c.standards = make(map[classification]string)
+	}
+	c.standards[key] = standard
+	return standard, nil
+}
+
+func (c *classifier) detect(ctx context.Context, address common.Address, fungible bool) (string, error) {
+	for _, candidate := range []struct {
+		interfaceId [4]byte
+		standard    string
+	}{
+		{HexBytes, model.StandardErc721},
+		{Erc1155InterfaceId, model.StandardErc1155},
+	} {
+		supported, err := c.supports(ctx, address, candidate.interfaceId)
+		if err != nil && NotSupported(err) {
+			// no erc165 at all
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if supported {
+			return candidate.standard, nil
+		}
+	}
+	if fungible {
+		return model.StandardErc20, nil
+	}
+	return model.StandardUnknown, nil
+}
+
+// registered reports whether a contract was already registered by this process
+func (c *classifier) registered(address common.Address) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.known[address]
+}
+
+func (c *classifier) register(address common.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.known[address] = true
+}
+
+// NotSupported reports whether a failed supportsInterface call means the contract does not implement it,
+// as opposed to a call which did not get an answer
+func NotSupported(err error) bool {
+	if errors.Is(err, bind.ErrNoCode) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "execution reverted") || strings.Contains(msg, "attempting to unmarshall an empty string")
+}
@@ -0,0 +1,111 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"nft-event/model"
+	"testing"
+)
+
+// fakeContract answers supportsInterface like a deployed contract
+type fakeContract struct {
+	interfaces map[[4]byte]bool
+	err        error
+	calls      int
+}
+
+func (f *fakeContract) supports(ctx context.Context, address common.Address, interfaceId [4]byte) (bool, error) {
+	f.calls++
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.interfaces[interfaceId], nil
+}
+
+func TestClassify(t *testing.T) {
+	address := common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	tests := []struct {
+		name     string
+		contract *fakeContract
+		fungible bool
+		standard string
+	}{
+		{"erc721", &fakeContract{interfaces: map[[4]byte]bool{HexBytes: true}}, false, model.StandardErc721},
+		{"erc1155", &fakeContract{interfaces: map[[4]byte]bool{Erc1155InterfaceId: true}}, false, model.StandardErc1155},
+		{"erc20 without erc165", &fakeContract{err: errors.New("execution reverted")}, true, model.StandardErc20},
+		{"unknown", &fakeContract{}, false, model.StandardUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newClassifier(test.contract.supports)
+			standard, err := c.classify(context.Background(), address, test.fungible)
+			assert.NoError(t, err)
+			assert.Equal(t, test.standard, standard)
+
+			calls := test.contract.calls
+			standard, err = c.classify(context.Background(), address, test.fungible)
+			assert.NoError(t, err)
+			assert.Equal(t, test.standard, standard)
+			assert.Equal(t, calls, test.contract.calls, "classification is cached")
+		})
+	}
+}
+
+func TestClassifyFailure(t *testing.T) {
+	address := common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	contract := &fakeContract{err: errors.New("connection refused")}
+	c := newClassifier(contract.supports)
+
+	_, err := c.classify(context.Background(), address, false)
+	assert.Error(t, err)
+
+	// failures are not cached
+	contract.err = nil
+	contract.interfaces = map[[4]byte]bool{HexBytes: true}
+	standard, err := c.classify(context.Background(), address, false)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StandardErc721, standard)
+}
+
+func TestClassifyCachedPerFungible(t *testing.T) {
+	address := common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	c := newClassifier((&fakeContract{err: errors.New("execution reverted")}).supports)
+
+	standard, err := c.classify(context.Background(), address, true)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StandardErc20, standard)
+
+	// the answer for fungible transfers is not reused for the other lookups of the contract
+	standard, err = c.classify(context.Background(), address, false)
+	assert.NoError(t, err)
+	assert.Equal(t, model.StandardUnknown, standard)
+}
+
+func TestNotSupported(t *testing.T) {
+	assert.True(t, NotSupported(errors.New("execution reverted")))
+	assert.True(t, NotSupported(fmt.Errorf("call: %w", bind.ErrNoCode)))
+	assert.True(t, NotSupported(errors.New("abi: attempting to unmarshall an empty string while arguments are expected")))
+
+	// calls without an answer say nothing about the contract
+	assert.False(t, NotSupported(errors.New("connection refused")))
+	assert.False(t, NotSupported(context.DeadlineExceeded))
+	assert.False(t, NotSupported(errors.New("429 Too Many Requests")))
+}
+
+func TestUnclassified(t *testing.T) {
+	classified := &candidate{address: common.HexToAddress("0x01"), standard: model.StandardErc721}
+	failing := &candidate{address: common.HexToAddress("0x02"), firstBlock: 10, fungible: true, err: errors.New("connection refused")}
+	retried := &candidate{address: common.HexToAddress("0x03"), firstBlock: 5, attempts: 3, err: errors.New("connection refused")}
+	exhausted := &candidate{address: common.HexToAddress("0x04"), attempts: 4, err: errors.New("connection refused")}
+
+	failed := unclassified([]*candidate{classified, failing, retried, exhausted}, 5)
+	assert.Equal(t, []model.UnclassifiedContract{
+		{Address: model.Address(failing.address), FirstBlock: 10, Fungible: true, Attempts: 1},
+		{Address: model.Address(retried.address), FirstBlock: 5, Attempts: 4},
+	}, failed)
+	assert.Empty(t, unclassified([]*candidate{classified}, 5))
+}
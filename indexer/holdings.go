@@ -0,0 +1,245 @@
+package indexer
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/db"
+	"nft-event/model"
+	"nft-event/util"
+	"time"
+)
+
+// RefreshHoldings recomputes the holdings of keys from the stored tokens, then the holder counts and supply of their contracts.
+// Holdings follow the tokens rather than applying transfers, so replayed or reordered logs cannot skew them.
+func RefreshHoldings(ctx context.Context, client *mongo.Client, config *util.Config, chainId uint64, keys []model.HoldingKey) error {
+	tokens := client.Database(config.MongoDb).Collection(config.MongoNft)
+	holdings := client.Database(config.MongoDb).Collection(config.MongoHolding)
+
+	seen := make(map[model.HoldingKey]bool, len(keys))
+	contracts := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		contracts[key.NftAddress] = true
+
+		filter := bson.D{{"chainId", chainId}, {"owner", key.Owner}, {"nftAddress", key.NftAddress}}
+		cur, err := tokens.Find(ctx, filter, options.Find().SetProjection(bson.D{{"tokenId", 1}}))
+		if err != nil {
+			return err
+		}
+		var held []model.Token
+		if err := cur.All(ctx, &held); err != nil {
+			return err
+		}
+
+		if len(held) == 0 {
+			if _, err := holdings.DeleteOne(ctx, filter); err != nil {
+				return err
+			}
+			continue
+		}
+		ids := make([]string, len(held))
+		for i, token := range held {
+			ids[i] = token.TokenId
+		}
+		model.SortTokenIds(ids)
+		update := bson.D{{"$set", bson.D{
+			{"tokenIds", ids},
+			{"count", int64(len(ids))},
+			{"updatedAt", time.Now()},
+		}}}
+		if _, err := holdings.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+	}
+
+	for address := range contracts {
+		if err := countHolders(ctx, client, config, chainId, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StaleHoldingWrites returns the writes marking the holdings of keys stale, to be committed with the transfers changing
+// them so a holding whose recomputation fails or never runs is recomputed by RefreshStaleHoldings later
+func StaleHoldingWrites(chainId uint64, keys []model.HoldingKey, config *util.Config) []db.Write {
+	var writes []db.Write
+	for _, key := range keys {
+		filter := bson.D{{"chainId", chainId}, {"owner", key.Owner}, {"nftAddress", key.NftAddress}}
+		writes = append(writes, db.Write{
+			Collection: config.MongoStale,
+			Model: mongo.NewUpdateOneModel().SetFilter(filter).
+				SetUpdate(bson.D{{"$set", bson.D{{"updatedAt", time.Now()}}}}).SetUpsert(true),
+		})
+	}
+	return writes
+}
+
+// RefreshStaleHoldings recomputes the stale holdings of a chain and drops their marks.
+// A holding marked again while it is recomputed stays stale for the next call.
+func RefreshStaleHoldings(ctx context.Context, client *mongo.Client, config *util.Config, chainId uint64) error {
+	stale := client.Database(config.MongoDb).Collection(config.MongoStale)
+	cur, err := stale.Find(ctx, bson.D{{"chainId", chainId}})
+	if err != nil {
+		return err
+	}
+	var marks []model.StaleHolding
+	if err := cur.All(ctx, &marks); err != nil {
+		return err
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+
+	keys := make([]model.HoldingKey, len(marks))
+	for i, mark := range marks {
+		keys[i] = model.HoldingKey{NftAddress: mark.NftAddress, Owner: mark.Owner}
+	}
+	if err := RefreshHoldings(ctx, client, config, chainId, keys); err != nil {
+		return err
+	}
+	for _, mark := range marks {
+		if _, err := stale.DeleteOne(ctx, bson.D{{"_id", mark.ID}, {"updatedAt", mark.UpdatedAt}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildHoldings recomputes every holding of the stored tokens and the holder counts of all contracts
+func RebuildHoldings(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	holdings := client.Database(config.MongoDb).Collection(config.MongoHolding)
+	if _, err := holdings.DeleteMany(ctx, bson.D{}); err != nil {
+		return err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"owner", bson.M{"$ne": model.ZeroAddress}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"chainId", "$chainId"}, {"owner", "$owner"}, {"nftAddress", "$nftAddress"}}},
+			{"tokenIds", bson.M{"$push": "$tokenId"}},
+		}}},
+	}
+	cur, err := client.Database(config.MongoDb).Collection(config.MongoNft).
+		Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cur, ctx)
+
+	type contractKey struct {
+		chainId uint64
+		address string
+	}
+	contracts := make(map[contractKey]bool)
+	for cur.Next(ctx) {
+		var group struct {
+			Id struct {
+				ChainId    uint64 `bson:"chainId"`
+				Owner      string `bson:"owner"`
+				NftAddress string `bson:"nftAddress"`
+			} `bson:"_id"`
+			TokenIds []string `bson:"tokenIds"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return err
+		}
+		model.SortTokenIds(group.TokenIds)
+		holding := model.Holding{
+			ChainId:    group.Id.ChainId,
+			Owner:      group.Id.Owner,
+			NftAddress: group.Id.NftAddress,
+			TokenIds:   group.TokenIds,
+			Count:      int64(len(group.TokenIds)),
+			UpdatedAt:  time.Now(),
+		}
+		if _, err := holdings.InsertOne(ctx, holding); err != nil {
+			return err
+		}
+		contracts[contractKey{holding.ChainId, holding.NftAddress}] = true
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for contract := range contracts {
+		if err := countHolders(ctx, client, config, contract.chainId, contract.address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountSupply recomputes the holder counts and supply counters of every contract with stored tokens
+func CountSupply(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{{"_id", bson.D{{"chainId", "$chainId"}, {"nftAddress", "$nftAddress"}}}}}},
+	}
+	cur, err := client.Database(config.MongoDb).Collection(config.MongoNft).
+		Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var contracts []struct {
+		Id struct {
+			ChainId    uint64 `bson:"chainId"`
+			NftAddress string `bson:"nftAddress"`
+		} `bson:"_id"`
+	}
+	if err := cur.All(ctx, &contracts); err != nil {
+		return err
+	}
+	for _, contract := range contracts {
+		if err := countHolders(ctx, client, config, contract.Id.ChainId, contract.Id.NftAddress); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countHolders stores the number of holdings of a contract as its holder count, with its supply counters
+func countHolders(ctx context.Context, client *mongo.Client, config *util.Config, chainId uint64, address string) error {
+	contract := bson.D{{"chainId", chainId}, {"nftAddress", address}}
+	holders, err := client.Database(config.MongoDb).Collection(config.MongoHolding).CountDocuments(ctx, contract)
+	if err != nil {
+		return err
+	}
+	// tokens minted before the start block are only known from later transfers, they are not counted as minted
+	tokens := client.Database(config.MongoDb).Collection(config.MongoNft)
+	minted, err := tokens.CountDocuments(ctx, append(contract, bson.E{Key: "mintTx", Value: bson.M{"$exists": true}}))
+	if err != nil {
+		return err
+	}
+	burned, err := tokens.CountDocuments(ctx, append(contract, bson.E{Key: "burned", Value: true}))
+	if err != nil {
+		return err
+	}
+	circulating, err := tokens.CountDocuments(ctx, append(contract, bson.E{Key: "burned", Value: bson.M{"$ne": true}}))
+	if err != nil {
+		return err
+	}
+
+	update := bson.D{
+		{"$set", bson.D{
+			{"holders", holders},
+			{"minted", minted},
+			{"burned", burned},
+			{"circulating", circulating},
+		}},
+		{"$setOnInsert", bson.D{{"createdAt", time.Now()}}},
+	}
+	_, err = client.Database(config.MongoDb).Collection(config.MongoContract).
+		UpdateOne(ctx, bson.D{{"chainId", chainId}, {"address", address}}, update, options.Update().SetUpsert(true))
+	return err
+}
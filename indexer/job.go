@@ -0,0 +1,505 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+	"nft-event/db"
+	"nft-event/eth"
+	"nft-event/model"
+	"nft-event/sink"
+	"nft-event/util"
+	"nft-event/worker"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BlockRange number of blocks per update
+	BlockRange int64 = 1000
+	// LogTimeout time allowed to process a single log
+	LogTimeout = 20 * time.Second
+	// WorkerQueue pending logs buffered per worker
+	WorkerQueue = 100
+)
+
+// Job indexes the event logs of the contracts of one chain into mongo
+type Job struct {
+	eth    *eth.Client
+	client *mongo.Client
+	config *util.Config
+	chain  util.ChainConfig
+
+	// nfts contracts of the current run by address
+	nfts map[common.Address]model.Nft
+	// collections contract documents of the contracts of the current run
+	collections map[common.Address]model.Contract
+
+	// standards erc165 classification of the contracts seen by discovery
+	standards *classifier
+	// txs transactions of the logs of the current run
+	txs *txCache
+	// versions metadata hash of the latest version recorded in the current run by token
+	versions   map[string]string
+	versionsMu sync.Mutex
+	// marketplaces contracts whose sale events are trusted
+	marketplaces marketplaces
+
+	// transactions whether mongo supports transactions, detected on the first commit
+	transactions *bool
+
+	// sink message broker the transfers and approvals are published to before they are committed, nil without SINK
+	sink sink.Sink
+}
+
+func NewJob(ethClient *eth.Client, client *mongo.Client, config *util.Config, chain util.ChainConfig, events sink.Sink) *Job {
+	job := &Job{eth: ethClient, client: client, config: config, chain: chain, sink: events, marketplaces: newMarketplaces(chain)}
+	job.standards = newClassifier(job.supportsInterface)
+	return job
+}
+
+// Run indexes the next block range of every contract and advances their checkpoints up to the last block whose logs are all stored
+func (j *Job) Run() {
+	start := time.Now()
+	ctx := context.Background()
+
+	// current block, blocks within the confirmation depth may still be reorganized
+	header, err := j.eth.HeaderByNumber(ctx, nil)
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+	head := header.Number.Int64()
+	j.txs = newTxCache(j.eth, j.chain.ChainId)
+	j.versions = make(map[string]string)
+
+	nfts, err := j.contracts(ctx)
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+	j.nfts = make(map[common.Address]model.Nft, len(nfts))
+	for _, nft := range nfts {
+		j.nfts[common.HexToAddress(nft.Address)] = nft
+	}
+
+	if err := j.loadContracts(ctx); err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+
+	j.retryParked(ctx)
+	// holdings left stale by an earlier run
+	j.refreshHoldings(ctx)
+
+	if safeBlock := head - int64(j.chain.Confirmations); j.chain.Discovery && safeBlock >= 0 {
+		j.discover(ctx, safeBlock)
+	}
+
+	if len(nfts) == 0 {
+		log.Infof("%s: no contracts", j.chain.Label())
+		return
+	}
+
+	// contracts with the same checkpoint, safe block and range share their log queries
+	groups := make(map[rangeKey]*contractGroup)
+	var ordered []*contractGroup
+	for _, nft := range nfts {
+		safeBlock := head - int64(nft.ConfirmationDepth(j.chain.Confirmations))
+		if safeBlock < 0 {
+			continue
+		}
+		current, err := j.checkpoint(ctx, model.HexAddress(nft.Address), initialBlock(nft.StartAt(j.chain.StartBlock), safeBlock))
+		if err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+			return
+		}
+
+		key := rangeKey{current: current, safeBlock: safeBlock, blockRange: nft.Range(BlockRange)}
+		group, ok := groups[key]
+		if !ok {
+			group = &contractGroup{rangeKey: key, priority: nft.Priority}
+			groups[key] = group
+			ordered = append(ordered, group)
+		}
+		if nft.Priority > group.priority {
+			group.priority = nft.Priority
+		}
+		group.addresses = append(group.addresses, common.HexToAddress(nft.Address))
+	}
+
+	// higher priority contracts first, then the ones furthest behind
+	sort.SliceStable(ordered, func(i, k int) bool {
+		if ordered[i].priority != ordered[k].priority {
+			return ordered[i].priority > ordered[k].priority
+		}
+		return ordered[i].current < ordered[k].current
+	})
+	for _, group := range ordered {
+		j.indexRange(ctx, group.addresses, group.current, group.safeBlock, group.blockRange)
+	}
+
+	j.refreshContracts(ctx, head)
+	j.refreshMetadata(ctx)
+
+	duration := time.Since(start)
+	log.Infof("%s: end nft event job, duration: %.2f", j.chain.Label(), duration.Seconds())
+}
+
+// rangeKey block range shared by contracts
+type rangeKey struct {
+	current    int64
+	safeBlock  int64
+	blockRange int64
+}
+
+// contractGroup contracts indexed with one log query
+type contractGroup struct {
+	rangeKey
+	addresses []common.Address
+	priority  int
+}
+
+// indexRange indexes the next block range of contracts sharing the checkpoint current
+func (j *Job) indexRange(ctx context.Context, addresses []common.Address, current, safeBlock, blockRange int64) {
+	// checkpoint is the last stored block
+	fromBlock := current + 1
+	toBlock := safeBlock
+	if toBlock-current > blockRange {
+		toBlock = current + blockRange
+	}
+
+	if toBlock < fromBlock {
+		return
+	} else {
+		log.Infof("%s: block %d - %d of %d contracts", j.chain.Label(), fromBlock, toBlock, len(addresses))
+	}
+
+	query := ethereum.FilterQuery{
+		Addresses: addresses,
+		FromBlock: big.NewInt(fromBlock),
+		ToBlock:   big.NewInt(toBlock),
+	}
+
+	logs, err := j.eth.FilterLogs(ctx, query)
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+		return
+	}
+
+	log.Infof("%s: number of event log %d", j.chain.Label(), len(logs))
+
+	results := j.processLogs(logs)
+
+	failedKeys := []string{}
+	var failed []failedLog
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		failedKeys = append(failedKeys, j.deadLetterKey(r.log))
+		parked, err := j.recordFailure(ctx, r.log, r.err)
+		if err != nil {
+			log.Error(err)
+			parked = false
+		}
+		failed = append(failed, failedLog{log: r.log, parked: parked})
+	}
+	checkpoints := rangeCheckpoints(addresses, toBlock, failed)
+
+	// logs after the checkpoint are processed again on the next run
+	batch := db.NewBatch()
+	committed := committable(results, checkpoints)
+	for _, r := range committed {
+		batch.Add(r.writes...)
+	}
+	transfers := j.transfers(committed)
+	batch.Add(j.staleHoldingWrites(transfers)...)
+	for _, address := range addresses {
+		checkpoint := checkpoints[address]
+		batch.Add(j.clearFailures(address, fromBlock, checkpoint, failedKeys))
+		if checkpoint <= current {
+			log.Warnf("%s: %s held at block %d by failed logs", j.chain.Label(), model.Address(address), checkpoint)
+			continue
+		}
+		// block doc
+		blockDoc := bson.D{
+			{"current", checkpoint},
+			{"updatedAt", time.Now()},
+		}
+		batch.Add(db.Write{
+			Collection: j.config.MongoBlock,
+			Model:      mongo.NewUpdateOneModel().SetFilter(j.blockFilter(model.Address(address))).SetUpdate(bson.M{"$set": blockDoc}),
+		})
+	}
+
+	if err := j.publish(ctx, committed); err != nil {
+		log.Errorf("%s: failed to publish block %d - %d, it is indexed again: %v", j.chain.Label(), fromBlock, toBlock, err)
+		return
+	}
+	if err := j.commit(ctx, batch); err != nil {
+		log.Error(err)
+		return
+	}
+	j.whaleAlerts(ctx, transfers)
+	j.refreshHoldings(ctx)
+	if len(failedKeys) > 0 {
+		log.Warnf("%s: %d logs failed", j.chain.Label(), len(failedKeys))
+	}
+}
+
+// contracts the contracts of the chain, the approved ones which are neither paused nor waiting for review
+// and the configured ones, which use the settings of their approved document when there is one
+func (j *Job) contracts(ctx context.Context) ([]model.Nft, error) {
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoApprovedNft)
+	cur, err := collection.Find(ctx, bson.D{{"chainId", j.chain.ChainId}})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cur, ctx)
+
+	approved := make(map[string]model.Nft)
+	var nfts []model.Nft
+	for cur.Next(ctx) {
+		nft := model.Nft{}
+		if err := cur.Decode(&nft); err != nil {
+			return nil, err
+		}
+		nft.Address = model.HexAddress(nft.Address)
+		approved[nft.Address] = nft
+		if nft.Active() {
+			nfts = append(nfts, nft)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, contract := range j.chain.Contracts {
+		address := model.HexAddress(contract)
+		if _, ok := approved[address]; !ok {
+			approved[address] = model.Nft{ChainId: j.chain.ChainId, Address: address}
+			nfts = append(nfts, approved[address])
+		}
+	}
+	return nfts, nil
+}
+
+// settings the settings of a contract, the defaults for contracts no longer indexed
+func (j *Job) settings(address common.Address) model.NftSettings {
+	return j.nfts[address].NftSettings
+}
+
+// checkpoint the checkpoint stored under key, a missing one is created at initial
+func (j *Job) checkpoint(ctx context.Context, key string, initial int64) (int64, error) {
+	block, err := j.checkpointBlock(ctx, key, initial)
+	return block.Current, err
+}
+
+// checkpointBlock the document of the checkpoint stored under key, a missing one is created at initial
+func (j *Job) checkpointBlock(ctx context.Context, key string, initial int64) (model.Block, error) {
+	update := bson.D{{"$setOnInsert", bson.D{
+		{"current", initial},
+		{"updatedAt", time.Now()},
+		{"createdAt", time.Now()},
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoBlock)
+	block := model.Block{}
+	err := collection.FindOneAndUpdate(ctx, j.blockFilter(key), update, opts).Decode(&block)
+	return block, err
+}
+
+// initialBlock checkpoint of a contract starting at startBlock, or at safeBlock when it is 0
+func initialBlock(startBlock uint64, safeBlock int64) int64 {
+	if startBlock > 0 {
+		return int64(startBlock) - 1
+	}
+	return safeBlock
+}
+
+// blockFilter filter of the checkpoint stored under key, the address of a contract
+func (j *Job) blockFilter(key string) bson.D {
+	return bson.D{
+		{"chainId", j.chain.ChainId},
+		{"nftAddress", key},
+	}
+}
+
+// result outcome of processing a log, the writes storing it or the error it failed with
+type result struct {
+	log    types.Log
+	writes []db.Write
+	err    error
+}
+
+// failedLog a log which failed to be stored, parked once it failed JOB_MAX_ATTEMPTS times
+type failedLog struct {
+	log    types.Log
+	parked bool
+}
+
+// rangeCheckpoints the checkpoints of contracts after indexing their logs up to toBlock. A failed log holds back the
+// checkpoint of its own contract to the block before it, unless it is parked: a parked log is left to the dead letter
+// retries and the checkpoint moves past it, even though it is not stored.
+func rangeCheckpoints(addresses []common.Address, toBlock int64, failed []failedLog) map[common.Address]int64 {
+	checkpoints := make(map[common.Address]int64, len(addresses))
+	for _, address := range addresses {
+		checkpoints[address] = toBlock
+	}
+	for _, f := range failed {
+		if !f.parked && int64(f.log.BlockNumber)-1 < checkpoints[f.log.Address] {
+			checkpoints[f.log.Address] = int64(f.log.BlockNumber) - 1
+		}
+	}
+	return checkpoints
+}
+
+// committable the stored results up to the checkpoints of their contracts, later ones are processed again
+func committable(results []result, checkpoints map[common.Address]int64) []result {
+	var committed []result
+	for _, r := range results {
+		if r.err == nil && int64(r.log.BlockNumber) <= checkpoints[r.log.Address] {
+			committed = append(committed, r)
+		}
+	}
+	return committed
+}
+
+// processLogs prepares the writes of logs on the worker pool and returns the results in chain order
+func (j *Job) processLogs(logs []types.Log) []result {
+	// changes of the same token must be applied in block and log order
+	sortLogs(logs)
+
+	results := make([]result, len(logs))
+	pool := worker.NewPool(j.config.JobWorkers, WorkerQueue)
+	for i, vLog := range logs {
+		i, vLog := i, vLog
+		pool.Submit(tokenKey(vLog), func() {
+			ctx, cancel := context.WithTimeout(context.Background(), LogTimeout)
+			defer cancel()
+			writes, err := j.prepareLog(ctx, vLog)
+			if err != nil {
+				log.Errorf("%s: failed to process log %s: %v", j.chain.Label(), logKey(vLog), err)
+			}
+			results[i] = result{log: vLog, writes: writes, err: err}
+		})
+	}
+	pool.Wait()
+	return results
+}
+
+// transfers the transfers of stored results
+func (j *Job) transfers(stored []result) []*model.Event {
+	var transfers []*model.Event
+	for _, r := range stored {
+		if transfer, err := model.NewTransfer(j.chain.ChainId, r.log); err == nil {
+			transfers = append(transfers, transfer)
+		}
+	}
+	return transfers
+}
+
+// staleHoldingWrites marks the holdings changed by transfers stale, in the batch storing them
+func (j *Job) staleHoldingWrites(transfers []*model.Event) []db.Write {
+	var keys []model.HoldingKey
+	for _, transfer := range transfers {
+		keys = append(keys, model.TransferHoldings(transfer)...)
+	}
+	return StaleHoldingWrites(j.chain.ChainId, keys, j.config)
+}
+
+// refreshHoldings recomputes the holdings marked stale by stored transfers.
+// A failure leaves them marked, they are recomputed on the next run.
+func (j *Job) refreshHoldings(ctx context.Context) {
+	if err := RefreshStaleHoldings(ctx, j.client, j.config, j.chain.ChainId); err != nil {
+		log.Errorf("%s: failed to refresh holdings: %v", j.chain.Label(), err)
+	}
+}
+
+// PublishTimeout time allowed to publish the events of a block range
+const PublishTimeout = 30 * time.Second
+
+// publish sends the transfers and approvals of stored results to the sink before their writes are committed.
+// Until it succeeds neither the writes nor the checkpoint are committed, so every event is published at least once.
+func (j *Job) publish(ctx context.Context, stored []result) error {
+	if j.sink == nil {
+		return nil
+	}
+	var events []*sink.Event
+	for _, r := range stored {
+		event, err := sink.NewEvent(j.chain.ChainId, r.log)
+		if err != nil {
+			continue
+		}
+		// cached by preparing the log
+		if header, err := j.txs.header(ctx, r.log.BlockHash); err == nil {
+			blockTime := time.Unix(int64(header.Time), 0).UTC()
+			event.BlockTime = &blockTime
+		}
+		events = append(events, event)
+	}
+	ctx, cancel := context.WithTimeout(ctx, PublishTimeout)
+	defer cancel()
+	return j.sink.Publish(ctx, events)
+}
+
+// commit writes the batch, in a single transaction when the server supports it
+func (j *Job) commit(ctx context.Context, batch *db.Batch) error {
+	if j.transactions == nil {
+		supported, err := db.SupportsTransactions(j.client, ctx)
+		if err != nil {
+			return err
+		}
+		if !supported {
+			log.Warn("mongo does not support transactions, block ranges are not committed atomically")
+		}
+		j.transactions = &supported
+	}
+	return db.Commit(j.client, ctx, j.config.MongoDb, batch, *j.transactions)
+}
+
+func sortLogs(logs []types.Log) {
+	sort.Slice(logs, func(i, k int) bool {
+		return logLess(logs[i], logs[k])
+	})
+}
+
+func logLess(a, b types.Log) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	return a.Index < b.Index
+}
+
+// tokenKey identifies the token a log changes, logs without token id are keyed by themselves
+func tokenKey(vLog types.Log) string {
+	if len(vLog.Topics) == 4 {
+		return vLog.Address.Hex() + vLog.Topics[3].Hex()
+	}
+	return logKey(vLog)
+}
+
+// logKey identifies a log on chain
+func logKey(vLog types.Log) string {
+	return fmt.Sprintf("%s-%d", vLog.TxHash.Hex(), vLog.Index)
+}
+
+// deadLetterKey identifies a log across chains
+func (j *Job) deadLetterKey(vLog types.Log) string {
+	return fmt.Sprintf("%d-%s", j.chain.ChainId, logKey(vLog))
+}
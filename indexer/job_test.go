@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRangeCheckpoints(t *testing.T) {
+	first := common.HexToAddress("0x01")
+	second := common.HexToAddress("0x02")
+	addresses := []common.Address{first, second}
+
+	// nothing failed, every contract reaches the end of the range
+	assert.Equal(t, map[common.Address]int64{first: 110, second: 110}, rangeCheckpoints(addresses, 110, nil))
+
+	// a failed log holds back its own contract to the block before it, the earliest one wins
+	failed := []failedLog{
+		{log: types.Log{Address: first, BlockNumber: 105}},
+		{log: types.Log{Address: first, BlockNumber: 103}},
+	}
+	assert.Equal(t, map[common.Address]int64{first: 102, second: 110}, rangeCheckpoints(addresses, 110, failed))
+
+	// a parked log does not hold back the checkpoint, the contract moves past it
+	parked := []failedLog{{log: types.Log{Address: first, BlockNumber: 103}, parked: true}}
+	assert.Equal(t, map[common.Address]int64{first: 110, second: 110}, rangeCheckpoints(addresses, 110, parked))
+	failed = append(parked, failedLog{log: types.Log{Address: first, BlockNumber: 107}})
+	assert.Equal(t, map[common.Address]int64{first: 106, second: 110}, rangeCheckpoints(addresses, 110, failed))
+
+	// a failure in the first block of the range keeps the checkpoint where it was
+	failed = []failedLog{{log: types.Log{Address: second, BlockNumber: 101}}}
+	assert.Equal(t, int64(100), rangeCheckpoints(addresses, 110, failed)[second])
+}
+
+func TestCommittable(t *testing.T) {
+	first := common.HexToAddress("0x01")
+	second := common.HexToAddress("0x02")
+	results := []result{
+		{log: types.Log{Address: first, BlockNumber: 101}},
+		{log: types.Log{Address: first, BlockNumber: 103}, err: errors.New("execution reverted")},
+		{log: types.Log{Address: first, BlockNumber: 104}},
+		{log: types.Log{Address: second, BlockNumber: 104}},
+	}
+
+	committed := committable(results, map[common.Address]int64{first: 102, second: 110})
+	assert.Len(t, committed, 2)
+	assert.Equal(t, uint64(101), committed[0].log.BlockNumber)
+	assert.Equal(t, second, committed[1].log.Address)
+
+	// a parked failure is never committed, the logs after it are
+	committed = committable(results, map[common.Address]int64{first: 110, second: 110})
+	assert.Len(t, committed, 3)
+}
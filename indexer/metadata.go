@@ -0,0 +1,286 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+	"nft-event/contracts"
+	"nft-event/db"
+	"nft-event/eth"
+	"nft-event/model"
+	"nft-event/util"
+	"time"
+)
+
+// MetadataRefreshBatch tokens whose metadata is refreshed per run
+const MetadataRefreshBatch = 100
+
+// MetadataRefreshAttempts failed refreshes in a row after which a token is given up until its next refresh
+const MetadataRefreshAttempts = 5
+
+// MetadataRetryBackoff wait after the first failed refresh of a token, doubled with every further failure
+const MetadataRetryBackoff = 10 * time.Minute
+
+// MetadataVersionWrite returns the write recording a metadata version, keyed by the time it was observed so a
+// version whose content comes back after a change is recorded again and storing the same observation twice is safe
+func MetadataVersionWrite(version *model.MetadataVersion, config *util.Config) db.Write {
+	filter := bson.D{
+		{"chainId", version.ChainId},
+		{"nftAddress", version.NftAddress},
+		{"tokenId", version.TokenId},
+		{"observedAt", version.ObservedAt},
+	}
+	return db.Write{
+		Collection: config.MongoMetadata,
+		Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$setOnInsert", version}}).SetUpsert(true),
+	}
+}
+
+// LatestMetadataHash content hash of the latest stored metadata version of a token, empty without one
+func LatestMetadataHash(ctx context.Context, client *mongo.Client, config *util.Config, chainId uint64, nftAddress, tokenId string) (string, error) {
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", nftAddress}, {"tokenId", tokenId}}
+	opts := options.FindOne().SetSort(bson.D{{"observedAt", -1}}).SetProjection(bson.D{{"hash", 1}})
+	latest := model.MetadataVersion{}
+	err := client.Database(config.MongoDb).Collection(config.MongoMetadata).FindOne(ctx, filter, opts).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return latest.Hash, err
+}
+
+// metadataVersionWrites returns the write recording the metadata of token as a new version when its hash differs
+// from the latest version of the token, recorded earlier in this run or stored
+func (j *Job) metadataVersionWrites(ctx context.Context, token *model.Token, metadata map[string]interface{}) ([]db.Write, error) {
+	key := token.NftAddress + ":" + token.TokenId
+	j.versionsMu.Lock()
+	latest, ok := j.versions[key]
+	j.versionsMu.Unlock()
+	if !ok {
+		var err error
+		if latest, err = LatestMetadataHash(ctx, j.client, j.config, token.ChainId, token.NftAddress, token.TokenId); err != nil {
+			return nil, err
+		}
+	}
+	if latest == token.MetadataHash {
+		return nil, nil
+	}
+	j.versionsMu.Lock()
+	j.versions[key] = token.MetadataHash
+	j.versionsMu.Unlock()
+	return []db.Write{MetadataVersionWrite(model.NewMetadataVersion(token, metadata), j.config)}, nil
+}
+
+// MetadataUpdateRange token ids of a batch metadata update queued one by one, larger batches queue all tokens of the contract
+const MetadataUpdateRange = 10000
+
+// prepareMetadataUpdate returns the writes storing an erc4906 metadata update and queuing the refresh of its tokens
+func (j *Job) prepareMetadataUpdate(ctx context.Context, update *model.Event, vLog types.Log) ([]db.Write, error) {
+	var err error
+	if update.BlockTime, err = j.blockTime(ctx, vLog); err != nil {
+		return nil, err
+	}
+	log.Infof("%s: %s of %s tokens %s-%s", j.chain.Label(), update.Type, update.NftAddress, update.TokenId, update.ToTokenId)
+	return MetadataUpdateWrites(update, j.config)
+}
+
+// MetadataUpdateWrites returns the writes storing an erc4906 metadata update in the events collection
+// and requesting a metadata refresh of the tokens it names
+func MetadataUpdateWrites(update *model.Event, config *util.Config) ([]db.Write, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	tokens := bson.D{{"chainId", update.ChainId}, {"nftAddress", update.NftAddress}}
+	if ids := updatedTokenIds(update); ids != nil {
+		tokens = append(tokens, bson.E{Key: "tokenId", Value: bson.M{"$in": ids}})
+	}
+	return []db.Write{
+		EventWrite(update, config),
+		{
+			Collection: config.MongoNft,
+			Model:      mongo.NewUpdateManyModel().SetFilter(tokens).SetUpdate(bson.D{{"$set", bson.D{{"metadataRefresh", true}}}}),
+		},
+	}, nil
+}
+
+// updatedTokenIds the token ids named by a metadata update, nil for a batch of more than MetadataUpdateRange tokens
+func updatedTokenIds(update *model.Event) []string {
+	if update.ToTokenId == "" {
+		return []string{update.TokenId}
+	}
+	from, _ := new(big.Int).SetString(update.TokenId, 10)
+	to, _ := new(big.Int).SetString(update.ToTokenId, 10)
+	if from == nil || to == nil || to.Cmp(from) < 0 {
+		return []string{update.TokenId}
+	}
+	if new(big.Int).Sub(to, from).Cmp(big.NewInt(MetadataUpdateRange)) >= 0 {
+		return nil
+	}
+	var ids []string
+	for id := new(big.Int).Set(from); id.Cmp(to) <= 0; id.Add(id, big.NewInt(1)) {
+		ids = append(ids, model.TokenId(id))
+	}
+	return ids
+}
+
+// refreshMetadata refetches the metadata of the tokens whose refresh was requested, then of the tokens of contracts
+// with metadata refresh not fetched within their refresh interval, up to MetadataRefreshBatch tokens per run.
+// Tokens whose metadata could not be fetched wait out their backoff, see refreshFailure.
+func (j *Job) refreshMetadata(ctx context.Context) {
+	collection := j.client.Database(j.config.MongoDb).Collection(j.config.MongoNft)
+	queries := []bson.D{{
+		{"chainId", j.chain.ChainId},
+		{"metadataRefresh", true},
+	}}
+	for address, nft := range j.nfts {
+		if nft.Metadata != model.MetadataRefresh {
+			continue
+		}
+		due := time.Now().Add(-time.Duration(nft.RefreshSeconds) * time.Second)
+		queries = append(queries, bson.D{
+			{"chainId", j.chain.ChainId},
+			{"nftAddress", model.Address(address)},
+			{"$or", bson.A{
+				bson.D{{"metadataRefreshedAt", bson.M{"$lt": due}}},
+				bson.D{{"metadataRefreshedAt", bson.M{"$exists": false}}},
+			}},
+		})
+	}
+
+	refreshed := 0
+	for _, query := range queries {
+		if refreshed == MetadataRefreshBatch {
+			return
+		}
+		// burned tokens have no token uri
+		query = append(query, bson.E{Key: "owner", Value: bson.M{"$ne": model.ZeroAddress}})
+		query = append(query, bson.E{Key: "metadataRetryAt", Value: bson.M{"$not": bson.M{"$gt": time.Now()}}})
+		opts := options.Find().SetSort(bson.D{{"metadataRefreshedAt", 1}}).SetLimit(int64(MetadataRefreshBatch - refreshed))
+		cur, err := collection.Find(ctx, query, opts)
+		if err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+			return
+		}
+		var tokens []model.Token
+		if err := cur.All(ctx, &tokens); err != nil {
+			log.Errorf("%s: %v", j.chain.Label(), err)
+			return
+		}
+		for _, token := range tokens {
+			refreshed++
+			address := common.HexToAddress(token.NftAddress)
+			settings := j.settings(address)
+			if _, ok := j.nfts[address]; !ok || !settings.FetchMetadata() {
+				// requested for a contract which is not indexed or skips metadata
+				j.clearRefresh(ctx, token)
+				continue
+			}
+			changed, err := RefreshTokenMetadata(ctx, j.eth, j.client, j.config, token, settings.FetchMedia())
+			if err == ErrMetadataNotFetched {
+				log.Warnf("%s: failed to refresh metadata of %s %s, attempt %d", j.chain.Label(), token.NftAddress, token.TokenId, token.MetadataRefreshFailures+1)
+				j.updateRefresh(ctx, token, refreshFailure(token.MetadataRefreshFailures, time.Now()))
+				continue
+			}
+			if err != nil {
+				log.Errorf("%s: failed to refresh metadata of %s %s: %v", j.chain.Label(), token.NftAddress, token.TokenId, err)
+				continue
+			}
+			if changed {
+				log.Infof("%s: metadata of %s %s changed", j.chain.Label(), token.NftAddress, token.TokenId)
+			}
+		}
+	}
+}
+
+// refreshFailure returns the update of a token whose metadata could not be fetched after failures failures in a row.
+// The token is tried again after a backoff doubling with every failure, after MetadataRefreshAttempts failures its
+// refresh request is dropped and it counts as refreshed, so it neither blocks the requested nor the scheduled refreshes.
+func refreshFailure(failures int, now time.Time) bson.D {
+	failures++
+	if failures >= MetadataRefreshAttempts {
+		return bson.D{
+			{"$set", bson.D{{"metadataRefreshedAt", now}}},
+			{"$unset", bson.D{{"metadataRefresh", ""}, {"metadataRefreshFailures", ""}, {"metadataRetryAt", ""}}},
+		}
+	}
+	return bson.D{{"$set", bson.D{
+		{"metadataRefreshFailures", failures},
+		{"metadataRetryAt", now.Add(MetadataRetryBackoff << (failures - 1))},
+	}}}
+}
+
+// clearRefresh drops the refresh request of token
+func (j *Job) clearRefresh(ctx context.Context, token model.Token) {
+	j.updateRefresh(ctx, token, bson.D{{"$unset", bson.D{{"metadataRefresh", ""}}}})
+}
+
+// updateRefresh applies update to the refresh state of token
+func (j *Job) updateRefresh(ctx context.Context, token model.Token, update bson.D) {
+	filter := bson.D{{"chainId", token.ChainId}, {"nftAddress", token.NftAddress}, {"tokenId", token.TokenId}}
+	_, err := j.client.Database(j.config.MongoDb).Collection(j.config.MongoNft).UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Errorf("%s: %v", j.chain.Label(), err)
+	}
+}
+
+// ErrMetadataNotFetched the metadata of a token could not be fetched
+var ErrMetadataNotFetched = errors.New("metadata not fetched")
+
+// RefreshTokenMetadata refetches the metadata of a stored token and reports whether its content hash changed.
+// A changed token gets the new metadata and a new metadata version, the image is downloaded again with media
+// when it changed. Either way the refresh time is updated and the refresh request dropped.
+// When the metadata cannot be fetched nothing is written and ErrMetadataNotFetched returned, the caller decides
+// whether the token is tried again.
+func RefreshTokenMetadata(ctx context.Context, ethClient *eth.Client, client *mongo.Client, config *util.Config, stored model.Token, media bool) (bool, error) {
+	instance, err := contracts.NewToken(common.HexToAddress(stored.NftAddress), ethClient)
+	if err != nil {
+		return false, err
+	}
+	fetched := stored
+	fetched.TokenUri, fetched.Name, fetched.Description, fetched.Image, fetched.MetadataHash = "", "", "", "", ""
+	metadata := fetchMetadata(ctx, instance, stored.TokenId, &fetched, false)
+	if fetched.MetadataHash == "" {
+		return false, ErrMetadataNotFetched
+	}
+
+	// a version is recorded whenever the content differs from the latest one, also when it comes back after a change
+	latest, err := LatestMetadataHash(ctx, client, config, stored.ChainId, stored.NftAddress, stored.TokenId)
+	if err != nil {
+		return false, err
+	}
+
+	filter := bson.D{{"chainId", stored.ChainId}, {"nftAddress", stored.NftAddress}, {"tokenId", stored.TokenId}}
+	set := bson.D{{"metadataRefreshedAt", time.Now()}}
+	changed := fetched.MetadataHash != stored.MetadataHash
+	if changed {
+		set = append(set,
+			bson.E{Key: "tokenUri", Value: fetched.TokenUri},
+			bson.E{Key: "name", Value: fetched.Name},
+			bson.E{Key: "description", Value: fetched.Description},
+			bson.E{Key: "image", Value: fetched.Image},
+			bson.E{Key: "metadataHash", Value: fetched.MetadataHash},
+			bson.E{Key: "updatedAt", Value: time.Now()},
+		)
+		if media && fetched.Image != stored.Image {
+			set = append(set, bson.E{Key: "mimeType", Value: detectMimeType(fetched.Image)})
+		}
+	}
+
+	batch := db.NewBatch()
+	batch.Add(db.Write{
+		Collection: config.MongoNft,
+		Model: mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{
+			{"$set", set},
+			{"$unset", bson.D{{"metadataRefresh", ""}, {"metadataRefreshFailures", ""}, {"metadataRetryAt", ""}}},
+		}),
+	})
+	if fetched.MetadataHash != latest {
+		batch.Add(MetadataVersionWrite(model.NewMetadataVersion(&fetched, metadata), config))
+	}
+	return changed, db.Commit(client, ctx, config.MongoDb, batch, false)
+}
@@ -0,0 +1,162 @@
+package indexer
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"math/big"
+	"nft-event/contracts"
+	"nft-event/model"
+	"sort"
+)
+
+const (
+	// RoyaltySamples tokens compared to decide whether all tokens of a contract have the same royalty
+	RoyaltySamples = 20
+	// RoyaltyMinSamples samples which must answer and agree before their royalty is taken for the whole contract
+	RoyaltyMinSamples = 10
+)
+
+// loadContracts loads the contract documents of the contracts of the current run
+func (j *Job) loadContracts(ctx context.Context) error {
+	addresses := make([]string, 0, len(j.nfts))
+	for address := range j.nfts {
+		addresses = append(addresses, model.Address(address))
+	}
+	filter := bson.D{
+		{"chainId", j.chain.ChainId},
+		{"address", bson.M{"$in": addresses}},
+	}
+	cur, err := j.client.Database(j.config.MongoDb).Collection(j.config.MongoContract).Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var docs []model.Contract
+	if err := cur.All(ctx, &docs); err != nil {
+		return err
+	}
+	j.collections = make(map[common.Address]model.Contract, len(docs))
+	for _, doc := range docs {
+		j.collections[common.HexToAddress(doc.Address)] = doc
+	}
+	return nil
+}
+
+// setRoyalty sets the erc2981 royalty of token, taken from the contract once sampling proved all its tokens have
+// the same one and asked for the token until then. A failing call is logged and leaves the royalty out.
+func (j *Job) setRoyalty(ctx context.Context, address common.Address, tokenId string, token *model.Token) {
+	contract, ok := j.collections[address]
+	if !ok || !contract.Supports("erc2981") {
+		return
+	}
+	if contract.Royalty != nil {
+		token.Royalty = contract.Royalty
+		return
+	}
+
+	id, ok := new(big.Int).SetString(tokenId, 10)
+	if !ok {
+		return
+	}
+	royalty, err := j.royaltyOf(ctx, address, id)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	token.Royalty = royalty
+}
+
+// royaltyOf asks the erc2981 royalty of a token
+func (j *Job) royaltyOf(ctx context.Context, address common.Address, tokenId *big.Int) (*model.Royalty, error) {
+	instance, err := contracts.NewCollection(address, j.eth)
+	if err != nil {
+		return nil, err
+	}
+	info, err := instance.RoyaltyInfo(&bind.CallOpts{Context: ctx}, tokenId, big.NewInt(model.RoyaltyBasis))
+	if err != nil {
+		return nil, err
+	}
+	return &model.Royalty{Receiver: model.Address(info.Receiver), Bps: info.RoyaltyAmount.Uint64()}, nil
+}
+
+// contractRoyalty samples the royalty of stored tokens of a contract, spread over their token ids.
+// It returns the royalty when at least RoyaltyMinSamples samples answer and all of them agree, nil otherwise.
+func (j *Job) contractRoyalty(ctx context.Context, address common.Address) (*model.Royalty, error) {
+	filter := bson.D{
+		{"chainId", j.chain.ChainId},
+		{"nftAddress", model.Address(address)},
+	}
+	opts := options.Find().SetProjection(bson.D{{"tokenId", 1}})
+	cur, err := j.client.Database(j.config.MongoDb).Collection(j.config.MongoNft).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for cur.Next(ctx) {
+		token := model.Token{}
+		if err := cur.Decode(&token); err != nil {
+			_ = cur.Close(ctx)
+			return nil, err
+		}
+		ids = append(ids, token.TokenId)
+	}
+	err = cur.Err()
+	_ = cur.Close(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sampleRoyalty(ctx, spreadSample(ids, RoyaltySamples), func(ctx context.Context, tokenId *big.Int) (*model.Royalty, error) {
+		return j.royaltyOf(ctx, address, tokenId)
+	})
+}
+
+// spreadSample up to n of the token ids, evenly spread from the lowest to the highest id
+func spreadSample(ids []string, n int) []*big.Int {
+	sorted := make([]*big.Int, 0, len(ids))
+	for _, id := range ids {
+		if value, ok := new(big.Int).SetString(id, 10); ok {
+			sorted = append(sorted, value)
+		}
+	}
+	sort.Slice(sorted, func(i, k int) bool {
+		return sorted[i].Cmp(sorted[k]) < 0
+	})
+	if len(sorted) <= n {
+		return sorted
+	}
+	sample := make([]*big.Int, n)
+	for i := range sample {
+		sample[i] = sorted[i*(len(sorted)-1)/(n-1)]
+	}
+	return sample
+}
+
+// sampleRoyalty the royalty all sampled tokens agree on, nil when they differ or fewer than RoyaltyMinSamples answered
+func sampleRoyalty(ctx context.Context, sample []*big.Int, royaltyOf func(ctx context.Context, tokenId *big.Int) (*model.Royalty, error)) (*model.Royalty, error) {
+	var uniform *model.Royalty
+	answered := 0
+	for _, id := range sample {
+		royalty, err := royaltyOf(ctx, id)
+		if err != nil && NotSupported(err) {
+			// burned tokens may revert
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if uniform == nil {
+			uniform = royalty
+		} else if *uniform != *royalty {
+			return nil, nil
+		}
+		answered++
+	}
+	if answered < RoyaltyMinSamples {
+		return nil, nil
+	}
+	return uniform, nil
+}
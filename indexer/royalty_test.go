@@ -0,0 +1,75 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"nft-event/model"
+	"strconv"
+	"testing"
+)
+
+// tokenIds the ids from to to as strings, in reverse like an unsorted collection
+func tokenIds(from, to int) []string {
+	var ids []string
+	for id := to; id >= from; id-- {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return ids
+}
+
+func TestSpreadSample(t *testing.T) {
+	// numeric order, first and last included
+	sample := spreadSample(tokenIds(1, 1000), 5)
+	assert.Equal(t, []*big.Int{big.NewInt(1), big.NewInt(250), big.NewInt(500), big.NewInt(750), big.NewInt(1000)}, sample)
+
+	assert.Len(t, spreadSample(tokenIds(1, 3), 5), 3)
+	assert.Empty(t, spreadSample(nil, 5))
+}
+
+func TestSampleRoyalty(t *testing.T) {
+	uniform := &model.Royalty{Receiver: model.Address(saleSeller), Bps: 500}
+	override := &model.Royalty{Receiver: model.Address(saleBuyer), Bps: 1000}
+	// royaltyOf answers uniform except for the listed ids
+	royaltyOf := func(answers map[int64]error, overrides ...int64) func(context.Context, *big.Int) (*model.Royalty, error) {
+		return func(_ context.Context, id *big.Int) (*model.Royalty, error) {
+			if err, ok := answers[id.Int64()]; ok {
+				return nil, err
+			}
+			for _, overridden := range overrides {
+				if id.Int64() == overridden {
+					return override, nil
+				}
+			}
+			return uniform, nil
+		}
+	}
+
+	royalty, err := sampleRoyalty(context.Background(), spreadSample(tokenIds(1, 1000), RoyaltySamples), royaltyOf(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, uniform, royalty)
+
+	// a single answering token proves nothing
+	royalty, err = sampleRoyalty(context.Background(), spreadSample(tokenIds(1, 1), RoyaltySamples), royaltyOf(nil))
+	assert.NoError(t, err)
+	assert.Nil(t, royalty)
+
+	// an override of the last token is sampled
+	royalty, err = sampleRoyalty(context.Background(), spreadSample(tokenIds(1, 1000), RoyaltySamples), royaltyOf(nil, 1000))
+	assert.NoError(t, err)
+	assert.Nil(t, royalty)
+
+	// reverting tokens do not count as answers
+	reverts := make(map[int64]error)
+	for _, id := range spreadSample(tokenIds(1, 1000), RoyaltySamples)[:RoyaltySamples-RoyaltyMinSamples+1] {
+		reverts[id.Int64()] = errors.New("execution reverted")
+	}
+	royalty, err = sampleRoyalty(context.Background(), spreadSample(tokenIds(1, 1000), RoyaltySamples), royaltyOf(reverts))
+	assert.NoError(t, err)
+	assert.Nil(t, royalty)
+
+	// calls without an answer fail the sampling
+	_, err = sampleRoyalty(context.Background(), spreadSample(tokenIds(1, 1000), RoyaltySamples), royaltyOf(map[int64]error{1: errors.New("connection refused")}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,370 @@
+package indexer
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"math/big"
+	"nft-event/model"
+	"nft-event/util"
+	"strings"
+	"time"
+)
+
+// MarketplaceAbi events of the marketplaces whose sales are decoded
+const MarketplaceAbi = `[
+	{"anonymous":false,"name":"OrderFulfilled","type":"event","inputs":[
+		{"indexed":false,"name":"orderHash","type":"bytes32"},
+		{"indexed":true,"name":"offerer","type":"address"},
+		{"indexed":true,"name":"zone","type":"address"},
+		{"indexed":false,"name":"recipient","type":"address"},
+		{"indexed":false,"name":"offer","type":"tuple[]","components":[
+			{"name":"itemType","type":"uint8"},
+			{"name":"token","type":"address"},
+			{"name":"identifier","type":"uint256"},
+			{"name":"amount","type":"uint256"}]},
+		{"indexed":false,"name":"consideration","type":"tuple[]","components":[
+			{"name":"itemType","type":"uint8"},
+			{"name":"token","type":"address"},
+			{"name":"identifier","type":"uint256"},
+			{"name":"amount","type":"uint256"},
+			{"name":"recipient","type":"address"}]}]},
+	{"anonymous":false,"name":"TakerAsk","type":"event","inputs":[
+		{"indexed":false,"name":"orderHash","type":"bytes32"},
+		{"indexed":false,"name":"orderNonce","type":"uint256"},
+		{"indexed":true,"name":"taker","type":"address"},
+		{"indexed":true,"name":"maker","type":"address"},
+		{"indexed":true,"name":"strategy","type":"address"},
+		{"indexed":false,"name":"currency","type":"address"},
+		{"indexed":false,"name":"collection","type":"address"},
+		{"indexed":false,"name":"tokenId","type":"uint256"},
+		{"indexed":false,"name":"amount","type":"uint256"},
+		{"indexed":false,"name":"price","type":"uint256"}]},
+	{"anonymous":false,"name":"TakerBid","type":"event","inputs":[
+		{"indexed":false,"name":"orderHash","type":"bytes32"},
+		{"indexed":false,"name":"orderNonce","type":"uint256"},
+		{"indexed":true,"name":"taker","type":"address"},
+		{"indexed":true,"name":"maker","type":"address"},
+		{"indexed":true,"name":"strategy","type":"address"},
+		{"indexed":false,"name":"currency","type":"address"},
+		{"indexed":false,"name":"collection","type":"address"},
+		{"indexed":false,"name":"tokenId","type":"uint256"},
+		{"indexed":false,"name":"amount","type":"uint256"},
+		{"indexed":false,"name":"price","type":"uint256"}]},
+	{"anonymous":false,"name":"OrdersMatched","type":"event","inputs":[
+		{"indexed":false,"name":"buyHash","type":"bytes32"},
+		{"indexed":false,"name":"sellHash","type":"bytes32"},
+		{"indexed":true,"name":"maker","type":"address"},
+		{"indexed":true,"name":"taker","type":"address"},
+		{"indexed":false,"name":"price","type":"uint256"},
+		{"indexed":true,"name":"metadata","type":"bytes32"}]}
+]`
+
+// seaport contracts 1.1, 1.4 and 1.5, deployed at the same address on every chain
+var seaportContracts = []string{
+	"0x00000000006c3852cbef3e08e8df289169ede581",
+	"0x00000000000001ad428e4906ae43d8f9852d0dd6",
+	"0x00000000000000adc04c56bf30ac9d3c0aaf14dc",
+}
+
+// KnownMarketplaces contracts whose sale events are trusted by chain id and marketplace.
+// The marketplaces of a chain config are added to them.
+var KnownMarketplaces = map[uint64]map[string][]string{
+	1: {
+		model.MarketplaceSeaport:   seaportContracts,
+		model.MarketplaceLooksRare: {"0x59728544b08ab483533076417fbbb2fd0b17ce3a"},
+		model.MarketplaceWyvern:    {"0x7be8076f4ea4a4ad08075c2508e481d6c946d12b", "0x7f268357a8c2552623316e2562d90e642bb538e5"},
+	},
+	5:     {model.MarketplaceSeaport: seaportContracts},
+	10:    {model.MarketplaceSeaport: seaportContracts},
+	137:   {model.MarketplaceSeaport: seaportContracts},
+	42161: {model.MarketplaceSeaport: seaportContracts},
+}
+
+// marketplaces marketplace of every contract whose sale events are trusted.
+// Anyone can emit a marketplace event, only the ones of these contracts are decoded.
+type marketplaces map[common.Address]string
+
+// newMarketplaces the known marketplace contracts of chain and the ones it configures
+func newMarketplaces(chain util.ChainConfig) marketplaces {
+	trusted := make(marketplaces)
+	for _, listed := range []map[string][]string{KnownMarketplaces[chain.ChainId], chain.Marketplaces} {
+		for marketplace, contracts := range listed {
+			switch marketplace {
+			case model.MarketplaceSeaport, model.MarketplaceLooksRare, model.MarketplaceWyvern:
+			default:
+				log.Warnf("%s: no sale events are decoded for marketplace %q", chain.Label(), marketplace)
+				continue
+			}
+			for _, contract := range contracts {
+				trusted[common.HexToAddress(contract)] = marketplace
+			}
+		}
+	}
+	return trusted
+}
+
+// seaport item types
+const (
+	itemNative uint8 = iota
+	itemErc20
+	itemErc721
+	itemErc1155
+	itemErc721WithCriteria
+)
+
+var marketplaceAbi = mustParseAbi(MarketplaceAbi)
+
+var (
+	orderFulfilledSigHash = marketplaceAbi.Events["OrderFulfilled"].ID
+	takerAskSigHash       = marketplaceAbi.Events["TakerAsk"].ID
+	takerBidSigHash       = marketplaceAbi.Events["TakerBid"].ID
+	ordersMatchedSigHash  = marketplaceAbi.Events["OrdersMatched"].ID
+)
+
+type spentItem struct {
+	ItemType   uint8
+	Token      common.Address
+	Identifier *big.Int
+	Amount     *big.Int
+}
+
+type receivedItem struct {
+	ItemType   uint8
+	Token      common.Address
+	Identifier *big.Int
+	Amount     *big.Int
+	Recipient  common.Address
+}
+
+type orderFulfilled struct {
+	OrderHash     [32]byte
+	Recipient     common.Address
+	Offer         []spentItem
+	Consideration []receivedItem
+}
+
+type takerOrder struct {
+	OrderHash  [32]byte
+	OrderNonce *big.Int
+	Currency   common.Address
+	Collection common.Address
+	TokenId    *big.Int
+	Amount     *big.Int
+	Price      *big.Int
+}
+
+type ordersMatched struct {
+	BuyHash  [32]byte
+	SellHash [32]byte
+	Price    *big.Int
+}
+
+func mustParseAbi(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// detectSale the sale of the token moved by transfer in the transaction tx sent by sender, nil when it was not sold.
+// Marketplace events of the receipt emitted by the trusted contracts are decoded first, otherwise the ether sent by the buyer or the erc20 tokens
+// paid by the buyer in the same transaction are split evenly among the tokens the buyer received.
+// Mints and burns are never sales.
+func detectSale(transfer *model.Event, tx *types.Transaction, sender common.Address, receipt *types.Receipt, trusted marketplaces) *model.Sale {
+	if transfer.From == model.ZeroAddress || transfer.To == model.ZeroAddress || receipt == nil {
+		return nil
+	}
+	price, currency, marketplace := marketplaceSale(transfer, receipt.Logs, trusted)
+	if price == nil {
+		price, currency, marketplace = paymentSale(transfer, tx, sender, receipt.Logs)
+	}
+	if price == nil || price.Sign() == 0 {
+		return nil
+	}
+	return &model.Sale{
+		ChainId:     transfer.ChainId,
+		Tx:          transfer.Tx,
+		LogIndex:    transfer.LogIndex,
+		BlockNumber: transfer.BlockNumber,
+		NftAddress:  transfer.NftAddress,
+		TokenId:     transfer.TokenId,
+		Seller:      transfer.From,
+		Buyer:       transfer.To,
+		Price:       model.TokenId(price),
+		Currency:    model.Address(currency),
+		Marketplace: marketplace,
+		BlockTime:   transfer.BlockTime,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// marketplaceSale the price and currency of transfer in the marketplace events of logs emitted by the trusted contracts
+func marketplaceSale(transfer *model.Event, logs []*types.Log, trusted marketplaces) (*big.Int, common.Address, string) {
+	var matched []*types.Log
+	for _, vLog := range logs {
+		if len(vLog.Topics) == 0 {
+			continue
+		}
+		marketplace := trusted[vLog.Address]
+		switch {
+		case vLog.Topics[0] == orderFulfilledSigHash && marketplace == model.MarketplaceSeaport:
+			if price, currency, ok := seaportSale(transfer, vLog); ok {
+				return price, currency, model.MarketplaceSeaport
+			}
+		case (vLog.Topics[0] == takerAskSigHash || vLog.Topics[0] == takerBidSigHash) && marketplace == model.MarketplaceLooksRare:
+			if price, currency, ok := looksRareSale(transfer, vLog); ok {
+				return price, currency, model.MarketplaceLooksRare
+			}
+		case vLog.Topics[0] == ordersMatchedSigHash && marketplace == model.MarketplaceWyvern:
+			matched = append(matched, vLog)
+		}
+	}
+
+	// wyvern orders do not name the token, only a single order per transaction is attributed
+	if len(matched) != 1 {
+		return nil, common.Address{}, ""
+	}
+	var order ordersMatched
+	if err := marketplaceAbi.UnpackIntoInterface(&order, "OrdersMatched", matched[0].Data); err != nil {
+		return nil, common.Address{}, ""
+	}
+	currency, _ := erc20Payment(transfer, logs)
+	return split(order.Price, nftsReceived(transfer, logs)), currency, model.MarketplaceWyvern
+}
+
+// seaportSale the price of transfer in an OrderFulfilled log. A listing offers the token and considers the payment,
+// an accepted offer offers the payment and considers the token. The payment is split among the tokens of the order.
+func seaportSale(transfer *model.Event, vLog *types.Log) (*big.Int, common.Address, bool) {
+	var order orderFulfilled
+	if err := marketplaceAbi.UnpackIntoInterface(&order, "OrderFulfilled", vLog.Data); err != nil {
+		return nil, common.Address{}, false
+	}
+
+	var offered, considered, nfts int64
+	for _, item := range order.Offer {
+		if isNft(item.ItemType) {
+			nfts++
+			if isToken(transfer, item.Token, item.Identifier) {
+				offered++
+			}
+		}
+	}
+	for _, item := range order.Consideration {
+		if isNft(item.ItemType) {
+			nfts++
+			if isToken(transfer, item.Token, item.Identifier) {
+				considered++
+			}
+		}
+	}
+	if offered+considered == 0 {
+		return nil, common.Address{}, false
+	}
+
+	// payments of the first currency, fees included
+	price := new(big.Int)
+	var currency *common.Address
+	pay := func(itemType uint8, token common.Address, amount *big.Int) {
+		if itemType != itemNative && itemType != itemErc20 {
+			return
+		}
+		if currency == nil {
+			currency = &token
+		}
+		if token == *currency {
+			price.Add(price, amount)
+		}
+	}
+	if offered > 0 {
+		for _, item := range order.Consideration {
+			pay(item.ItemType, item.Token, item.Amount)
+		}
+	} else {
+		for _, item := range order.Offer {
+			pay(item.ItemType, item.Token, item.Amount)
+		}
+	}
+	if currency == nil {
+		return nil, common.Address{}, false
+	}
+	return split(price, nfts), *currency, true
+}
+
+// looksRareSale the price of transfer in a TakerAsk or TakerBid log
+func looksRareSale(transfer *model.Event, vLog *types.Log) (*big.Int, common.Address, bool) {
+	var order takerOrder
+	if err := marketplaceAbi.UnpackIntoInterface(&order, "TakerAsk", vLog.Data); err != nil {
+		return nil, common.Address{}, false
+	}
+	if !isToken(transfer, order.Collection, order.TokenId) {
+		return nil, common.Address{}, false
+	}
+	return order.Price, order.Currency, true
+}
+
+// paymentSale the price of transfer from the ether the buyer sent with the transaction, or else the erc20 tokens the buyer paid
+func paymentSale(transfer *model.Event, tx *types.Transaction, sender common.Address, logs []*types.Log) (*big.Int, common.Address, string) {
+	nfts := nftsReceived(transfer, logs)
+	if tx != nil && tx.Value().Sign() > 0 && model.Address(sender) == transfer.To {
+		return split(tx.Value(), nfts), common.Address{}, model.MarketplaceUnknown
+	}
+	currency, paid := erc20Payment(transfer, logs)
+	if paid == nil {
+		return nil, common.Address{}, ""
+	}
+	return split(paid, nfts), currency, model.MarketplaceUnknown
+}
+
+// erc20Payment the amount of the first erc20 token the buyer of transfer sent in logs
+func erc20Payment(transfer *model.Event, logs []*types.Log) (common.Address, *big.Int) {
+	var currency *common.Address
+	paid := new(big.Int)
+	for _, vLog := range logs {
+		if len(vLog.Topics) != 3 || vLog.Topics[0] != model.TransferSigHash || model.TopicAddress(vLog.Topics[1]) != transfer.To {
+			continue
+		}
+		if currency == nil {
+			currency = &vLog.Address
+		}
+		if vLog.Address == *currency {
+			paid.Add(paid, new(big.Int).SetBytes(vLog.Data))
+		}
+	}
+	if currency == nil {
+		return common.Address{}, nil
+	}
+	return *currency, paid
+}
+
+// nftsReceived the number of erc721 tokens the buyer of transfer received in logs, at least one
+func nftsReceived(transfer *model.Event, logs []*types.Log) int64 {
+	var n int64
+	for _, vLog := range logs {
+		if len(vLog.Topics) == 4 && vLog.Topics[0] == model.TransferSigHash && model.TopicAddress(vLog.Topics[2]) == transfer.To {
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
+
+func isNft(itemType uint8) bool {
+	return itemType == itemErc721 || itemType == itemErc721WithCriteria || itemType == itemErc1155
+}
+
+func isToken(transfer *model.Event, address common.Address, tokenId *big.Int) bool {
+	return tokenId != nil && model.Address(address) == transfer.NftAddress && model.TokenId(tokenId) == transfer.TokenId
+}
+
+// split price evenly among n tokens
+func split(price *big.Int, n int64) *big.Int {
+	if price == nil || n <= 1 {
+		return price
+	}
+	return new(big.Int).Div(price, big.NewInt(n))
+}
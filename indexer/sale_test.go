@@ -0,0 +1,131 @@
+package indexer
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"nft-event/model"
+	"nft-event/util"
+	"testing"
+)
+
+var (
+	saleNft    = common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	saleSeller = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	saleBuyer  = common.HexToAddress("0x2222222222222222222222222222222222222222")
+	saleWeth   = common.HexToAddress("0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2")
+
+	saleSeaport      = common.HexToAddress("0x00000000000000adc04c56bf30ac9d3c0aaf14dc")
+	saleMarketplaces = newMarketplaces(util.ChainConfig{ChainId: 1})
+)
+
+func nftTransferLog(from, to common.Address, tokenId int64) *types.Log {
+	return &types.Log{
+		Address: saleNft,
+		Topics:  []common.Hash{model.TransferSigHash, from.Hash(), to.Hash(), common.BigToHash(big.NewInt(tokenId))},
+	}
+}
+
+func erc20TransferLog(token, from, to common.Address, amount int64) *types.Log {
+	return &types.Log{
+		Address: token,
+		Topics:  []common.Hash{model.TransferSigHash, from.Hash(), to.Hash()},
+		Data:    common.BigToHash(big.NewInt(amount)).Bytes(),
+	}
+}
+
+func saleTransfer(t *testing.T, vLog *types.Log) *model.Event {
+	transfer, err := model.NewTransfer(1, *vLog)
+	assert.NoError(t, err)
+	return transfer
+}
+
+// seaportReceipt the receipt of a seaport listing of token 7 bought for 1000 wei, the OrderFulfilled log emitted by emitter
+func seaportReceipt(t *testing.T, emitter common.Address) (*types.Log, *types.Receipt) {
+	data, err := marketplaceAbi.Events["OrderFulfilled"].Inputs.NonIndexed().Pack(
+		[32]byte{1},
+		saleBuyer,
+		[]spentItem{{ItemType: itemErc721, Token: saleNft, Identifier: big.NewInt(7), Amount: big.NewInt(1)}},
+		[]receivedItem{
+			{ItemType: itemNative, Token: common.Address{}, Identifier: big.NewInt(0), Amount: big.NewInt(975), Recipient: saleSeller},
+			{ItemType: itemNative, Token: common.Address{}, Identifier: big.NewInt(0), Amount: big.NewInt(25), Recipient: common.HexToAddress("0x3333333333333333333333333333333333333333")},
+		},
+	)
+	assert.NoError(t, err)
+	transferLog := nftTransferLog(saleSeller, saleBuyer, 7)
+	return transferLog, &types.Receipt{Logs: []*types.Log{
+		transferLog,
+		{Address: emitter, Topics: []common.Hash{orderFulfilledSigHash, saleSeller.Hash(), {}}, Data: data},
+	}}
+}
+
+func TestDetectSeaportSale(t *testing.T) {
+	transferLog, receipt := seaportReceipt(t, saleSeaport)
+	sale := detectSale(saleTransfer(t, transferLog), types.NewTransaction(0, saleNft, big.NewInt(1000), 0, nil, nil), saleBuyer, receipt, saleMarketplaces)
+	assert.NotNil(t, sale)
+	assert.Equal(t, "1000", sale.Price)
+	assert.Equal(t, model.ZeroAddress, sale.Currency)
+	assert.Equal(t, model.MarketplaceSeaport, sale.Marketplace)
+	assert.Equal(t, model.Address(saleSeller), sale.Seller)
+	assert.Equal(t, model.Address(saleBuyer), sale.Buyer)
+	assert.NoError(t, sale.Validate())
+}
+
+func TestDetectSpoofedSale(t *testing.T) {
+	// any contract can emit OrderFulfilled, its made up price is ignored
+	spoofer := common.HexToAddress("0x5555555555555555555555555555555555555555")
+	transferLog, receipt := seaportReceipt(t, spoofer)
+	assert.Nil(t, detectSale(saleTransfer(t, transferLog), types.NewTransaction(0, saleNft, big.NewInt(0), 0, nil, nil), saleBuyer, receipt, saleMarketplaces))
+
+	// unless the chain config trusts it
+	trusted := newMarketplaces(util.ChainConfig{ChainId: 1, Marketplaces: map[string][]string{model.MarketplaceSeaport: {spoofer.Hex()}}})
+	sale := detectSale(saleTransfer(t, transferLog), types.NewTransaction(0, saleNft, big.NewInt(0), 0, nil, nil), saleBuyer, receipt, trusted)
+	assert.NotNil(t, sale)
+	assert.Equal(t, model.MarketplaceSeaport, sale.Marketplace)
+
+	// the seaport contract of one chain is not trusted on a chain without seaport
+	assert.Nil(t, detectSale(saleTransfer(t, transferLog), types.NewTransaction(0, saleNft, big.NewInt(0), 0, nil, nil), saleBuyer, receipt, newMarketplaces(util.ChainConfig{ChainId: 56})))
+}
+
+func TestDetectEtherSale(t *testing.T) {
+	first, second := nftTransferLog(saleSeller, saleBuyer, 1), nftTransferLog(saleSeller, saleBuyer, 2)
+	receipt := &types.Receipt{Logs: []*types.Log{first, second}}
+	tx := types.NewTransaction(0, common.HexToAddress("0x4444444444444444444444444444444444444444"), big.NewInt(3000), 0, nil, nil)
+
+	sale := detectSale(saleTransfer(t, second), tx, saleBuyer, receipt, saleMarketplaces)
+	assert.NotNil(t, sale)
+	assert.Equal(t, "1500", sale.Price)
+	assert.Equal(t, model.ZeroAddress, sale.Currency)
+	assert.Equal(t, model.MarketplaceUnknown, sale.Marketplace)
+
+	// ether sent by someone else does not pay for the token
+	assert.Nil(t, detectSale(saleTransfer(t, second), tx, saleSeller, receipt, saleMarketplaces))
+}
+
+func TestDetectErc20Sale(t *testing.T) {
+	transferLog := nftTransferLog(saleSeller, saleBuyer, 7)
+	receipt := &types.Receipt{Logs: []*types.Log{
+		erc20TransferLog(saleWeth, saleBuyer, saleSeller, 900),
+		erc20TransferLog(saleWeth, saleBuyer, common.HexToAddress("0x3333333333333333333333333333333333333333"), 100),
+		transferLog,
+	}}
+	tx := types.NewTransaction(0, saleNft, big.NewInt(0), 0, nil, nil)
+
+	sale := detectSale(saleTransfer(t, transferLog), tx, saleSeller, receipt, saleMarketplaces)
+	assert.NotNil(t, sale)
+	assert.Equal(t, "1000", sale.Price)
+	assert.Equal(t, model.Address(saleWeth), sale.Currency)
+}
+
+func TestDetectNoSale(t *testing.T) {
+	transferLog := nftTransferLog(saleSeller, saleBuyer, 7)
+	receipt := &types.Receipt{Logs: []*types.Log{transferLog}}
+	tx := types.NewTransaction(0, saleNft, big.NewInt(0), 0, nil, nil)
+	assert.Nil(t, detectSale(saleTransfer(t, transferLog), tx, saleSeller, receipt, saleMarketplaces))
+
+	// a paid mint is not a sale
+	mintLog := nftTransferLog(common.Address{}, saleBuyer, 8)
+	paid := types.NewTransaction(0, saleNft, big.NewInt(1000), 0, nil, nil)
+	assert.Nil(t, detectSale(saleTransfer(t, mintLog), paid, saleBuyer, &types.Receipt{Logs: []*types.Log{mintLog}}, saleMarketplaces))
+}
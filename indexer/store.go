@@ -0,0 +1,349 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math/big"
+	"net/http"
+	"nft-event/contracts"
+	"nft-event/db"
+	"nft-event/model"
+	"nft-event/util"
+	"strings"
+	"time"
+)
+
+// HexBytes ERC721 interface must be compliant with 0x80ac58cd
+var HexBytes = [4]byte{0x80, 0xac, 0x58, 0xcd}
+
+// prepareLog returns the writes storing the event and the new owner of a transfer log.
+// Failing metadata lookups are logged and leave the metadata out, any other failure is returned.
+func (j *Job) prepareLog(ctx context.Context, vLog types.Log) ([]db.Write, error) {
+	vlogStart := time.Now()
+
+	// skip erc20 transfer event which has 3 topics
+	transfer, err := model.NewTransfer(j.chain.ChainId, vLog)
+	if err == model.ErrNotTransfer {
+		if update, err := model.NewMetadataUpdate(j.chain.ChainId, vLog); err == nil {
+			return j.prepareMetadataUpdate(ctx, update, vLog)
+		}
+		return j.prepareApproval(ctx, vLog)
+	}
+
+	standard, err := j.standards.classify(ctx, vLog.Address, false)
+	if err != nil {
+		return nil, err
+	}
+	if standard != model.StandardErc721 {
+		log.Info("no erc721 compliant...")
+		return nil, nil
+	}
+
+	instance, err := contracts.NewToken(vLog.Address, j.eth)
+	if err != nil {
+		return nil, err
+	}
+
+	// the transaction and header only enrich the transfer and detect its sale, failing to fetch them leaves those out.
+	// Some transaction types cannot be decoded by this client at all.
+	info, err := j.txs.get(ctx, vLog.TxHash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Warnf("transaction %s of transfer %s:%s not fetched, stored without its details and sale: %v", vLog.TxHash, transfer.NftAddress, transfer.TokenId, err)
+	}
+	header, err := j.txs.header(ctx, vLog.BlockHash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Warnf("block %d of transfer %s:%s not fetched, stored without its time: %v", vLog.BlockNumber, transfer.NftAddress, transfer.TokenId, err)
+	}
+	enrichTransfer(transfer, info, header)
+
+	log.Infof("%+v", transfer)
+
+	// the receiver of this transfer owns the token once the logs before it are applied
+	token := model.NewToken(transfer)
+	var metadata map[string]interface{}
+	if settings := j.settings(vLog.Address); settings.FetchMetadata() {
+		metadata = fetchMetadata(ctx, instance, transfer.TokenId, token, settings.FetchMedia())
+	}
+	j.setRoyalty(ctx, vLog.Address, transfer.TokenId, token)
+
+	log.Infof("nft doc: %+v", token)
+
+	writes, err := TransferWrites(transfer, token, j.config)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.MetadataHash != "" {
+		versionWrites, err := j.metadataVersionWrites(ctx, token, metadata)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, versionWrites...)
+	}
+
+	if info != nil {
+		writes = append(writes, saleWrites(transfer, info, j.marketplaces, j.config)...)
+	}
+
+	vlogDuration := time.Since(vlogStart)
+	log.Infof("vlog topics end, duration: %.5f", vlogDuration.Seconds())
+	return writes, nil
+}
+
+// prepareApproval returns the writes storing the activity of an erc721 approval log, none for other logs
+func (j *Job) prepareApproval(ctx context.Context, vLog types.Log) ([]db.Write, error) {
+	approval, err := model.NewApproval(j.chain.ChainId, vLog)
+	if err == model.ErrNotApproval {
+		return nil, nil
+	}
+	standard, err := j.standards.classify(ctx, vLog.Address, false)
+	if err != nil {
+		return nil, err
+	}
+	if standard != model.StandardErc721 {
+		return nil, nil
+	}
+	if approval.BlockTime, err = j.blockTime(ctx, vLog); err != nil {
+		return nil, err
+	}
+	return ActivityWrites([]model.Activity{*approval}, j.config)
+}
+
+// blockTime time of the block of vLog, the zero time when its header cannot be fetched
+func (j *Job) blockTime(ctx context.Context, vLog types.Log) (time.Time, error) {
+	header, err := j.txs.header(ctx, vLog.BlockHash)
+	if err != nil {
+		if ctx.Err() != nil {
+			return time.Time{}, ctx.Err()
+		}
+		log.Warnf("block %d of log %s:%d not fetched, stored without its time: %v", vLog.BlockNumber, vLog.TxHash, vLog.Index, err)
+		return time.Time{}, nil
+	}
+	return time.Unix(int64(header.Time), 0).UTC(), nil
+}
+
+// enrichTransfer sets the block time, operator, recipient, gas used and effective gas price of the transaction of transfer.
+// A missing transaction or header leaves the fields taken from it unset.
+func enrichTransfer(transfer *model.Event, info *txInfo, header *types.Header) {
+	if header != nil {
+		transfer.BlockTime = time.Unix(int64(header.Time), 0).UTC()
+	}
+	if info == nil {
+		return
+	}
+	transfer.Operator = model.Address(info.sender)
+	if to := info.tx.To(); to != nil {
+		transfer.TxTo = model.Address(*to)
+	}
+	transfer.GasUsed = info.receipt.GasUsed
+	if header != nil {
+		transfer.GasPrice = effectiveGasPrice(info.tx, header.BaseFee).String()
+	}
+}
+
+// saleWrites returns the writes storing the sale of transfer, none when it was not sold.
+// A sale which cannot be stored is logged and left out, it never keeps the transfer from being stored.
+func saleWrites(transfer *model.Event, info *txInfo, trusted marketplaces, config *util.Config) []db.Write {
+	sale := detectSale(transfer, info.tx, info.sender, info.receipt, trusted)
+	if sale == nil {
+		return nil
+	}
+	writes, err := SaleWrites(sale, config)
+	if err != nil {
+		log.Warnf("sale of %s:%s in %s not stored: %v", transfer.NftAddress, transfer.TokenId, transfer.Tx, err)
+		return nil
+	}
+	return writes
+}
+
+// effectiveGasPrice the price per gas paid by tx in a block with baseFee, nil before london.
+// Receipts of this client do not carry it, dynamic fee transactions pay the base fee plus their tip up to the fee cap.
+func effectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return tx.GasPrice()
+	}
+	tip := new(big.Int).Sub(tx.GasFeeCap(), baseFee)
+	if tx.GasTipCap().Cmp(tip) < 0 {
+		tip = tx.GasTipCap()
+	}
+	return new(big.Int).Add(baseFee, tip)
+}
+
+// EventWrite returns the write storing an event, keyed by its log so storing it again is safe
+func EventWrite(event *model.Event, config *util.Config) db.Write {
+	filter := bson.D{
+		{"chainId", event.ChainId},
+		{"tx", event.Tx},
+		{"logIndex", event.LogIndex},
+	}
+	return db.Write{
+		Collection: config.MongoEvent,
+		Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$set", event}}).SetUpsert(true),
+	}
+}
+
+// TransferWrites returns the writes storing a transfer event and the token it changes.
+// The event is keyed by its position so storing it again is safe, the token is only changed by a log not older than the stored one.
+func TransferWrites(transfer *model.Event, token *model.Token, config *util.Config) ([]db.Write, error) {
+	if err := transfer.Validate(); err != nil {
+		return nil, err
+	}
+	if err := token.Validate(); err != nil {
+		return nil, err
+	}
+
+	tokenDoc, err := db.ToDoc(token)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.D{
+		{"chainId", token.ChainId},
+		{"nftAddress", token.NftAddress},
+		{"tokenId", token.TokenId},
+	}
+
+	writes := []db.Write{
+		EventWrite(transfer, config),
+		{
+			Collection: config.MongoNft,
+			Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(db.NewerPipeline(tokenDoc, transfer.BlockNumber, transfer.LogIndex)).SetUpsert(true),
+		},
+	}
+	// mint and burn fields are set whatever the order the transfers of the token are stored in
+	if lifecycle := model.LifecycleUpdate(transfer); lifecycle != nil {
+		writes = append(writes, db.Write{
+			Collection: config.MongoNft,
+			Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$set", lifecycle}}),
+		})
+	}
+
+	activityWrites, err := ActivityWrites(model.TransferActivities(transfer), config)
+	if err != nil {
+		return nil, err
+	}
+	return append(writes, activityWrites...), nil
+}
+
+// SaleWrites returns the writes storing a sale, keyed by the transfer log like its event, and the activities of its wallets
+func SaleWrites(sale *model.Sale, config *util.Config) ([]db.Write, error) {
+	if err := sale.Validate(); err != nil {
+		return nil, err
+	}
+	filter := bson.D{
+		{"chainId", sale.ChainId},
+		{"tx", sale.Tx},
+		{"logIndex", sale.LogIndex},
+	}
+	activityWrites, err := ActivityWrites(model.SaleActivities(sale), config)
+	if err != nil {
+		return nil, err
+	}
+	return append([]db.Write{{
+		Collection: config.MongoSale,
+		Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$set", sale}}).SetUpsert(true),
+	}}, activityWrites...), nil
+}
+
+// ActivityWrites returns the writes storing activities, keyed by wallet, log and type
+func ActivityWrites(activities []model.Activity, config *util.Config) ([]db.Write, error) {
+	writes := make([]db.Write, 0, len(activities))
+	for _, activity := range activities {
+		if err := activity.Validate(); err != nil {
+			return nil, err
+		}
+		filter := bson.D{
+			{"chainId", activity.ChainId},
+			{"wallet", activity.Wallet},
+			{"tx", activity.Tx},
+			{"logIndex", activity.LogIndex},
+			{"type", activity.Type},
+		}
+		writes = append(writes, db.Write{
+			Collection: config.MongoActivity,
+			Model:      mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$set", activity}}).SetUpsert(true),
+		})
+	}
+	return writes, nil
+}
+
+// fetchMetadata sets the token uri, metadata and its hash of token, as far as they could be fetched,
+// and returns the metadata document. The hash is only set when the metadata is complete. The image is only downloaded to detect its mime type with media.
+func fetchMetadata(ctx context.Context, instance *contracts.Token, tokenId string, token *model.Token, media bool) map[string]interface{} {
+	id, ok := new(big.Int).SetString(tokenId, 10)
+	if !ok {
+		return nil
+	}
+
+	tokenUriStart := time.Now()
+	tokenUri, err := instance.TokenURI(&bind.CallOpts{Context: ctx}, id)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	tokenUriStartDuration := time.Since(tokenUriStart)
+	log.Infof("token uri end, duration: %.2f", tokenUriStartDuration.Seconds())
+
+	token.TokenUri = tokenUri
+	token.MetadataRefreshedAt = time.Now()
+
+	// TODO: skip except for http
+	if !strings.HasPrefix(tokenUri, "http") {
+		token.MetadataHash = model.MetadataHash(tokenUri, nil)
+		return nil
+	}
+
+	httpStart := time.Now()
+	data, err := util.GetRequest(tokenUri)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+
+	var nftItem model.NftItem
+	if err = json.Unmarshal(data, &nftItem); err != nil {
+		log.Error(err)
+		return nil
+	}
+	var metadata map[string]interface{}
+	if err = json.Unmarshal(data, &metadata); err != nil {
+		log.Error(err)
+		return nil
+	}
+	token.Name = nftItem.Name
+	token.Description = nftItem.Description
+	token.Image = nftItem.Image
+	token.MetadataHash = model.MetadataHash(tokenUri, metadata)
+
+	if media {
+		token.MimeType = detectMimeType(nftItem.Image)
+	}
+
+	httpDuration := time.Since(httpStart)
+	log.Infof("http end, duration: %.2f", httpDuration.Seconds())
+	return metadata
+}
+
+// detectMimeType downloads an image to detect its mime type, empty when it could not be downloaded
+func detectMimeType(image string) string {
+	// TODO: skip except for http
+	if !strings.HasPrefix(image, "http") {
+		return ""
+	}
+	imageData, err := util.GetRequest(image)
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
+	return http.DetectContentType(imageData)
+}
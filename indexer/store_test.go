@@ -0,0 +1,121 @@
+package indexer
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math/big"
+	"nft-event/model"
+	"nft-event/util"
+	"testing"
+	"time"
+)
+
+func TestEffectiveGasPrice(t *testing.T) {
+	legacy := types.NewTx(&types.LegacyTx{GasPrice: big.NewInt(50)})
+	assert.Equal(t, "50", effectiveGasPrice(legacy, nil).String())
+	assert.Equal(t, "50", effectiveGasPrice(legacy, big.NewInt(30)).String())
+
+	dynamic := types.NewTx(&types.DynamicFeeTx{GasFeeCap: big.NewInt(100), GasTipCap: big.NewInt(2)})
+	assert.Equal(t, "32", effectiveGasPrice(dynamic, big.NewInt(30)).String())
+	// the fee cap bounds the tip
+	assert.Equal(t, "100", effectiveGasPrice(dynamic, big.NewInt(99)).String())
+}
+
+func TestEnrichTransfer(t *testing.T) {
+	market := common.HexToAddress("0x00000000006c3852cbef3e08e8df289169ede581")
+	transfer := saleTransfer(t, nftTransferLog(saleSeller, saleBuyer, 7))
+	info := &txInfo{
+		tx:      types.NewTx(&types.DynamicFeeTx{To: &market, GasFeeCap: big.NewInt(100), GasTipCap: big.NewInt(2)}),
+		sender:  saleBuyer,
+		receipt: &types.Receipt{GasUsed: 21000},
+	}
+	enrichTransfer(transfer, info, &types.Header{Time: 1650000000, BaseFee: big.NewInt(30)})
+
+	assert.Equal(t, time.Unix(1650000000, 0).UTC(), transfer.BlockTime)
+	assert.Equal(t, model.Address(saleBuyer), transfer.Operator)
+	assert.Equal(t, model.Address(market), transfer.TxTo)
+	assert.Equal(t, uint64(21000), transfer.GasUsed)
+	assert.Equal(t, "32", transfer.GasPrice)
+	assert.NoError(t, transfer.Validate())
+
+	// without its transaction the transfer only gets the block time, without the header no gas price
+	bare := saleTransfer(t, nftTransferLog(saleSeller, saleBuyer, 7))
+	enrichTransfer(bare, nil, &types.Header{Time: 1650000000})
+	assert.Equal(t, time.Unix(1650000000, 0).UTC(), bare.BlockTime)
+	assert.Empty(t, bare.Operator)
+	assert.NoError(t, bare.Validate())
+	bare = saleTransfer(t, nftTransferLog(saleSeller, saleBuyer, 7))
+	enrichTransfer(bare, info, nil)
+	assert.True(t, bare.BlockTime.IsZero())
+	assert.Equal(t, uint64(21000), bare.GasUsed)
+	assert.Empty(t, bare.GasPrice)
+}
+
+func TestUpdatedTokenIds(t *testing.T) {
+	assert.Equal(t, []string{"7"}, updatedTokenIds(&model.Event{TokenId: "7"}))
+	assert.Equal(t, []string{"7", "8", "9"}, updatedTokenIds(&model.Event{TokenId: "7", ToTokenId: "9"}))
+	// the whole collection
+	last := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	assert.Nil(t, updatedTokenIds(&model.Event{TokenId: "0", ToTokenId: model.TokenId(last)}))
+}
+
+func TestMetadataVersionWrites(t *testing.T) {
+	first, second := model.MetadataHash("ipfs://a", nil), model.MetadataHash("ipfs://b", nil)
+	token := &model.Token{ChainId: 1, NftAddress: model.Address(saleNft), TokenId: "7", MetadataHash: first}
+	key := token.NftAddress + ":" + token.TokenId
+	job := &Job{config: &util.Config{MongoMetadata: "metadata"}, versions: map[string]string{key: first}}
+
+	// the latest version is not recorded again
+	writes, err := job.metadataVersionWrites(context.Background(), token, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, writes)
+
+	// a change and the change back are both recorded
+	for _, hash := range []string{second, first} {
+		token.MetadataHash = hash
+		writes, err = job.metadataVersionWrites(context.Background(), token, nil)
+		assert.NoError(t, err)
+		assert.Len(t, writes, 1)
+		assert.Equal(t, hash, job.versions[key])
+	}
+}
+
+func TestRefreshFailure(t *testing.T) {
+	now := time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, bson.D{{"$set", bson.D{
+		{"metadataRefreshFailures", 1},
+		{"metadataRetryAt", now.Add(MetadataRetryBackoff)},
+	}}}, refreshFailure(0, now))
+	assert.Equal(t, bson.D{{"$set", bson.D{
+		{"metadataRefreshFailures", 3},
+		{"metadataRetryAt", now.Add(4 * MetadataRetryBackoff)},
+	}}}, refreshFailure(2, now))
+
+	// given up, the token waits for its next scheduled refresh
+	assert.Equal(t, bson.D{
+		{"$set", bson.D{{"metadataRefreshedAt", now}}},
+		{"$unset", bson.D{{"metadataRefresh", ""}, {"metadataRefreshFailures", ""}, {"metadataRetryAt", ""}}},
+	}, refreshFailure(MetadataRefreshAttempts-1, now))
+}
+
+func TestStaleHoldingWrites(t *testing.T) {
+	job := &Job{config: &util.Config{MongoStale: "staleholdings"}, chain: util.ChainConfig{ChainId: 1}}
+	mint := saleTransfer(t, nftTransferLog(common.Address{}, saleBuyer, 7))
+	transfer := saleTransfer(t, nftTransferLog(saleSeller, saleBuyer, 8))
+
+	// minted tokens were held by nobody, the buyer is marked once per transfer
+	writes := job.staleHoldingWrites([]*model.Event{mint, transfer})
+	assert.Len(t, writes, 3)
+	var owners []string
+	for _, write := range writes {
+		assert.Equal(t, "staleholdings", write.Collection)
+		update := write.Model.(*mongo.UpdateOneModel)
+		assert.True(t, *update.Upsert)
+		owners = append(owners, update.Filter.(bson.D).Map()["owner"].(string))
+	}
+	assert.Equal(t, []string{model.Address(saleBuyer), model.Address(saleSeller), model.Address(saleBuyer)}, owners)
+}
@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"nft-event/eth"
+	"sync"
+)
+
+// txInfo transaction of a log with its sender and receipt
+type txInfo struct {
+	tx      *types.Transaction
+	sender  common.Address
+	receipt *types.Receipt
+}
+
+// txCache fetches every transaction and block header of a run once, however many of its logs are processed.
+// Failed lookups are not cached and are tried again by the next log needing them.
+type txCache struct {
+	eth    *eth.Client
+	signer types.Signer
+
+	mu      sync.Mutex
+	txs     map[common.Hash]*cacheEntry
+	headers map[common.Hash]*cacheEntry
+}
+
+// cacheEntry value of a key, concurrent lookups of the key wait for the first one
+type cacheEntry struct {
+	mu    sync.Mutex
+	value interface{}
+}
+
+func newTxCache(ethClient *eth.Client, chainId uint64) *txCache {
+	return &txCache{
+		eth:     ethClient,
+		signer:  types.LatestSignerForChainID(new(big.Int).SetUint64(chainId)),
+		txs:     make(map[common.Hash]*cacheEntry),
+		headers: make(map[common.Hash]*cacheEntry),
+	}
+}
+
+// get the transaction, sender and receipt of hash
+func (c *txCache) get(ctx context.Context, hash common.Hash) (*txInfo, error) {
+	value, err := c.load(c.txs, hash, func() (interface{}, error) {
+		tx, _, err := c.eth.TransactionByHash(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		sender, err := types.Sender(c.signer, tx)
+		if err != nil {
+			return nil, err
+		}
+		receipt, err := c.eth.TransactionReceipt(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		return &txInfo{tx: tx, sender: sender, receipt: receipt}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*txInfo), nil
+}
+
+// header of the block with hash
+func (c *txCache) header(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	value, err := c.load(c.headers, hash, func() (interface{}, error) {
+		return c.eth.HeaderByHash(ctx, hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*types.Header), nil
+}
+
+func (c *txCache) load(entries map[common.Hash]*cacheEntry, key common.Hash, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	entry, ok := entries[key]
+	if !ok {
+		entry = &cacheEntry{}
+		entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.value != nil {
+		return entry.value, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	entry.value = value
+	return value, nil
+}
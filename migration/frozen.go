@@ -0,0 +1,289 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// The migrations below keep their own copy of the logic they apply as it was when they were written,
+// so changes of the indexer and the models do not change what a migration does to a database migrated later.
+
+var (
+	canonicalAddress = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+	canonicalTokenId = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+)
+
+// storedTransfer the fields of a document of the events collection the migrations read
+type storedTransfer struct {
+	ChainId     uint64    `bson:"chainId"`
+	NftAddress  string    `bson:"nftAddress"`
+	TokenId     string    `bson:"tokenId"`
+	From        string    `bson:"from"`
+	To          string    `bson:"to"`
+	Tx          string    `bson:"tx"`
+	LogIndex    uint      `bson:"logIndex"`
+	BlockNumber uint64    `bson:"blockNumber"`
+	BlockTime   time.Time `bson:"blockTime"`
+	// Type set for the events which are no transfers
+	Type string `bson:"type"`
+}
+
+// storedSale the fields of a document of the sales collection the migrations read
+type storedSale struct {
+	ChainId     uint64    `bson:"chainId"`
+	NftAddress  string    `bson:"nftAddress"`
+	TokenId     string    `bson:"tokenId"`
+	Seller      string    `bson:"seller"`
+	Buyer       string    `bson:"buyer"`
+	Tx          string    `bson:"tx"`
+	LogIndex    uint      `bson:"logIndex"`
+	BlockNumber uint64    `bson:"blockNumber"`
+	BlockTime   time.Time `bson:"blockTime"`
+	Price       string    `bson:"price"`
+	Currency    string    `bson:"currency"`
+	Marketplace string    `bson:"marketplace"`
+}
+
+// activity document of the activities collection as migration 5 builds it
+type activity struct {
+	ChainId      uint64    `bson:"chainId"`
+	Wallet       string    `bson:"wallet"`
+	Type         string    `bson:"type"`
+	NftAddress   string    `bson:"nftAddress"`
+	TokenId      string    `bson:"tokenId,omitempty"`
+	Counterparty string    `bson:"counterparty,omitempty"`
+	Tx           string    `bson:"tx"`
+	LogIndex     uint      `bson:"logIndex"`
+	BlockNumber  uint64    `bson:"blockNumber"`
+	BlockTime    time.Time `bson:"blockTime,omitempty"`
+	Price        string    `bson:"price,omitempty"`
+	Currency     string    `bson:"currency,omitempty"`
+	Marketplace  string    `bson:"marketplace,omitempty"`
+	CreatedAt    time.Time `bson:"createdAt"`
+}
+
+// transferActivities the mint of the receiver, the burn of the sender or the send and the receive of a transfer
+func transferActivities(transfer *storedTransfer) []activity {
+	build := func(wallet, activityType, counterparty string) activity {
+		return activity{
+			ChainId:      transfer.ChainId,
+			Wallet:       wallet,
+			Type:         activityType,
+			NftAddress:   transfer.NftAddress,
+			TokenId:      transfer.TokenId,
+			Counterparty: counterparty,
+			Tx:           transfer.Tx,
+			LogIndex:     transfer.LogIndex,
+			BlockNumber:  transfer.BlockNumber,
+			BlockTime:    transfer.BlockTime,
+			CreatedAt:    time.Now(),
+		}
+	}
+	switch {
+	case transfer.From == model.ZeroAddress:
+		return []activity{build(transfer.To, "mint", "")}
+	case transfer.To == model.ZeroAddress:
+		return []activity{build(transfer.From, "burn", "")}
+	default:
+		return []activity{build(transfer.From, "send", transfer.To), build(transfer.To, "receive", transfer.From)}
+	}
+}
+
+// saleActivities the sale of the seller and the purchase of the buyer
+func saleActivities(sale *storedSale) []activity {
+	build := func(wallet, activityType, counterparty string) activity {
+		return activity{
+			ChainId:      sale.ChainId,
+			Wallet:       wallet,
+			Type:         activityType,
+			NftAddress:   sale.NftAddress,
+			TokenId:      sale.TokenId,
+			Counterparty: counterparty,
+			Tx:           sale.Tx,
+			LogIndex:     sale.LogIndex,
+			BlockNumber:  sale.BlockNumber,
+			BlockTime:    sale.BlockTime,
+			Price:        sale.Price,
+			Currency:     sale.Currency,
+			Marketplace:  sale.Marketplace,
+			CreatedAt:    time.Now(),
+		}
+	}
+	return []activity{build(sale.Seller, "sale", sale.Buyer), build(sale.Buyer, "purchase", sale.Seller)}
+}
+
+// activityModels the upserts of activities keyed by wallet, log and type, an error when one is not in canonical form
+func activityModels(activities []activity) ([]mongo.WriteModel, error) {
+	var models []mongo.WriteModel
+	for _, a := range activities {
+		if !canonicalAddress.MatchString(a.Wallet) || !canonicalAddress.MatchString(a.NftAddress) ||
+			(a.TokenId != "" && !canonicalTokenId.MatchString(a.TokenId)) ||
+			(a.Counterparty != "" && !canonicalAddress.MatchString(a.Counterparty)) {
+			return nil, fmt.Errorf("activity of tx %s log %d is not canonical", a.Tx, a.LogIndex)
+		}
+		filter := bson.D{{"chainId", a.ChainId}, {"wallet", a.Wallet}, {"tx", a.Tx}, {"logIndex", a.LogIndex}, {"type", a.Type}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.D{{"$set", a}}).SetUpsert(true))
+	}
+	return models, nil
+}
+
+// lifecycleUpdate the mint fields of a mint or the burn fields of a burn, nil for other transfers
+func lifecycleUpdate(transfer *storedTransfer) bson.D {
+	var prefix, wallet string
+	switch {
+	case transfer.From == model.ZeroAddress:
+		prefix, wallet = "mint", transfer.To
+	case transfer.To == model.ZeroAddress:
+		prefix, wallet = "burn", transfer.From
+	default:
+		return nil
+	}
+	update := bson.D{
+		{prefix + "er", wallet},
+		{prefix + "Tx", transfer.Tx},
+		{prefix + "Block", transfer.BlockNumber},
+	}
+	if !transfer.BlockTime.IsZero() {
+		update = append(update, bson.E{Key: prefix + "Time", Value: transfer.BlockTime})
+	}
+	return update
+}
+
+// contractKey a contract of a chain
+type contractKey struct {
+	chainId uint64
+	address string
+}
+
+// rebuildHoldings replaces the holdings with the tokens each owner holds per contract in numeric order,
+// then stores the holder count of every contract with holdings
+func rebuildHoldings(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	holdings := client.Database(config.MongoDb).Collection(config.MongoHolding)
+	if _, err := holdings.DeleteMany(ctx, bson.D{}); err != nil {
+		return err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"owner", bson.M{"$ne": model.ZeroAddress}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"chainId", "$chainId"}, {"owner", "$owner"}, {"nftAddress", "$nftAddress"}}},
+			{"tokenIds", bson.M{"$push": "$tokenId"}},
+		}}},
+	}
+	cur, err := client.Database(config.MongoDb).Collection(config.MongoNft).
+		Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = cur.Close(ctx)
+	}()
+
+	contracts := make(map[contractKey]bool)
+	for cur.Next(ctx) {
+		var group struct {
+			Id struct {
+				ChainId    uint64 `bson:"chainId"`
+				Owner      string `bson:"owner"`
+				NftAddress string `bson:"nftAddress"`
+			} `bson:"_id"`
+			TokenIds []string `bson:"tokenIds"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return err
+		}
+		ids := group.TokenIds
+		sort.Slice(ids, func(i, k int) bool {
+			if len(ids[i]) != len(ids[k]) {
+				return len(ids[i]) < len(ids[k])
+			}
+			return ids[i] < ids[k]
+		})
+		holding := bson.D{
+			{"chainId", group.Id.ChainId},
+			{"owner", group.Id.Owner},
+			{"nftAddress", group.Id.NftAddress},
+			{"tokenIds", ids},
+			{"count", int64(len(ids))},
+			{"updatedAt", time.Now()},
+		}
+		if _, err := holdings.InsertOne(ctx, holding); err != nil {
+			return err
+		}
+		contracts[contractKey{group.Id.ChainId, group.Id.NftAddress}] = true
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	for contract := range contracts {
+		filter := bson.D{{"chainId", contract.chainId}, {"nftAddress", contract.address}}
+		holders, err := holdings.CountDocuments(ctx, filter)
+		if err != nil {
+			return err
+		}
+		if err := updateContract(ctx, client, config, contract, bson.D{{"holders", holders}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countSupply stores the minted, burned and circulating tokens of every contract with stored tokens
+func countSupply(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	tokens := client.Database(config.MongoDb).Collection(config.MongoNft)
+	pipeline := mongo.Pipeline{
+		{{"$group", bson.D{{"_id", bson.D{{"chainId", "$chainId"}, {"nftAddress", "$nftAddress"}}}}}},
+	}
+	cur, err := tokens.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Id struct {
+			ChainId    uint64 `bson:"chainId"`
+			NftAddress string `bson:"nftAddress"`
+		} `bson:"_id"`
+	}
+	if err := cur.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		contract := bson.D{{"chainId", group.Id.ChainId}, {"nftAddress", group.Id.NftAddress}}
+		// tokens minted before the start block are only known from later transfers, they are not counted as minted
+		filters := bson.D{
+			{"minted", bson.E{Key: "mintTx", Value: bson.M{"$exists": true}}},
+			{"burned", bson.E{Key: "burned", Value: true}},
+			{"circulating", bson.E{Key: "burned", Value: bson.M{"$ne": true}}},
+		}
+		counters := bson.D{}
+		for _, filter := range filters {
+			count, err := tokens.CountDocuments(ctx, append(contract, filter.Value.(bson.E)))
+			if err != nil {
+				return err
+			}
+			counters = append(counters, bson.E{Key: filter.Key, Value: count})
+		}
+		if err := updateContract(ctx, client, config, contractKey{group.Id.ChainId, group.Id.NftAddress}, counters); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateContract sets fields of a contract document, creating it when it is missing
+func updateContract(ctx context.Context, client *mongo.Client, config *util.Config, contract contractKey, set bson.D) error {
+	update := bson.D{{"$set", set}, {"$setOnInsert", bson.D{{"createdAt", time.Now()}}}}
+	_, err := client.Database(config.MongoDb).Collection(config.MongoContract).
+		UpdateOne(ctx, bson.D{{"chainId", contract.chainId}, {"address", contract.address}}, update, options.Update().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,59 @@
+package migration
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"nft-event/model"
+	"testing"
+	"time"
+)
+
+const (
+	frozenNft    = "0x00000000000000000000000000000000000000aa"
+	frozenSeller = "0x1111111111111111111111111111111111111111"
+	frozenBuyer  = "0x2222222222222222222222222222222222222222"
+)
+
+func TestTransferActivities(t *testing.T) {
+	transfer := storedTransfer{ChainId: 1, NftAddress: frozenNft, TokenId: "7", From: frozenSeller, To: frozenBuyer, Tx: "0x01", LogIndex: 3}
+	activities := transferActivities(&transfer)
+	assert.Len(t, activities, 2)
+	assert.Equal(t, "send", activities[0].Type)
+	assert.Equal(t, frozenBuyer, activities[0].Counterparty)
+	assert.Equal(t, "receive", activities[1].Type)
+	assert.Equal(t, frozenBuyer, activities[1].Wallet)
+
+	mint := transfer
+	mint.From = model.ZeroAddress
+	activities = transferActivities(&mint)
+	assert.Len(t, activities, 1)
+	assert.Equal(t, "mint", activities[0].Type)
+
+	models, err := activityModels(activities)
+	assert.NoError(t, err)
+	assert.Len(t, models, 1)
+
+	// metadata updates have no wallets
+	_, err = activityModels(transferActivities(&storedTransfer{NftAddress: frozenNft, TokenId: "7", Type: "metadataUpdate"}))
+	assert.Error(t, err)
+}
+
+func TestSaleActivities(t *testing.T) {
+	sale := storedSale{ChainId: 1, NftAddress: frozenNft, TokenId: "7", Seller: frozenSeller, Buyer: frozenBuyer, Price: "1000"}
+	activities := saleActivities(&sale)
+	assert.Equal(t, "sale", activities[0].Type)
+	assert.Equal(t, frozenSeller, activities[0].Wallet)
+	assert.Equal(t, "purchase", activities[1].Type)
+	assert.Equal(t, "1000", activities[1].Price)
+}
+
+func TestLifecycleUpdate(t *testing.T) {
+	blockTime := time.Unix(1650000000, 0).UTC()
+	mint := storedTransfer{From: model.ZeroAddress, To: frozenBuyer, Tx: "0x01", BlockNumber: 10, BlockTime: blockTime}
+	assert.Equal(t, bson.D{{"minter", frozenBuyer}, {"mintTx", "0x01"}, {"mintBlock", uint64(10)}, {"mintTime", blockTime}}, lifecycleUpdate(&mint))
+
+	burn := storedTransfer{From: frozenSeller, To: model.ZeroAddress, Tx: "0x02", BlockNumber: 11}
+	assert.Equal(t, bson.D{{"burner", frozenSeller}, {"burnTx", "0x02"}, {"burnBlock", uint64(11)}}, lifecycleUpdate(&burn))
+
+	assert.Nil(t, lifecycleUpdate(&storedTransfer{From: frozenSeller, To: frozenBuyer}))
+}
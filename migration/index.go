@@ -0,0 +1,196 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/util"
+	"reflect"
+	"strings"
+)
+
+const (
+	// namespaceNotFound error code of listing the indexes of a collection which does not exist
+	namespaceNotFound = 26
+	// indexNotFound error code of dropping an index which does not exist
+	indexNotFound = 27
+)
+
+// Index index a collection needs
+type Index struct {
+	Collection string
+	Name       string
+	Keys       bson.D
+	Unique     bool
+	// Partial only documents matching this filter are indexed
+	Partial bson.D
+}
+
+// Indexes all indexes of the collections in config
+func Indexes(config *util.Config) []Index {
+	return []Index{
+		// legacy events have no log index, they are left out of the unique index
+		{Collection: config.MongoEvent, Name: "chainId_tx_logIndex", Keys: bson.D{{"chainId", 1}, {"tx", 1}, {"logIndex", 1}}, Unique: true, Partial: bson.D{{"logIndex", bson.M{"$exists": true}}}},
+		{Collection: config.MongoEvent, Name: "chainId_nftAddress_tokenId_blockNumber", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"tokenId", 1}, {"blockNumber", 1}}},
+		{Collection: config.MongoEvent, Name: "from", Keys: bson.D{{"from", 1}}},
+		{Collection: config.MongoEvent, Name: "to", Keys: bson.D{{"to", 1}}},
+		{Collection: config.MongoNft, Name: "chainId_nftAddress_tokenId", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"tokenId", 1}}, Unique: true},
+		{Collection: config.MongoNft, Name: "owner", Keys: bson.D{{"owner", 1}}},
+		{Collection: config.MongoNft, Name: "chainId_nftAddress_burned", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"burned", 1}}},
+		{Collection: config.MongoNft, Name: "chainId_nftAddress_metadataRefreshedAt", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"metadataRefreshedAt", 1}}},
+		{Collection: config.MongoNft, Name: "chainId_metadataRefresh", Keys: bson.D{{"chainId", 1}, {"metadataRefresh", 1}}, Partial: bson.D{{"metadataRefresh", true}}},
+		{Collection: config.MongoMetadata, Name: "chainId_nftAddress_tokenId_observedAt", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"tokenId", 1}, {"observedAt", -1}}},
+		{Collection: config.MongoBlock, Name: "chainId_nftAddress", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}}, Unique: true},
+		{Collection: config.MongoApprovedNft, Name: "chainId_address", Keys: bson.D{{"chainId", 1}, {"address", 1}}, Unique: true},
+		{Collection: config.MongoContract, Name: "chainId_address", Keys: bson.D{{"chainId", 1}, {"address", 1}}, Unique: true},
+		{Collection: config.MongoContract, Name: "chainId_refreshedAt", Keys: bson.D{{"chainId", 1}, {"refreshedAt", 1}}},
+		{Collection: config.MongoSale, Name: "chainId_tx_logIndex", Keys: bson.D{{"chainId", 1}, {"tx", 1}, {"logIndex", 1}}, Unique: true},
+		{Collection: config.MongoSale, Name: "chainId_nftAddress_tokenId_blockNumber", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"tokenId", 1}, {"blockNumber", 1}}},
+		{Collection: config.MongoSale, Name: "buyer", Keys: bson.D{{"buyer", 1}}},
+		{Collection: config.MongoSale, Name: "seller", Keys: bson.D{{"seller", 1}}},
+		{Collection: config.MongoHolding, Name: "chainId_owner_nftAddress", Keys: bson.D{{"chainId", 1}, {"owner", 1}, {"nftAddress", 1}}, Unique: true},
+		{Collection: config.MongoHolding, Name: "chainId_nftAddress_count", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"count", -1}}},
+		{Collection: config.MongoStale, Name: "chainId_owner_nftAddress", Keys: bson.D{{"chainId", 1}, {"owner", 1}, {"nftAddress", 1}}, Unique: true},
+		{Collection: config.MongoHolderStats, Name: "chainId_nftAddress_createdAt", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"createdAt", -1}}},
+		{Collection: config.MongoAlert, Name: "chainId_nftAddress_createdAt", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"createdAt", -1}}},
+		{Collection: config.MongoActivity, Name: "chainId_wallet_tx_logIndex_type", Keys: bson.D{{"chainId", 1}, {"wallet", 1}, {"tx", 1}, {"logIndex", 1}, {"type", 1}}, Unique: true},
+		{Collection: config.MongoActivity, Name: "chainId_wallet_blockNumber_logIndex", Keys: bson.D{{"chainId", 1}, {"wallet", 1}, {"blockNumber", -1}, {"logIndex", -1}}},
+		{Collection: config.MongoActivity, Name: "chainId_wallet_nftAddress_blockNumber_logIndex", Keys: bson.D{{"chainId", 1}, {"wallet", 1}, {"nftAddress", 1}, {"blockNumber", -1}, {"logIndex", -1}}},
+		{Collection: config.MongoDeadLetter, Name: "key", Keys: bson.D{{"key", 1}}, Unique: true},
+		{Collection: config.MongoDeadLetter, Name: "chainId_nftAddress_status_blockNumber", Keys: bson.D{{"chainId", 1}, {"nftAddress", 1}, {"status", 1}, {"blockNumber", 1}}},
+	}
+}
+
+// existingIndex index as listed by mongo
+type existingIndex struct {
+	Name    string `bson:"name"`
+	Key     bson.D `bson:"key"`
+	Unique  bool   `bson:"unique"`
+	Partial bson.D `bson:"partialFilterExpression"`
+}
+
+// EnsureIndexes creates missing indexes and verifies existing ones match their definition
+func EnsureIndexes(ctx context.Context, client *mongo.Client, dataBase string, indexes []Index) error {
+	return ensureIndexes(ctx, client, dataBase, indexes, true)
+}
+
+// VerifyIndexes verifies all indexes exist and match their definition
+func VerifyIndexes(ctx context.Context, client *mongo.Client, dataBase string, indexes []Index) error {
+	return ensureIndexes(ctx, client, dataBase, indexes, false)
+}
+
+func ensureIndexes(ctx context.Context, client *mongo.Client, dataBase string, indexes []Index, create bool) error {
+	var missing []string
+	for _, index := range indexes {
+		collection := client.Database(dataBase).Collection(index.Collection)
+		existing, err := listIndexes(ctx, collection)
+		if err != nil {
+			return err
+		}
+
+		if found, ok := existing[index.Name]; ok {
+			if err := verify(index, found); err != nil {
+				return err
+			}
+			log.Infof("index %s.%s ok", index.Collection, index.Name)
+			continue
+		}
+		if !create {
+			log.Warnf("index %s.%s missing", index.Collection, index.Name)
+			missing = append(missing, index.Collection+"."+index.Name)
+			continue
+		}
+
+		opts := options.Index().SetName(index.Name)
+		if index.Unique {
+			opts.SetUnique(true)
+		}
+		if index.Partial != nil {
+			opts.SetPartialFilterExpression(index.Partial)
+		}
+		if _, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: index.Keys, Options: opts}); err != nil {
+			return fmt.Errorf("failed to create index %s.%s: %w", index.Collection, index.Name, err)
+		}
+		log.Infof("index %s.%s created", index.Collection, index.Name)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing indexes: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func listIndexes(ctx context.Context, collection *mongo.Collection) (map[string]existingIndex, error) {
+	cur, err := collection.Indexes().List(ctx)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.Code == namespaceNotFound {
+		return map[string]existingIndex{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []existingIndex
+	if err := cur.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]existingIndex, len(indexes))
+	for _, index := range indexes {
+		existing[index.Name] = index
+	}
+	return existing, nil
+}
+
+// dropIndex drops an index which is no longer defined, doing nothing when it does not exist
+func dropIndex(ctx context.Context, client *mongo.Client, dataBase, collection, name string) error {
+	_, err := client.Database(dataBase).Collection(collection).Indexes().DropOne(ctx, name)
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Code == namespaceNotFound || cmdErr.Code == indexNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to drop index %s.%s: %w", collection, name, err)
+	}
+	log.Infof("index %s.%s dropped", collection, name)
+	return nil
+}
+
+// verify compares an existing index with its definition
+func verify(index Index, existing existingIndex) error {
+	if len(index.Keys) != len(existing.Key) {
+		return fmt.Errorf("index %s.%s has keys %v, expected %v", index.Collection, index.Name, existing.Key, index.Keys)
+	}
+	for i, key := range index.Keys {
+		got := existing.Key[i]
+		if key.Key != got.Key || fmt.Sprint(key.Value) != fmt.Sprint(got.Value) {
+			return fmt.Errorf("index %s.%s has keys %v, expected %v", index.Collection, index.Name, existing.Key, index.Keys)
+		}
+	}
+	if index.Unique != existing.Unique {
+		return fmt.Errorf("index %s.%s unique is %t, expected %t", index.Collection, index.Name, existing.Unique, index.Unique)
+	}
+	if !reflect.DeepEqual(normalize(index.Partial), normalize(existing.Partial)) {
+		return fmt.Errorf("index %s.%s has partial filter %v, expected %v", index.Collection, index.Name, existing.Partial, index.Partial)
+	}
+	return nil
+}
+
+// normalize round trips a document through bson so documents built in code compare equal to decoded ones
+func normalize(doc bson.D) interface{} {
+	if doc == nil {
+		return nil
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	return m
+}
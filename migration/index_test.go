@@ -0,0 +1,41 @@
+package migration
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	index := Index{
+		Collection: "events",
+		Name:       "tx_logIndex",
+		Keys:       bson.D{{"tx", 1}, {"logIndex", 1}},
+		Unique:     true,
+		Partial:    bson.D{{"logIndex", bson.M{"$exists": true}}},
+	}
+	existing := existingIndex{
+		Name:    "tx_logIndex",
+		Key:     bson.D{{"tx", int32(1)}, {"logIndex", int32(1)}},
+		Unique:  true,
+		Partial: bson.D{{"logIndex", bson.D{{"$exists", true}}}},
+	}
+	assert.NoError(t, verify(index, existing))
+
+	other := existing
+	other.Key = bson.D{{"logIndex", int32(1)}, {"tx", int32(1)}}
+	assert.Error(t, verify(index, other))
+
+	other = existing
+	other.Unique = false
+	assert.Error(t, verify(index, other))
+
+	other = existing
+	other.Partial = nil
+	assert.Error(t, verify(index, other))
+}
+
+func TestPending(t *testing.T) {
+	assert.Len(t, Pending(0), len(Migrations))
+	assert.Empty(t, Pending(Latest()))
+}
@@ -0,0 +1,104 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/util"
+	"time"
+)
+
+// schemaId id of the document holding the schema version
+const schemaId = "schema"
+
+// Migration versioned change of the stored data
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, client *mongo.Client, config *util.Config) error
+}
+
+// Schema version document of the migration collection
+type Schema struct {
+	ID        string    `bson:"_id"`
+	Version   int       `bson:"version"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// Latest version of the schema the code expects
+func Latest() int {
+	latest := 0
+	for _, m := range Migrations {
+		if m.Version > latest {
+			latest = m.Version
+		}
+	}
+	return latest
+}
+
+// Version returns the schema version stored in the database, 0 if nothing was migrated yet
+func Version(ctx context.Context, client *mongo.Client, config *util.Config) (int, error) {
+	collection := client.Database(config.MongoDb).Collection(config.MongoMigration)
+	schema := Schema{}
+	err := collection.FindOne(ctx, bson.M{"_id": schemaId}).Decode(&schema)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return schema.Version, nil
+}
+
+// Pending returns the migrations newer than version in order
+func Pending(version int) []Migration {
+	var pending []Migration
+	for _, m := range Migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// Migrate runs the pending migrations one after another, storing the version after each of them
+func Migrate(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	version, err := Version(ctx, client, config)
+	if err != nil {
+		return err
+	}
+	if version > Latest() {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, Latest())
+	}
+
+	collection := client.Database(config.MongoDb).Collection(config.MongoMigration)
+	for _, m := range Pending(version) {
+		log.Infof("migration %d: %s", m.Version, m.Description)
+		start := time.Now()
+		if err := m.Up(ctx, client, config); err != nil {
+			return fmt.Errorf("migration %d failed: %w", m.Version, err)
+		}
+
+		update := bson.M{"$set": bson.M{"version": m.Version, "updatedAt": time.Now()}}
+		if _, err := collection.UpdateOne(ctx, bson.M{"_id": schemaId}, update, options.Update().SetUpsert(true)); err != nil {
+			return err
+		}
+		log.Infof("migration %d done, duration: %.2f", m.Version, time.Since(start).Seconds())
+	}
+	return nil
+}
+
+// Check warns when the database schema is behind the code
+func Check(ctx context.Context, client *mongo.Client, config *util.Config) {
+	version, err := Version(ctx, client, config)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	if version < Latest() {
+		log.Warnf("database schema version %d is behind %d, run the migrate command", version, Latest())
+	}
+}
@@ -0,0 +1,339 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+)
+
+// Migrations all data migrations, ordered by version
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "convert numeric token ids written by the receiver into strings",
+		Up:          convertTokenIds,
+	},
+	{
+		Version:     2,
+		Description: "rewrite checksummed addresses into lowercase hex",
+		Up:          lowercaseAddresses,
+	},
+	{
+		Version:     3,
+		Description: "tag documents with the chain id and key checkpoints by chain and contract",
+		Up:          tagChainId,
+	},
+	{
+		Version:     4,
+		Description: "build the holdings of the stored tokens and the holder counts of contracts",
+		Up:          rebuildHoldings,
+	},
+	{
+		Version:     5,
+		Description: "build the wallet activities of the stored transfers and sales",
+		Up:          buildActivities,
+	},
+	{
+		Version:     6,
+		Description: "set the mint and burn fields of the stored tokens and the supply counters of contracts",
+		Up:          buildLifecycle,
+	},
+	{
+		Version:     7,
+		Description: "drop the unique content hash index of metadata versions so a content coming back is recorded again",
+		Up:          dropMetadataHashIndex,
+	},
+}
+
+// numericTokenId matches documents whose tokenId is stored as a number
+var numericTokenId = bson.M{"tokenId": bson.M{"$type": bson.A{"int", "long", "double"}}}
+
+func convertTokenIds(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	update := mongo.Pipeline{{{"$set", bson.D{{"tokenId", bson.M{"$toString": bson.M{"$toLong": "$tokenId"}}}}}}}
+	for _, col := range []string{config.MongoEvent, config.MongoNft} {
+		collection := client.Database(config.MongoDb).Collection(col)
+		result, err := collection.UpdateMany(ctx, numericTokenId, update)
+		if err != nil {
+			return err
+		}
+		log.Infof("%s: converted %d token ids", col, result.ModifiedCount)
+	}
+	return removeDuplicateNfts(ctx, client, config)
+}
+
+// removeDuplicateNfts keeps the most recently updated document of every token.
+// The receiver upserted with a filter that never matched, so it inserted a new document per transfer.
+func removeDuplicateNfts(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	collection := client.Database(config.MongoDb).Collection(config.MongoNft)
+	pipeline := mongo.Pipeline{
+		{{"$sort", bson.D{{"updatedAt", -1}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"nftAddress", "$nftAddress"}, {"tokenId", "$tokenId"}}},
+			{"ids", bson.M{"$push": "$_id"}},
+			{"count", bson.M{"$sum": 1}},
+		}}},
+		{{"$match", bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+	cur, err := collection.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+
+	defer func(cur *mongo.Cursor, ctx context.Context) {
+		err := cur.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(cur, ctx)
+
+	var removed int64
+	for cur.Next(ctx) {
+		var group struct {
+			Ids []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cur.Decode(&group); err != nil {
+			return err
+		}
+		result, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.Ids[1:]}})
+		if err != nil {
+			return err
+		}
+		removed += result.DeletedCount
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	log.Infof("%s: removed %d duplicate documents", config.MongoNft, removed)
+	return nil
+}
+
+func lowercaseAddresses(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	fields := map[string][]string{
+		config.MongoEvent:       {"nftAddress", "from", "to"},
+		config.MongoNft:         {"nftAddress", "owner", "minter"},
+		config.MongoApprovedNft: {"address"},
+		config.MongoDeadLetter:  {"nftAddress"},
+	}
+	for col, names := range fields {
+		collection := client.Database(config.MongoDb).Collection(col)
+		for _, name := range names {
+			filter := bson.M{name: bson.M{"$type": "string", "$regex": "[A-F]"}}
+			update := mongo.Pipeline{{{"$set", bson.D{{name, bson.M{"$toLower": "$" + name}}}}}}
+			result, err := collection.UpdateMany(ctx, filter, update)
+			if err != nil {
+				return err
+			}
+			log.Infof("%s: lowercased %d %s values", col, result.ModifiedCount, name)
+		}
+	}
+	return nil
+}
+
+// tagChainId assigns the documents written before multi chain support to CHAIN_ID.
+// The single checkpoint of NFT_ADDRESS becomes the checkpoint of that contract.
+func tagChainId(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	untagged := bson.M{"chainId": bson.M{"$exists": false}}
+	for _, col := range []string{config.MongoEvent, config.MongoNft, config.MongoApprovedNft} {
+		collection := client.Database(config.MongoDb).Collection(col)
+		result, err := collection.UpdateMany(ctx, untagged, bson.M{"$set": bson.M{"chainId": config.ChainId}})
+		if err != nil {
+			return err
+		}
+		log.Infof("%s: tagged %d documents with chain %d", col, result.ModifiedCount, config.ChainId)
+	}
+
+	// dead letter keys are unique across chains
+	deadLetters := client.Database(config.MongoDb).Collection(config.MongoDeadLetter)
+	update := mongo.Pipeline{{{"$set", bson.D{
+		{"chainId", config.ChainId},
+		{"key", bson.M{"$concat": bson.A{fmt.Sprintf("%d-", config.ChainId), "$key"}}},
+	}}}}
+	result, err := deadLetters.UpdateMany(ctx, untagged, update)
+	if err != nil {
+		return err
+	}
+	log.Infof("%s: tagged %d documents with chain %d", config.MongoDeadLetter, result.ModifiedCount, config.ChainId)
+
+	blocks := client.Database(config.MongoDb).Collection(config.MongoBlock)
+	if config.NftAddress == "" {
+		log.Warnf("%s: NFT_ADDRESS is not set, the nftId checkpoint is left as it is", config.MongoBlock)
+	} else {
+		update := bson.D{
+			{"$set", bson.D{{"chainId", config.ChainId}, {"nftAddress", model.HexAddress(config.NftAddress)}}},
+			{"$unset", bson.D{{"nftId", ""}}},
+		}
+		result, err := blocks.UpdateOne(ctx, bson.M{"nftId": 1}, update)
+		if err != nil {
+			return err
+		}
+		log.Infof("%s: moved %d checkpoints to %s", config.MongoBlock, result.ModifiedCount, model.HexAddress(config.NftAddress))
+	}
+
+	// indexes replaced by the ones starting with the chain id
+	dropped := map[string][]string{
+		config.MongoEvent:       {"tx_logIndex", "nftAddress_tokenId_blockNumber"},
+		config.MongoNft:         {"nftAddress_tokenId"},
+		config.MongoBlock:       {"nftId"},
+		config.MongoApprovedNft: {"address"},
+		config.MongoDeadLetter:  {"nftAddress_status_blockNumber"},
+	}
+	for col, names := range dropped {
+		for _, name := range names {
+			if err := dropIndex(ctx, client, config.MongoDb, col, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeBatch documents written per bulk write while building them
+const writeBatch = 1000
+
+// buildActivities writes the activities of every stored transfer and sale.
+// Approvals were not stored before and only appear from the blocks indexed from now on.
+func buildActivities(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	activities := client.Database(config.MongoDb).Collection(config.MongoActivity)
+	sources := []struct {
+		collection string
+		decode     func(cur *mongo.Cursor) ([]activity, error)
+	}{
+		{config.MongoEvent, func(cur *mongo.Cursor) ([]activity, error) {
+			transfer := storedTransfer{}
+			if err := cur.Decode(&transfer); err != nil || transfer.Type != "" {
+				// metadata updates are stored with the transfers but are no activity of any wallet
+				return nil, err
+			}
+			return transferActivities(&transfer), nil
+		}},
+		{config.MongoSale, func(cur *mongo.Cursor) ([]activity, error) {
+			sale := storedSale{}
+			err := cur.Decode(&sale)
+			return saleActivities(&sale), err
+		}},
+	}
+
+	for _, source := range sources {
+		cur, err := client.Database(config.MongoDb).Collection(source.collection).Find(ctx, bson.D{})
+		if err != nil {
+			return err
+		}
+		var written int64
+		var models []mongo.WriteModel
+		flush := func() error {
+			if len(models) == 0 {
+				return nil
+			}
+			_, err := activities.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+			written += int64(len(models))
+			models = nil
+			return err
+		}
+		for cur.Next(ctx) {
+			decoded, err := source.decode(cur)
+			if err != nil {
+				_ = cur.Close(ctx)
+				return err
+			}
+			decodedModels, err := activityModels(decoded)
+			if err != nil {
+				// documents which are not in canonical form have no activities
+				log.Warnf("%s: %v", source.collection, err)
+				continue
+			}
+			models = append(models, decodedModels...)
+			if len(models) >= writeBatch {
+				if err := flush(); err != nil {
+					_ = cur.Close(ctx)
+					return err
+				}
+			}
+		}
+		err = cur.Err()
+		_ = cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		log.Infof("%s: wrote %d activities", source.collection, written)
+	}
+	return nil
+}
+
+// buildLifecycle sets the mint and burn fields of tokens from the stored mints and burns, in log order so the
+// last one wins, then flags the tokens owned by the zero address as burned and counts the supply of every contract
+func buildLifecycle(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	tokens := client.Database(config.MongoDb).Collection(config.MongoNft)
+	filter := bson.D{
+		{"type", bson.M{"$exists": false}},
+		{"$or", bson.A{bson.D{{"from", model.ZeroAddress}}, bson.D{{"to", model.ZeroAddress}}}},
+	}
+	opts := options.Find().SetSort(bson.D{{"blockNumber", 1}, {"logIndex", 1}})
+	cur, err := client.Database(config.MongoDb).Collection(config.MongoEvent).Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+
+	var written int64
+	var models []mongo.WriteModel
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		// ordered, a later burn of the same token overrides an earlier one
+		_, err := tokens.BulkWrite(ctx, models)
+		written += int64(len(models))
+		models = nil
+		return err
+	}
+	for cur.Next(ctx) {
+		transfer := storedTransfer{}
+		if err := cur.Decode(&transfer); err != nil {
+			_ = cur.Close(ctx)
+			return err
+		}
+		token := bson.D{{"chainId", transfer.ChainId}, {"nftAddress", transfer.NftAddress}, {"tokenId", transfer.TokenId}}
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(token).SetUpdate(bson.D{{"$set", lifecycleUpdate(&transfer)}}))
+		if len(models) >= writeBatch {
+			if err := flush(); err != nil {
+				_ = cur.Close(ctx)
+				return err
+			}
+		}
+	}
+	err = cur.Err()
+	_ = cur.Close(ctx)
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	log.Infof("%s: set the lifecycle of %d mints and burns", config.MongoNft, written)
+
+	for _, burned := range []bool{true, false} {
+		owner := bson.M{"$ne": model.ZeroAddress}
+		if burned {
+			owner = bson.M{"$eq": model.ZeroAddress}
+		}
+		result, err := tokens.UpdateMany(ctx, bson.D{{"owner", owner}}, bson.D{{"$set", bson.D{{"burned", burned}}}})
+		if err != nil {
+			return err
+		}
+		log.Infof("%s: flagged %d tokens with burned %t", config.MongoNft, result.ModifiedCount, burned)
+	}
+	return countSupply(ctx, client, config)
+}
+
+// dropMetadataHashIndex drops the index keeping a single version per content of a token
+func dropMetadataHashIndex(ctx context.Context, client *mongo.Client, config *util.Config) error {
+	return dropIndex(ctx, client, config.MongoDb, config.MongoMetadata, "chainId_nftAddress_tokenId_hash")
+}
@@ -0,0 +1,167 @@
+package model
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"time"
+)
+
+var (
+	// ApprovalSigHash Approval(owner, approved, tokenId)
+	ApprovalSigHash = crypto.Keccak256Hash([]byte("Approval(address,address,uint256)"))
+	// ApprovalForAllSigHash ApprovalForAll(owner, operator, approved)
+	ApprovalForAllSigHash = crypto.Keccak256Hash([]byte("ApprovalForAll(address,address,bool)"))
+)
+
+// ErrNotApproval the log is not an erc721 approval
+var ErrNotApproval = errors.New("log is not an erc721 approval")
+
+// activity types
+const (
+	ActivityMint           = "mint"
+	ActivitySend           = "send"
+	ActivityReceive        = "receive"
+	ActivityBurn           = "burn"
+	ActivitySale           = "sale"
+	ActivityPurchase       = "purchase"
+	ActivityApproval       = "approval"
+	ActivityApprovalForAll = "approvalForAll"
+)
+
+// ActivityTypes all activity types
+var ActivityTypes = []string{
+	ActivityMint, ActivitySend, ActivityReceive, ActivityBurn, ActivitySale, ActivityPurchase, ActivityApproval, ActivityApprovalForAll,
+}
+
+// Activity document of the activities collection, something that happened to a wallet.
+// A transfer between two wallets is a send of one and a receive of the other.
+type Activity struct {
+	ChainId    uint64 `bson:"chainId" json:"chainId"`
+	Wallet     string `bson:"wallet" json:"wallet"`
+	Type       string `bson:"type" json:"type"`
+	NftAddress string `bson:"nftAddress" json:"nftAddress"`
+	// TokenId empty for approvals of all tokens
+	TokenId string `bson:"tokenId,omitempty" json:"tokenId,omitempty"`
+	// Counterparty the other wallet, the approved operator of approvals
+	Counterparty string    `bson:"counterparty,omitempty" json:"counterparty,omitempty"`
+	Tx           string    `bson:"tx" json:"tx"`
+	LogIndex     uint      `bson:"logIndex" json:"logIndex"`
+	BlockNumber  uint64    `bson:"blockNumber" json:"blockNumber"`
+	BlockTime    time.Time `bson:"blockTime,omitempty" json:"blockTime,omitempty"`
+	Price        string    `bson:"price,omitempty" json:"price,omitempty"`
+	Currency     string    `bson:"currency,omitempty" json:"currency,omitempty"`
+	Marketplace  string    `bson:"marketplace,omitempty" json:"marketplace,omitempty"`
+	// Approved whether an approval of all tokens was granted or revoked
+	Approved  *bool     `bson:"approved,omitempty" json:"approved,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// TransferActivities the activities of the wallets of a transfer, a mint of the receiver, a burn of the sender
+// or a send and a receive
+func TransferActivities(transfer *Event) []Activity {
+	activity := func(wallet, activityType, counterparty string) Activity {
+		return Activity{
+			ChainId:      transfer.ChainId,
+			Wallet:       wallet,
+			Type:         activityType,
+			NftAddress:   transfer.NftAddress,
+			TokenId:      transfer.TokenId,
+			Counterparty: counterparty,
+			Tx:           transfer.Tx,
+			LogIndex:     transfer.LogIndex,
+			BlockNumber:  transfer.BlockNumber,
+			BlockTime:    transfer.BlockTime,
+			CreatedAt:    time.Now(),
+		}
+	}
+	switch {
+	case transfer.From == ZeroAddress:
+		return []Activity{activity(transfer.To, ActivityMint, "")}
+	case transfer.To == ZeroAddress:
+		return []Activity{activity(transfer.From, ActivityBurn, "")}
+	default:
+		return []Activity{
+			activity(transfer.From, ActivitySend, transfer.To),
+			activity(transfer.To, ActivityReceive, transfer.From),
+		}
+	}
+}
+
+// SaleActivities the sale of the seller and the purchase of the buyer
+func SaleActivities(sale *Sale) []Activity {
+	activity := func(wallet, activityType, counterparty string) Activity {
+		return Activity{
+			ChainId:      sale.ChainId,
+			Wallet:       wallet,
+			Type:         activityType,
+			NftAddress:   sale.NftAddress,
+			TokenId:      sale.TokenId,
+			Counterparty: counterparty,
+			Tx:           sale.Tx,
+			LogIndex:     sale.LogIndex,
+			BlockNumber:  sale.BlockNumber,
+			BlockTime:    sale.BlockTime,
+			Price:        sale.Price,
+			Currency:     sale.Currency,
+			Marketplace:  sale.Marketplace,
+			CreatedAt:    time.Now(),
+		}
+	}
+	return []Activity{
+		activity(sale.Seller, ActivitySale, sale.Buyer),
+		activity(sale.Buyer, ActivityPurchase, sale.Seller),
+	}
+}
+
+// NewApproval decodes an erc721 Approval or ApprovalForAll log into the activity of the owner,
+// erc20 approvals have 3 topics and are rejected
+func NewApproval(chainId uint64, vLog types.Log) (*Activity, error) {
+	if len(vLog.Topics) == 0 {
+		return nil, ErrNotApproval
+	}
+	activity := &Activity{
+		ChainId:     chainId,
+		NftAddress:  Address(vLog.Address),
+		Tx:          vLog.TxHash.Hex(),
+		LogIndex:    vLog.Index,
+		BlockNumber: vLog.BlockNumber,
+		CreatedAt:   time.Now(),
+	}
+	switch {
+	case vLog.Topics[0] == ApprovalSigHash && len(vLog.Topics) == 4:
+		activity.Type = ActivityApproval
+		activity.TokenId = TopicTokenId(vLog.Topics[3])
+	case vLog.Topics[0] == ApprovalForAllSigHash && len(vLog.Topics) == 3 && len(vLog.Data) == common.HashLength:
+		activity.Type = ActivityApprovalForAll
+		approved := common.BytesToHash(vLog.Data).Big().Sign() != 0
+		activity.Approved = &approved
+	default:
+		return nil, ErrNotApproval
+	}
+	activity.Wallet = TopicAddress(vLog.Topics[1])
+	activity.Counterparty = TopicAddress(vLog.Topics[2])
+	return activity, nil
+}
+
+// Validate checks the activity is in canonical form
+func (a *Activity) Validate() error {
+	for _, err := range []error{
+		validate("wallet", a.Wallet, ValidAddress),
+		validate("nftAddress", a.NftAddress, ValidAddress),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if a.TokenId != "" {
+		if err := validate("tokenId", a.TokenId, ValidTokenId); err != nil {
+			return err
+		}
+	}
+	if a.Counterparty != "" {
+		return validate("counterparty", a.Counterparty, ValidAddress)
+	}
+	return nil
+}
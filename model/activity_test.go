@@ -0,0 +1,64 @@
+package model
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+func TestTransferActivities(t *testing.T) {
+	owner := "0x1111111111111111111111111111111111111111"
+	other := "0x2222222222222222222222222222222222222222"
+	transfer := &Event{NftAddress: "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", TokenId: "1"}
+
+	transfer.From, transfer.To = ZeroAddress, owner
+	mint := TransferActivities(transfer)
+	assert.Len(t, mint, 1)
+	assert.Equal(t, ActivityMint, mint[0].Type)
+	assert.Equal(t, owner, mint[0].Wallet)
+
+	transfer.From, transfer.To = owner, ZeroAddress
+	burn := TransferActivities(transfer)
+	assert.Len(t, burn, 1)
+	assert.Equal(t, ActivityBurn, burn[0].Type)
+	assert.Equal(t, owner, burn[0].Wallet)
+
+	transfer.From, transfer.To = owner, other
+	moves := TransferActivities(transfer)
+	assert.Len(t, moves, 2)
+	assert.Equal(t, Activity{Wallet: owner, Type: ActivitySend, Counterparty: other}, Activity{Wallet: moves[0].Wallet, Type: moves[0].Type, Counterparty: moves[0].Counterparty})
+	assert.Equal(t, Activity{Wallet: other, Type: ActivityReceive, Counterparty: owner}, Activity{Wallet: moves[1].Wallet, Type: moves[1].Type, Counterparty: moves[1].Counterparty})
+}
+
+func TestNewApproval(t *testing.T) {
+	nft := common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	owner := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	operator := common.HexToAddress("0x2222222222222222222222222222222222222222")
+
+	approval, err := NewApproval(1, types.Log{
+		Address: nft,
+		Topics:  []common.Hash{ApprovalSigHash, owner.Hash(), operator.Hash(), common.BigToHash(big.NewInt(7))},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ActivityApproval, approval.Type)
+	assert.Equal(t, Address(owner), approval.Wallet)
+	assert.Equal(t, Address(operator), approval.Counterparty)
+	assert.Equal(t, "7", approval.TokenId)
+	assert.NoError(t, approval.Validate())
+
+	approval, err = NewApproval(1, types.Log{
+		Address: nft,
+		Topics:  []common.Hash{ApprovalForAllSigHash, owner.Hash(), operator.Hash()},
+		Data:    common.BigToHash(big.NewInt(1)).Bytes(),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, ActivityApprovalForAll, approval.Type)
+	assert.True(t, *approval.Approved)
+	assert.Empty(t, approval.TokenId)
+
+	// erc20 approvals have the amount in the data
+	_, err = NewApproval(1, types.Log{Address: nft, Topics: []common.Hash{ApprovalSigHash, owner.Hash(), operator.Hash()}})
+	assert.Equal(t, ErrNotApproval, err)
+}
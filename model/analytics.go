@@ -0,0 +1,85 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// HolderBucketBounds smallest holding of every holder bucket, the last bucket is open ended
+var HolderBucketBounds = []int64{1, 2, 6, 21}
+
+// HolderBucket holders whose holding lies within a range of token counts
+type HolderBucket struct {
+	// Label range of token counts, "1", "2-5", "6-20" and "20+"
+	Label   string `bson:"label" json:"label"`
+	Holders int64  `bson:"holders" json:"holders"`
+	Tokens  int64  `bson:"tokens" json:"tokens"`
+}
+
+// HolderStats document of the holder stats collection, the holder distribution of a contract at a point in time
+type HolderStats struct {
+	ChainId    uint64         `bson:"chainId" json:"chainId"`
+	NftAddress string         `bson:"nftAddress" json:"nftAddress"`
+	Holders    int64          `bson:"holders" json:"holders"`
+	Tokens     int64          `bson:"tokens" json:"tokens"`
+	Buckets    []HolderBucket `bson:"buckets" json:"buckets"`
+	// Gini concentration of the tokens among the holders, 0 when all hold the same, towards 1 when one holds all
+	Gini      float64   `bson:"gini" json:"gini"`
+	Top       []Holding `bson:"top" json:"top"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// WhaleAlert document of the alerts collection, tokens moved away by a large holder
+type WhaleAlert struct {
+	ChainId    uint64 `bson:"chainId" json:"chainId"`
+	NftAddress string `bson:"nftAddress" json:"nftAddress"`
+	Whale      string `bson:"whale" json:"whale"`
+	// Held tokens of the contract the whale held before the moves
+	Held int64 `bson:"held" json:"held"`
+	// Moved tokens the whale sent in the block range
+	Moved       int64     `bson:"moved" json:"moved"`
+	Receivers   []string  `bson:"receivers" json:"receivers"`
+	Txs         []string  `bson:"txs" json:"txs"`
+	BlockNumber uint64    `bson:"blockNumber" json:"blockNumber"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// HolderBuckets distributes holders by the token counts they hold into the HolderBucketBounds buckets
+func HolderBuckets(counts []int64) []HolderBucket {
+	buckets := []HolderBucket{{Label: "1"}, {Label: "2-5"}, {Label: "6-20"}, {Label: "20+"}}
+	for _, count := range counts {
+		if count < 1 {
+			continue
+		}
+		i := sort.Search(len(HolderBucketBounds), func(i int) bool { return HolderBucketBounds[i] > count }) - 1
+		buckets[i].Holders++
+		buckets[i].Tokens += count
+	}
+	return buckets
+}
+
+// Gini gini coefficient of the token counts held, 0 for no holders
+func Gini(counts []int64) float64 {
+	sorted := make([]int64, 0, len(counts))
+	var total int64
+	for _, count := range counts {
+		if count > 0 {
+			sorted = append(sorted, count)
+			total += count
+		}
+	}
+	n := len(sorted)
+	if n == 0 || total == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, k int) bool { return sorted[i] < sorted[k] })
+
+	// G = sum((2i - n - 1) * x_i) / (n * sum(x)) with i from 1 over the ascending counts
+	var weighted float64
+	for i, count := range sorted {
+		weighted += float64(2*(i+1)-n-1) * float64(count)
+	}
+	gini := weighted / (float64(n) * float64(total))
+	return math.Round(gini*10000) / 10000
+}
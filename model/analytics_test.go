@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHolderBuckets(t *testing.T) {
+	buckets := HolderBuckets([]int64{1, 1, 2, 5, 6, 20, 21, 100, 0})
+	assert.Equal(t, []HolderBucket{
+		{Label: "1", Holders: 2, Tokens: 2},
+		{Label: "2-5", Holders: 2, Tokens: 7},
+		{Label: "6-20", Holders: 2, Tokens: 26},
+		{Label: "20+", Holders: 2, Tokens: 121},
+	}, buckets)
+}
+
+func TestGini(t *testing.T) {
+	assert.Equal(t, 0.0, Gini(nil))
+	assert.Equal(t, 0.0, Gini([]int64{3, 3, 3}))
+	assert.Equal(t, 0.5, Gini([]int64{1, 1, 1, 9}))
+	// one holder of everything among many holding one each
+	counts := []int64{1000000}
+	for i := 0; i < 99; i++ {
+		counts = append(counts, 1)
+	}
+	assert.InDelta(t, 0.99, Gini(counts), 0.001)
+}
@@ -2,10 +2,22 @@ package model
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// Block checkpoint of a contract, the last block whose logs are all stored
 type Block struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	NftId     int64              `bson:"nftId"`
-	Current   int64              `bson:"current"`
-	UpdatedAt primitive.DateTime `bson:"updatedAt"`
-	CreatedAt primitive.DateTime `bson:"createdAt"`
+	ID         primitive.ObjectID `bson:"_id"`
+	ChainId    uint64             `bson:"chainId"`
+	NftAddress string             `bson:"nftAddress"`
+	Current    int64              `bson:"current"`
+	// Unclassified contracts the discovery checkpoint moved past whose classification failed
+	Unclassified []UnclassifiedContract `bson:"unclassified,omitempty"`
+	UpdatedAt    primitive.DateTime     `bson:"updatedAt"`
+	CreatedAt    primitive.DateTime     `bson:"createdAt"`
+}
+
+// UnclassifiedContract contract seen by discovery whose classification failed, retried on the next runs
+type UnclassifiedContract struct {
+	Address    string `bson:"address"`
+	FirstBlock uint64 `bson:"firstBlock"`
+	Fungible   bool   `bson:"fungible"`
+	Attempts   int    `bson:"attempts"`
 }
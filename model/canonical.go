@@ -0,0 +1,62 @@
+package model
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Canonical encoding of stored values: addresses are 0x prefixed lowercase hex,
+// token ids are base 10 strings so 256 bit ids survive.
+
+// ZeroAddress canonical zero address, sender of mints and receiver of burns
+const ZeroAddress = "0x0000000000000000000000000000000000000000"
+
+var (
+	addressPattern = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
+	tokenIdPattern = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+)
+
+// Address canonical form of an address
+func Address(address common.Address) string {
+	return strings.ToLower(address.Hex())
+}
+
+// HexAddress canonical form of an address given as hex, checksummed or not
+func HexAddress(hex string) string {
+	return Address(common.HexToAddress(hex))
+}
+
+// TopicAddress canonical form of an address stored in an indexed event topic
+func TopicAddress(topic common.Hash) string {
+	return Address(common.BytesToAddress(topic.Bytes()))
+}
+
+// TokenId canonical form of a token id
+func TokenId(id *big.Int) string {
+	return id.String()
+}
+
+// TopicTokenId canonical form of a token id stored in an indexed event topic
+func TopicTokenId(topic common.Hash) string {
+	return TokenId(topic.Big())
+}
+
+// ValidAddress reports whether s is a canonical address
+func ValidAddress(s string) bool {
+	return addressPattern.MatchString(s)
+}
+
+// ValidTokenId reports whether s is a canonical token id
+func ValidTokenId(s string) bool {
+	return tokenIdPattern.MatchString(s)
+}
+
+func validate(field, value string, valid func(string) bool) error {
+	if !valid(value) {
+		return fmt.Errorf("%s %q is not canonical", field, value)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCanonicalAddress(t *testing.T) {
+	address := common.HexToAddress("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D")
+	assert.Equal(t, "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", Address(address))
+	assert.Equal(t, Address(address), HexAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"))
+	assert.Equal(t, Address(address), TopicAddress(common.BytesToHash(address.Bytes())))
+	assert.True(t, ValidAddress(Address(address)))
+	assert.False(t, ValidAddress(address.Hex()))
+}
+
+func TestCanonicalTokenId(t *testing.T) {
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+	topic := common.BigToHash(max)
+	assert.Equal(t, max.String(), TopicTokenId(topic))
+	assert.True(t, ValidTokenId(TopicTokenId(topic)))
+	assert.True(t, ValidTokenId("0"))
+	assert.False(t, ValidTokenId("0x0d"))
+	assert.False(t, ValidTokenId("013"))
+}
+
+func TestNewTransfer(t *testing.T) {
+	from := common.HexToAddress("0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B")
+	to := common.HexToAddress("0x00000000219ab540356cBB839Cbe05303d7705Fa")
+	vLog := types.Log{
+		Address: common.HexToAddress("0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"),
+		Topics: []common.Hash{
+			TransferSigHash,
+			common.BytesToHash(from.Bytes()),
+			common.BytesToHash(to.Bytes()),
+			common.BigToHash(big.NewInt(13)),
+		},
+		BlockNumber: 100,
+		Index:       2,
+	}
+
+	transfer, err := NewTransfer(1, vLog)
+	assert.NoError(t, err)
+	assert.NoError(t, transfer.Validate())
+	assert.Equal(t, "0xab5801a7d398351b8be11c439e05c5b3259aec9b", transfer.From)
+	assert.Equal(t, "0x00000000219ab540356cbb839cbe05303d7705fa", transfer.To)
+	assert.Equal(t, "13", transfer.TokenId)
+
+	token := NewToken(transfer)
+	assert.NoError(t, token.Validate())
+	assert.Equal(t, transfer.To, token.Owner)
+	assert.Empty(t, token.Minter)
+	assert.False(t, token.Burned)
+	assert.Nil(t, LifecycleUpdate(transfer))
+
+	// erc20 transfers have no token id topic
+	vLog.Topics = vLog.Topics[:3]
+	_, err = NewTransfer(1, vLog)
+	assert.ErrorIs(t, err, ErrNotTransfer)
+}
+
+func TestNewMetadataUpdate(t *testing.T) {
+	nft := common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+
+	update, err := NewMetadataUpdate(1, types.Log{Address: nft, Topics: []common.Hash{MetadataUpdateSigHash}, Data: common.BigToHash(big.NewInt(7)).Bytes()})
+	assert.NoError(t, err)
+	assert.Equal(t, EventMetadataUpdate, update.Type)
+	assert.Equal(t, "7", update.TokenId)
+	assert.NoError(t, update.Validate())
+
+	data := append(common.BigToHash(big.NewInt(1)).Bytes(), common.BigToHash(big.NewInt(100)).Bytes()...)
+	update, err = NewMetadataUpdate(1, types.Log{Address: nft, Topics: []common.Hash{BatchMetadataUpdateSigHash}, Data: data})
+	assert.NoError(t, err)
+	assert.Equal(t, EventBatchMetadataUpdate, update.Type)
+	assert.Equal(t, "1", update.TokenId)
+	assert.Equal(t, "100", update.ToTokenId)
+	assert.NoError(t, update.Validate())
+
+	_, err = NewMetadataUpdate(1, types.Log{Address: nft, Topics: []common.Hash{MetadataUpdateSigHash}})
+	assert.Equal(t, ErrNotMetadataUpdate, err)
+}
+
+func TestLifecycleUpdate(t *testing.T) {
+	wallet := "0xab5801a7d398351b8be11c439e05c5b3259aec9b"
+	blockTime := time.Unix(1650000000, 0).UTC()
+	mint := &Event{From: ZeroAddress, To: wallet, Tx: "0x01", BlockNumber: 100, BlockTime: blockTime}
+	assert.Equal(t, bson.D{
+		{"minter", wallet},
+		{"mintTx", "0x01"},
+		{"mintBlock", uint64(100)},
+		{"mintTime", blockTime},
+	}, LifecycleUpdate(mint))
+	assert.False(t, NewToken(mint).Burned)
+
+	burn := &Event{From: wallet, To: ZeroAddress, Tx: "0x02", BlockNumber: 200}
+	assert.Equal(t, bson.D{
+		{"burner", wallet},
+		{"burnTx", "0x02"},
+		{"burnBlock", uint64(200)},
+	}, LifecycleUpdate(burn))
+	assert.True(t, NewToken(burn).Burned)
+}
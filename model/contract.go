@@ -0,0 +1,54 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Contract document of the contracts collection, what a contract tells about itself
+type Contract struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	ChainId     uint64             `bson:"chainId" json:"chainId"`
+	Address     string             `bson:"address" json:"address"`
+	Name        string             `bson:"name,omitempty" json:"name,omitempty"`
+	Symbol      string             `bson:"symbol,omitempty" json:"symbol,omitempty"`
+	TotalSupply string             `bson:"totalSupply,omitempty" json:"totalSupply,omitempty"`
+	ContractUri string             `bson:"contractUri,omitempty" json:"contractUri,omitempty"`
+	// Metadata json document the contract uri points to
+	Metadata map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	// Interfaces names of the erc165 interfaces the contract supports
+	Interfaces []string `bson:"interfaces" json:"interfaces"`
+	// Royalty erc2981 royalty of the contract, set when every sampled token has the same one
+	Royalty         *Royalty `bson:"royalty,omitempty" json:"royalty,omitempty"`
+	Deployer        string   `bson:"deployer,omitempty" json:"deployer,omitempty"`
+	DeploymentTx    string   `bson:"deploymentTx,omitempty" json:"deploymentTx,omitempty"`
+	DeploymentBlock uint64   `bson:"deploymentBlock,omitempty" json:"deploymentBlock,omitempty"`
+	// Holders number of owners holding at least one token, maintained with the holdings
+	Holders int64 `bson:"holders" json:"holders"`
+	// Minted tokens whose mint was indexed, Burned the burned tokens and Circulating the stored tokens not burned,
+	// which includes tokens minted before the start block
+	Minted      int64     `bson:"minted" json:"minted"`
+	Burned      int64     `bson:"burned" json:"burned"`
+	Circulating int64     `bson:"circulating" json:"circulating"`
+	RefreshedAt time.Time `bson:"refreshedAt" json:"refreshedAt"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// RoyaltyBasis sale price asked for in royaltyInfo calls, so the royalty amount is in basis points
+const RoyaltyBasis = 10000
+
+// Royalty erc2981 royalty, the share of a sale price in basis points paid to receiver
+type Royalty struct {
+	Receiver string `bson:"receiver" json:"receiver"`
+	Bps      uint64 `bson:"bps" json:"bps"`
+}
+
+// Supports whether the contract supports the erc165 interface with name
+func (c Contract) Supports(name string) bool {
+	for _, i := range c.Interfaces {
+		if i == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// DeadLetterPending failed log still holding back the checkpoint
+	DeadLetterPending = "pending"
+	// DeadLetterParked log which failed too often, retried apart from the block range
+	DeadLetterParked = "parked"
+)
+
+type DeadLetter struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Key         string             `bson:"key"`
+	ChainId     uint64             `bson:"chainId"`
+	NftAddress  string             `bson:"nftAddress"`
+	BlockNumber uint64             `bson:"blockNumber"`
+	BlockHash   string             `bson:"blockHash"`
+	Tx          string             `bson:"tx"`
+	TxIndex     uint               `bson:"txIndex"`
+	LogIndex    uint               `bson:"logIndex"`
+	Topics      []string           `bson:"topics"`
+	Data        string             `bson:"data"`
+	Error       string             `bson:"error"`
+	Attempts    int                `bson:"attempts"`
+	Status      string             `bson:"status"`
+	CreatedAt   primitive.DateTime `bson:"createdAt"`
+	UpdatedAt   primitive.DateTime `bson:"updatedAt"`
+}
+
+// Log rebuilds the event log of the dead letter
+func (d DeadLetter) Log() types.Log {
+	var topics []common.Hash
+	for _, topic := range d.Topics {
+		topics = append(topics, common.HexToHash(topic))
+	}
+	data, _ := hexutil.Decode(d.Data)
+	return types.Log{
+		Address:     common.HexToAddress(d.NftAddress),
+		Topics:      topics,
+		Data:        data,
+		BlockNumber: d.BlockNumber,
+		TxHash:      common.HexToHash(d.Tx),
+		TxIndex:     d.TxIndex,
+		BlockHash:   common.HexToHash(d.BlockHash),
+		Index:       d.LogIndex,
+	}
+}
@@ -0,0 +1,146 @@
+package model
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math/big"
+	"time"
+)
+
+// TransferSigHash Transfer(from, to, tokenId)
+var TransferSigHash = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// ErrNotTransfer the log is not an erc721 transfer
+var ErrNotTransfer = errors.New("log is not an erc721 transfer")
+
+// erc4906 events of metadata changes, they carry the token ids in their data
+var (
+	// MetadataUpdateSigHash MetadataUpdate(tokenId)
+	MetadataUpdateSigHash = crypto.Keccak256Hash([]byte("MetadataUpdate(uint256)"))
+	// BatchMetadataUpdateSigHash BatchMetadataUpdate(fromTokenId, toTokenId)
+	BatchMetadataUpdateSigHash = crypto.Keccak256Hash([]byte("BatchMetadataUpdate(uint256,uint256)"))
+)
+
+// ErrNotMetadataUpdate the log is not an erc4906 metadata update
+var ErrNotMetadataUpdate = errors.New("log is not an erc4906 metadata update")
+
+// event types besides transfers, which have no type
+const (
+	EventMetadataUpdate      = "MetadataUpdate"
+	EventBatchMetadataUpdate = "BatchMetadataUpdate"
+)
+
+// Event document of the events collection, a transfer unless it has a type
+type Event struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty"`
+	Type        string             `bson:"type,omitempty"`
+	ChainId     uint64             `bson:"chainId"`
+	Tx          string             `bson:"tx"`
+	LogIndex    uint               `bson:"logIndex"`
+	BlockNumber uint64             `bson:"blockNumber"`
+	NftAddress  string             `bson:"nftAddress"`
+	From        string             `bson:"from"`
+	To          string             `bson:"to"`
+	TokenId     string             `bson:"tokenId"`
+	// ToTokenId last token id of a batch metadata update, TokenId is the first one
+	ToTokenId string `bson:"toTokenId,omitempty"`
+	// BlockTime time of the block of the transfer, CreatedAt is the time it was indexed
+	BlockTime time.Time `bson:"blockTime,omitempty"`
+	// Operator sender of the transaction, often a marketplace user or an approved operator rather than from
+	Operator string `bson:"operator,omitempty"`
+	// TxTo contract or wallet the transaction was sent to, empty for contract creations
+	TxTo    string `bson:"txTo,omitempty"`
+	GasUsed uint64 `bson:"gasUsed,omitempty"`
+	// GasPrice effective gas price of the transaction in wei, base 10
+	GasPrice  string    `bson:"gasPrice,omitempty"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// NewTransfer decodes an erc721 transfer log, erc20 transfers have 3 topics and are rejected
+func NewTransfer(chainId uint64, vLog types.Log) (*Event, error) {
+	if len(vLog.Topics) != 4 || vLog.Topics[0] != TransferSigHash {
+		return nil, ErrNotTransfer
+	}
+	return &Event{
+		ChainId:     chainId,
+		Tx:          vLog.TxHash.Hex(),
+		LogIndex:    vLog.Index,
+		BlockNumber: vLog.BlockNumber,
+		NftAddress:  Address(vLog.Address),
+		From:        TopicAddress(vLog.Topics[1]),
+		To:          TopicAddress(vLog.Topics[2]),
+		TokenId:     TopicTokenId(vLog.Topics[3]),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// NewMetadataUpdate decodes an erc4906 MetadataUpdate or BatchMetadataUpdate log
+func NewMetadataUpdate(chainId uint64, vLog types.Log) (*Event, error) {
+	if len(vLog.Topics) != 1 {
+		return nil, ErrNotMetadataUpdate
+	}
+	update := &Event{
+		ChainId:     chainId,
+		Tx:          vLog.TxHash.Hex(),
+		LogIndex:    vLog.Index,
+		BlockNumber: vLog.BlockNumber,
+		NftAddress:  Address(vLog.Address),
+		CreatedAt:   time.Now(),
+	}
+	switch {
+	case vLog.Topics[0] == MetadataUpdateSigHash && len(vLog.Data) == common.HashLength:
+		update.Type = EventMetadataUpdate
+		update.TokenId = TokenId(new(big.Int).SetBytes(vLog.Data))
+	case vLog.Topics[0] == BatchMetadataUpdateSigHash && len(vLog.Data) == 2*common.HashLength:
+		update.Type = EventBatchMetadataUpdate
+		update.TokenId = TokenId(new(big.Int).SetBytes(vLog.Data[:common.HashLength]))
+		update.ToTokenId = TokenId(new(big.Int).SetBytes(vLog.Data[common.HashLength:]))
+	default:
+		return nil, ErrNotMetadataUpdate
+	}
+	return update, nil
+}
+
+// Validate checks the event is in canonical form
+func (e *Event) Validate() error {
+	if e.Type != "" {
+		return e.validateUpdate()
+	}
+	for _, err := range []error{
+		validate("nftAddress", e.NftAddress, ValidAddress),
+		validate("from", e.From, ValidAddress),
+		validate("to", e.To, ValidAddress),
+		validate("tokenId", e.TokenId, ValidTokenId),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if e.Operator != "" {
+		if err := validate("operator", e.Operator, ValidAddress); err != nil {
+			return err
+		}
+	}
+	if e.TxTo != "" {
+		return validate("txTo", e.TxTo, ValidAddress)
+	}
+	return nil
+}
+
+func (e *Event) validateUpdate() error {
+	for _, err := range []error{
+		validate("nftAddress", e.NftAddress, ValidAddress),
+		validate("tokenId", e.TokenId, ValidTokenId),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if e.ToTokenId != "" {
+		return validate("toTokenId", e.ToTokenId, ValidTokenId)
+	}
+	return nil
+}
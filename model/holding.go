@@ -0,0 +1,55 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"sort"
+	"time"
+)
+
+// Holding document of the holdings collection, the tokens of a contract an owner holds
+type Holding struct {
+	ChainId    uint64 `bson:"chainId" json:"chainId"`
+	Owner      string `bson:"owner" json:"owner"`
+	NftAddress string `bson:"nftAddress" json:"nftAddress"`
+	// TokenIds ids of the held tokens in numeric order
+	TokenIds  []string  `bson:"tokenIds" json:"tokenIds"`
+	Count     int64     `bson:"count" json:"count"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// StaleHolding document of the stale holdings collection, a holding whose transfers are stored but which is not yet
+// recomputed. It is written along with the transfers and deleted once the holding is recomputed.
+type StaleHolding struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	ChainId    uint64             `bson:"chainId"`
+	Owner      string             `bson:"owner"`
+	NftAddress string             `bson:"nftAddress"`
+	UpdatedAt  time.Time          `bson:"updatedAt"`
+}
+
+// HoldingKey owner and contract of a holding
+type HoldingKey struct {
+	NftAddress string
+	Owner      string
+}
+
+// TransferHoldings the holdings a transfer changes, burned and minted tokens are held by nobody
+func TransferHoldings(transfer *Event) []HoldingKey {
+	var keys []HoldingKey
+	for _, owner := range []string{transfer.From, transfer.To} {
+		if owner != ZeroAddress {
+			keys = append(keys, HoldingKey{NftAddress: transfer.NftAddress, Owner: owner})
+		}
+	}
+	return keys
+}
+
+// SortTokenIds sorts canonical token ids in numeric order
+func SortTokenIds(ids []string) {
+	sort.Slice(ids, func(i, k int) bool {
+		if len(ids[i]) != len(ids[k]) {
+			return len(ids[i]) < len(ids[k])
+		}
+		return ids[i] < ids[k]
+	})
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSortTokenIds(t *testing.T) {
+	ids := []string{"10", "9", "100", "1", "115792089237316195423570985008687907853269984665640564039457584007913129639935"}
+	SortTokenIds(ids)
+	assert.Equal(t, []string{"1", "9", "10", "100", "115792089237316195423570985008687907853269984665640564039457584007913129639935"}, ids)
+}
+
+func TestTransferHoldings(t *testing.T) {
+	owner := "0x1111111111111111111111111111111111111111"
+	nft := "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+	assert.Equal(t, []HoldingKey{{nft, owner}}, TransferHoldings(&Event{NftAddress: nft, From: ZeroAddress, To: owner}))
+	assert.Equal(t, []HoldingKey{{nft, owner}}, TransferHoldings(&Event{NftAddress: nft, From: owner, To: ZeroAddress}))
+	assert.Len(t, TransferHoldings(&Event{NftAddress: nft, From: owner, To: "0x2222222222222222222222222222222222222222"}), 2)
+}
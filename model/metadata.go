@@ -0,0 +1,76 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// MetadataVersion document of the metadata collection, a distinct version of the metadata of a token
+type MetadataVersion struct {
+	ChainId    uint64 `bson:"chainId" json:"chainId"`
+	NftAddress string `bson:"nftAddress" json:"nftAddress"`
+	TokenId    string `bson:"tokenId" json:"tokenId"`
+	// Hash content hash of the token uri and the metadata document
+	Hash        string `bson:"hash" json:"hash"`
+	TokenUri    string `bson:"tokenUri" json:"tokenUri"`
+	Name        string `bson:"name,omitempty" json:"name,omitempty"`
+	Description string `bson:"description,omitempty" json:"description,omitempty"`
+	Image       string `bson:"image,omitempty" json:"image,omitempty"`
+	// Metadata json document the token uri points to, empty when it could not be fetched
+	Metadata map[string]interface{} `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	// ObservedAt time the version was first seen
+	ObservedAt time.Time `bson:"observedAt" json:"observedAt"`
+}
+
+// MetadataHash content hash of a token uri and its metadata document, which is hashed with sorted keys
+// so formatting and key order do not count as changes
+func MetadataHash(tokenUri string, metadata map[string]interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(tokenUri))
+	h.Write([]byte{0})
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err == nil {
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewMetadataVersion the metadata version of token with its metadata document
+func NewMetadataVersion(token *Token, metadata map[string]interface{}) *MetadataVersion {
+	return &MetadataVersion{
+		ChainId:     token.ChainId,
+		NftAddress:  token.NftAddress,
+		TokenId:     token.TokenId,
+		Hash:        token.MetadataHash,
+		TokenUri:    token.TokenUri,
+		Name:        token.Name,
+		Description: token.Description,
+		Image:       token.Image,
+		Metadata:    metadata,
+		ObservedAt:  time.Now(),
+	}
+}
+
+// Validate checks the version is in canonical form
+func (v *MetadataVersion) Validate() error {
+	for _, err := range []error{
+		validate("nftAddress", v.NftAddress, ValidAddress),
+		validate("tokenId", v.TokenId, ValidTokenId),
+		validate("hash", v.Hash, validHash),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validHash whether hash is a lowercase hex sha256 hash
+func validHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size && hex.EncodeToString(decoded) == hash
+}
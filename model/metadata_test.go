@@ -0,0 +1,20 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMetadataHash(t *testing.T) {
+	var a, b, c map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(`{"name": "Ape #1", "image": "https://example.com/1.png"}`), &a))
+	assert.NoError(t, json.Unmarshal([]byte(`{"image":"https://example.com/1.png","name":"Ape #1"}`), &b))
+	assert.NoError(t, json.Unmarshal([]byte(`{"name": "Ape #1", "image": "https://example.com/1-revealed.png"}`), &c))
+
+	uri := "https://example.com/1.json"
+	assert.Equal(t, MetadataHash(uri, a), MetadataHash(uri, b), "formatting and key order are no changes")
+	assert.NotEqual(t, MetadataHash(uri, a), MetadataHash(uri, c))
+	assert.NotEqual(t, MetadataHash(uri, a), MetadataHash("https://example.com/2.json", a))
+	assert.NotEqual(t, MetadataHash(uri, nil), MetadataHash(uri, a))
+}
@@ -1,10 +1,133 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
+const (
+	// NftActive contract which is indexed, also assumed for contracts without status
+	NftActive = "active"
+	// NftPaused contract which is not indexed until it is resumed
+	NftPaused = "paused"
+	// NftDiscovered contract found by discovery, not indexed until it is resumed after review
+	NftDiscovered = "discovered"
+)
+
+const (
+	// MetadataFetch token metadata is fetched on transfers, the default
+	MetadataFetch = "fetch"
+	// MetadataSkip token metadata is never fetched
+	MetadataSkip = "skip"
+	// MetadataRefresh token metadata is fetched on transfers and again every refresh interval
+	MetadataRefresh = "refresh"
+)
+
+// InactiveStatuses statuses of contracts which are not indexed
+var InactiveStatuses = []string{NftPaused, NftDiscovered}
+
+const (
+	// StandardErc721 contract supporting the erc721 interface
+	StandardErc721 = "erc721"
+	// StandardErc1155 contract supporting the erc1155 interface
+	StandardErc1155 = "erc1155"
+	// StandardErc20 contract emitting transfers without erc165 support, or with 3 topics
+	StandardErc20 = "erc20"
+	// StandardUnknown contract supporting none of the interfaces
+	StandardUnknown = "unknown"
+)
+
+// Nft document of the approved collection
 type Nft struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	NftId     int64              `bson:"nftId"`
-	Address   string             `bson:"address"`
-	CreatedAt primitive.DateTime `bson:"createdAt"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	NftId       int64              `bson:"nftId,omitempty" json:"-"`
+	ChainId     uint64             `bson:"chainId" json:"chainId"`
+	Address     string             `bson:"address" json:"address"`
+	Status      string             `bson:"status,omitempty" json:"status"`
+	NftSettings `bson:",inline"`
+	CreatedAt   primitive.DateTime `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   primitive.DateTime `bson:"updatedAt,omitempty" json:"updatedAt,omitempty"`
+}
+
+// Active whether the contract is indexed
+func (n Nft) Active() bool {
+	for _, status := range InactiveStatuses {
+		if n.Status == status {
+			return false
+		}
+	}
+	return true
+}
+
+// NftSettings indexing settings of a contract, unset ones fall back to the chain or the defaults
+type NftSettings struct {
+	// StartBlock first block indexed when the contract has no checkpoint yet
+	StartBlock uint64 `bson:"startBlock,omitempty" json:"startBlock,omitempty"`
+	// Confirmations blocks behind the head not indexed yet, contracts with confirmations are left out of the receiver
+	Confirmations *uint64 `bson:"confirmations,omitempty" json:"confirmations,omitempty"`
+	// BlockRange blocks per job run
+	BlockRange int64 `bson:"blockRange,omitempty" json:"blockRange,omitempty"`
+	// Metadata one of MetadataFetch, MetadataSkip or MetadataRefresh
+	Metadata string `bson:"metadata,omitempty" json:"metadata,omitempty"`
+	// RefreshSeconds interval of MetadataRefresh
+	RefreshSeconds int64 `bson:"refreshSeconds,omitempty" json:"refreshSeconds,omitempty"`
+	// Media whether the image of a token is downloaded to detect its mime type, on when unset
+	Media *bool `bson:"media,omitempty" json:"media,omitempty"`
+	// Priority contracts with a higher priority are indexed first
+	Priority int `bson:"priority,omitempty" json:"priority,omitempty"`
+}
+
+// StartAt first block to index, the start block of the chain when unset
+func (s NftSettings) StartAt(chainStart uint64) uint64 {
+	if s.StartBlock > 0 {
+		return s.StartBlock
+	}
+	return chainStart
+}
+
+// ConfirmationDepth confirmations of the contract, the ones of the chain when unset
+func (s NftSettings) ConfirmationDepth(chainConfirmations uint64) uint64 {
+	if s.Confirmations != nil {
+		return *s.Confirmations
+	}
+	return chainConfirmations
+}
+
+// Range blocks per job run, defaultRange when unset
+func (s NftSettings) Range(defaultRange int64) int64 {
+	if s.BlockRange > 0 {
+		return s.BlockRange
+	}
+	return defaultRange
+}
+
+// FetchMetadata whether token metadata is fetched
+func (s NftSettings) FetchMetadata() bool {
+	return s.Metadata != MetadataSkip
+}
+
+// FetchMedia whether token images are downloaded
+func (s NftSettings) FetchMedia() bool {
+	return s.Media == nil || *s.Media
+}
+
+// Validate checks the settings are consistent
+func (s NftSettings) Validate() error {
+	switch s.Metadata {
+	case "", MetadataFetch, MetadataSkip:
+	case MetadataRefresh:
+		if s.RefreshSeconds <= 0 {
+			return errors.New("refreshSeconds must be positive with metadata refresh")
+		}
+	default:
+		return fmt.Errorf("metadata %q is not one of %s, %s, %s", s.Metadata, MetadataFetch, MetadataSkip, MetadataRefresh)
+	}
+	if s.BlockRange < 0 {
+		return fmt.Errorf("blockRange %d must not be negative", s.BlockRange)
+	}
+	if s.RefreshSeconds < 0 {
+		return fmt.Errorf("refreshSeconds %d must not be negative", s.RefreshSeconds)
+	}
+	return nil
 }
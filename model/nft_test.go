@@ -0,0 +1,38 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNftSettingsDefaults(t *testing.T) {
+	settings := NftSettings{}
+	assert.Equal(t, uint64(100), settings.StartAt(100))
+	assert.Equal(t, uint64(12), settings.ConfirmationDepth(12))
+	assert.Equal(t, int64(1000), settings.Range(1000))
+	assert.True(t, settings.FetchMetadata())
+	assert.True(t, settings.FetchMedia())
+
+	zero, off := uint64(0), false
+	settings = NftSettings{StartBlock: 5, Confirmations: &zero, BlockRange: 50, Metadata: MetadataSkip, Media: &off}
+	assert.Equal(t, uint64(5), settings.StartAt(100))
+	assert.Equal(t, uint64(0), settings.ConfirmationDepth(12))
+	assert.Equal(t, int64(50), settings.Range(1000))
+	assert.False(t, settings.FetchMetadata())
+	assert.False(t, settings.FetchMedia())
+}
+
+func TestNftSettingsValidate(t *testing.T) {
+	assert.NoError(t, NftSettings{}.Validate())
+	assert.NoError(t, NftSettings{Metadata: MetadataRefresh, RefreshSeconds: 3600}.Validate())
+	assert.Error(t, NftSettings{Metadata: MetadataRefresh}.Validate())
+	assert.Error(t, NftSettings{Metadata: "always"}.Validate())
+	assert.Error(t, NftSettings{BlockRange: -1}.Validate())
+}
+
+func TestNftActive(t *testing.T) {
+	assert.True(t, Nft{}.Active())
+	assert.True(t, Nft{Status: NftActive}.Active())
+	assert.False(t, Nft{Status: NftPaused}.Active())
+	assert.False(t, Nft{Status: NftDiscovered}.Active())
+}
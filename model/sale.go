@@ -0,0 +1,53 @@
+package model
+
+import (
+	"time"
+)
+
+const (
+	// MarketplaceSeaport sale decoded from a seaport OrderFulfilled event
+	MarketplaceSeaport = "seaport"
+	// MarketplaceLooksRare sale decoded from a looksrare TakerBid or TakerAsk event
+	MarketplaceLooksRare = "looksrare"
+	// MarketplaceWyvern sale decoded from a wyvern OrdersMatched event
+	MarketplaceWyvern = "wyvern"
+	// MarketplaceUnknown sale inferred from the payments of the transaction
+	MarketplaceUnknown = "unknown"
+)
+
+// Sale document of the sales collection, a transfer paid for in the same transaction
+type Sale struct {
+	ChainId uint64 `bson:"chainId" json:"chainId"`
+	Tx      string `bson:"tx" json:"tx"`
+	// LogIndex index of the transfer log of the token
+	LogIndex    uint   `bson:"logIndex" json:"logIndex"`
+	BlockNumber uint64 `bson:"blockNumber" json:"blockNumber"`
+	NftAddress  string `bson:"nftAddress" json:"nftAddress"`
+	TokenId     string `bson:"tokenId" json:"tokenId"`
+	Seller      string `bson:"seller" json:"seller"`
+	Buyer       string `bson:"buyer" json:"buyer"`
+	// Price base 10 amount in the smallest unit of the currency
+	Price string `bson:"price" json:"price"`
+	// Currency address of the erc20 token paid with, the zero address for the native currency
+	Currency    string    `bson:"currency" json:"currency"`
+	Marketplace string    `bson:"marketplace" json:"marketplace"`
+	BlockTime   time.Time `bson:"blockTime,omitempty" json:"blockTime"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// Validate checks the sale is in canonical form
+func (s *Sale) Validate() error {
+	for _, err := range []error{
+		validate("nftAddress", s.NftAddress, ValidAddress),
+		validate("tokenId", s.TokenId, ValidTokenId),
+		validate("seller", s.Seller, ValidAddress),
+		validate("buyer", s.Buyer, ValidAddress),
+		validate("price", s.Price, ValidTokenId),
+		validate("currency", s.Currency, ValidAddress),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package model
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Token document of the nfts collection, empty metadata fields are left untouched on update
+type Token struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	ChainId    uint64             `bson:"chainId"`
+	NftAddress string             `bson:"nftAddress"`
+	TokenId    string             `bson:"tokenId"`
+	Owner      string             `bson:"owner"`
+	// Minter receiver of the mint, set with the mint fields by LifecycleUpdate
+	Minter    string    `bson:"minter,omitempty"`
+	MintTx    string    `bson:"mintTx,omitempty"`
+	MintBlock uint64    `bson:"mintBlock,omitempty"`
+	MintTime  time.Time `bson:"mintTime,omitempty"`
+	Burner    string    `bson:"burner,omitempty"`
+	BurnTx    string    `bson:"burnTx,omitempty"`
+	BurnBlock uint64    `bson:"burnBlock,omitempty"`
+	BurnTime  time.Time `bson:"burnTime,omitempty"`
+	// Burned whether the last transfer burned the token, its owner is the zero address then
+	Burned      bool   `bson:"burned"`
+	TokenUri    string `bson:"tokenUri,omitempty"`
+	Name        string `bson:"name,omitempty"`
+	Description string `bson:"description,omitempty"`
+	Image       string `bson:"image,omitempty"`
+	MimeType    string `bson:"mimeType,omitempty"`
+	// MetadataHash content hash of the metadata, see MetadataHash
+	MetadataHash string `bson:"metadataHash,omitempty"`
+	// MetadataRefreshedAt time the metadata was last fetched, or its refresh given up after failing
+	MetadataRefreshedAt time.Time `bson:"metadataRefreshedAt,omitempty"`
+	// MetadataRefresh set when a refresh of the metadata was requested
+	MetadataRefresh bool `bson:"metadataRefresh,omitempty"`
+	// MetadataRefreshFailures refreshes in a row whose metadata could not be fetched
+	MetadataRefreshFailures int `bson:"metadataRefreshFailures,omitempty"`
+	// MetadataRetryAt time before which a failed refresh is not tried again
+	MetadataRetryAt time.Time `bson:"metadataRetryAt,omitempty"`
+	Royalty         *Royalty  `bson:"royalty,omitempty"`
+	BlockNumber     uint64    `bson:"blockNumber,omitempty"`
+	LogIndex        uint      `bson:"logIndex,omitempty"`
+	CreatedAt       time.Time `bson:"createdAt"`
+	UpdatedAt       time.Time `bson:"updatedAt"`
+}
+
+// NewToken token owned by the receiver of transfer, burned when it is the zero address.
+// The mint and burn fields are left to LifecycleUpdate, they do not depend on the order of transfers.
+func NewToken(transfer *Event) *Token {
+	return &Token{
+		ChainId:    transfer.ChainId,
+		NftAddress: transfer.NftAddress,
+		TokenId:    transfer.TokenId,
+		Owner:      transfer.To,
+		Burned:     transfer.To == ZeroAddress,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+}
+
+// LifecycleUpdate the mint fields of a mint or the burn fields of a burn, nil for other transfers.
+// The minter is the receiver of the mint and the burner the owner sending the token to the zero address.
+func LifecycleUpdate(transfer *Event) bson.D {
+	switch {
+	case transfer.From == ZeroAddress:
+		mint := bson.D{
+			{"minter", transfer.To},
+			{"mintTx", transfer.Tx},
+			{"mintBlock", transfer.BlockNumber},
+		}
+		if !transfer.BlockTime.IsZero() {
+			mint = append(mint, bson.E{Key: "mintTime", Value: transfer.BlockTime})
+		}
+		return mint
+	case transfer.To == ZeroAddress:
+		burn := bson.D{
+			{"burner", transfer.From},
+			{"burnTx", transfer.Tx},
+			{"burnBlock", transfer.BlockNumber},
+		}
+		if !transfer.BlockTime.IsZero() {
+			burn = append(burn, bson.E{Key: "burnTime", Value: transfer.BlockTime})
+		}
+		return burn
+	}
+	return nil
+}
+
+// Validate checks the token is in canonical form
+func (t *Token) Validate() error {
+	for _, err := range []error{
+		validate("nftAddress", t.NftAddress, ValidAddress),
+		validate("tokenId", t.TokenId, ValidTokenId),
+		validate("owner", t.Owner, ValidAddress),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if t.Minter != "" {
+		return validate("minter", t.Minter, ValidAddress)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+)
+
+// ActivityFilter narrows the activity feed of a wallet, its zero value matches everything
+type ActivityFilter struct {
+	// Contract only activities of this contract unless it is the zero address
+	Contract common.Address
+	// Types only activities of these types unless empty
+	Types []string
+	// Ascending oldest first instead of latest first
+	Ascending bool
+}
+
+// WalletActivity the activities of wallet across all contracts of a chain in chain order, from offset on
+func WalletActivity(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, wallet common.Address, filter ActivityFilter, limit, offset int64) ([]model.Activity, error) {
+	query := bson.D{{"chainId", chainId}, {"wallet", model.Address(wallet)}}
+	if filter.Contract != (common.Address{}) {
+		query = append(query, bson.E{Key: "nftAddress", Value: model.Address(filter.Contract)})
+	}
+	if len(filter.Types) > 0 {
+		query = append(query, bson.E{Key: "type", Value: bson.M{"$in": filter.Types}})
+	}
+	order := -1
+	if filter.Ascending {
+		order = 1
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"blockNumber", order}, {"logIndex", order}, {"type", 1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	cur, err := mongoClient.Database(config.MongoDb).Collection(config.MongoActivity).Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	activities := []model.Activity{}
+	if err := cur.All(ctx, &activities); err != nil {
+		return nil, err
+	}
+	return activities, nil
+}
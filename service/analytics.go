@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/indexer"
+	"nft-event/model"
+	"nft-event/util"
+	"time"
+)
+
+// HolderStats the current holder distribution of a contract with its top largest holders
+func HolderStats(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, top int64) (model.HolderStats, error) {
+	return indexer.ComputeHolderStats(ctx, mongoClient, config, chainId, model.Address(address), top)
+}
+
+// HolderHistory the holder reports of a contract between since and until, oldest first, without their top holders
+func HolderHistory(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, since, until time.Time, limit int64) ([]model.HolderStats, error) {
+	filter := bson.D{
+		{"chainId", chainId},
+		{"nftAddress", model.Address(address)},
+		{"createdAt", bson.D{{"$gte", since}, {"$lte", until}}},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{"createdAt", 1}}).
+		SetLimit(limit).
+		SetProjection(bson.D{{"top", 0}})
+	cur, err := mongoClient.Database(config.MongoDb).Collection(config.MongoHolderStats).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	history := []model.HolderStats{}
+	if err := cur.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// WhaleAlerts the whale alerts of a contract from offset on, latest first
+func WhaleAlerts(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, limit, offset int64) ([]model.WhaleAlert, error) {
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", model.Address(address)}}
+	opts := options.Find().SetSort(bson.D{{"createdAt", -1}}).SetSkip(offset).SetLimit(limit)
+	cur, err := mongoClient.Database(config.MongoDb).Collection(config.MongoAlert).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	alerts := []model.WhaleAlert{}
+	if err := cur.All(ctx, &alerts); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
@@ -2,23 +2,44 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"nft-event/contracts"
+	"nft-event/db"
+	"nft-event/eth"
+	"nft-event/indexer"
 	"nft-event/model"
 	"nft-event/util"
+	"reflect"
+	"strings"
+	"time"
 )
 
-func GetApprovedNfts(ethClient *ethclient.Client, mongoClient *mongo.Client, config *util.Config) ([]common.Address, map[common.Address]*contracts.Token, error) {
-	var addresses []common.Address
-	nftMap := make(map[common.Address]*contracts.Token, 0)
+// ApprovedNfts contracts of a chain with their bindings and settings
+type ApprovedNfts struct {
+	Addresses []common.Address
+	NftMap    map[common.Address]*contracts.Token
+	Settings  map[common.Address]model.NftSettings
+}
+
+// GetApprovedNfts the contracts of chain, the approved ones which are neither paused nor waiting for review
+// and the configured ones, which use the settings of their approved document when there is one
+func GetApprovedNfts(ethClient *eth.Client, mongoClient *mongo.Client, config *util.Config, chain util.ChainConfig) (ApprovedNfts, error) {
+	approved := ApprovedNfts{
+		NftMap:   make(map[common.Address]*contracts.Token),
+		Settings: make(map[common.Address]model.NftSettings),
+	}
 
 	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoApprovedNft)
-	cur, err := collection.Find(context.Background(), bson.D{})
+	cur, err := collection.Find(context.Background(), bson.D{{"chainId", chain.ChainId}})
 	if err != nil {
-		return addresses, nftMap, err
+		return approved, err
 	}
 
 	defer func(cur *mongo.Cursor, ctx context.Context) {
@@ -29,28 +50,181 @@ func GetApprovedNfts(ethClient *ethclient.Client, mongoClient *mongo.Client, con
 	}(cur, context.Background())
 
 	var nfts []model.Nft
+	listed := make(map[common.Address]bool)
 	for cur.Next(context.Background()) {
 		result := model.Nft{}
 		err := cur.Decode(&result)
 		if err != nil {
-			return addresses, nftMap, err
+			return approved, err
+		}
+		listed[common.HexToAddress(result.Address)] = true
+		if result.Active() {
+			nfts = append(nfts, result)
 		}
-		nfts = append(nfts, result)
 	}
 	if err := cur.Err(); err != nil {
-		return addresses, nftMap, err
+		return approved, err
+	}
+	for _, contract := range chain.Contracts {
+		if !listed[common.HexToAddress(contract)] {
+			nfts = append(nfts, model.Nft{ChainId: chain.ChainId, Address: contract})
+		}
 	}
-
-	nftMap = make(map[common.Address]*contracts.Token, len(nfts))
 
 	for _, nft := range nfts {
-		instance, err := contracts.NewToken(common.HexToAddress(nft.Address), ethClient)
+		address := common.HexToAddress(nft.Address)
+		if _, ok := approved.NftMap[address]; ok {
+			continue
+		}
+		instance, err := contracts.NewToken(address, ethClient)
 		if err != nil {
 			continue
 		}
-		nftMap[common.HexToAddress(nft.Address)] = instance
-		addresses = append(addresses, common.HexToAddress(nft.Address))
+		approved.NftMap[address] = instance
+		approved.Settings[address] = nft.NftSettings
+		approved.Addresses = append(approved.Addresses, address)
+	}
+
+	return approved, nil
+}
+
+// ErrNftNotFound the contract is not in the approved collection
+var ErrNftNotFound = errors.New("contract not found")
+
+// ErrNftExists the contract is already in the approved collection
+var ErrNftExists = errors.New("contract already exists")
+
+// ErrNotErc721 the contract does not support the erc721 interface
+var ErrNotErc721 = errors.New("contract does not support erc721")
+
+// ListNfts the approved collection, of one chain when chainId is not 0
+func ListNfts(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64) ([]model.Nft, error) {
+	filter := bson.D{}
+	if chainId != 0 {
+		filter = bson.D{{"chainId", chainId}}
+	}
+	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoApprovedNft)
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{"chainId", 1}, {"address", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	nfts := []model.Nft{}
+	if err := cur.All(ctx, &nfts); err != nil {
+		return nil, err
+	}
+	return nfts, nil
+}
+
+// AddNft adds an erc721 contract with its settings to the approved collection.
+// ErrNotErc721 is returned when the contract answers that it is no erc721 or reverts, other call errors as they are.
+func AddNft(ctx context.Context, ethClient *eth.Client, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, settings model.NftSettings) (*model.Nft, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	instance, err := contracts.NewToken(address, ethClient)
+	if err != nil {
+		return nil, err
+	}
+	isErc721, err := instance.SupportsInterface(&bind.CallOpts{Context: ctx}, indexer.HexBytes)
+	if err != nil && indexer.NotSupported(err) {
+		return nil, fmt.Errorf("%w: %v", ErrNotErc721, err)
+	}
+	if err != nil {
+		// no answer from the node, the contract may well be an erc721
+		return nil, err
+	}
+	if !isErc721 {
+		return nil, ErrNotErc721
 	}
 
-	return addresses, nftMap, nil
+	nft := &model.Nft{
+		ChainId:     chainId,
+		Address:     model.Address(address),
+		Status:      model.NftActive,
+		NftSettings: settings,
+		CreatedAt:   primitive.NewDateTimeFromTime(time.Now()),
+	}
+	_, err = db.InsertOne(mongoClient, ctx, config.MongoDb, config.MongoApprovedNft, nft)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrNftExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nft, nil
+}
+
+// SetNftStatus pauses or resumes a contract
+func SetNftStatus(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, status string) error {
+	doc := bson.D{
+		{"status", status},
+		{"updatedAt", time.Now()},
+	}
+	result, err := db.UpdateOne(mongoClient, ctx, config.MongoDb, config.MongoApprovedNft, doc, nftFilter(chainId, address))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNftNotFound
+	}
+	return nil
+}
+
+// SetNftSettings replaces the settings of a contract
+func SetNftSettings(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, settings model.NftSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	doc, err := db.ToDoc(settings)
+	if err != nil {
+		return err
+	}
+
+	// unset settings fall back to their defaults
+	set := make(map[string]bool, len(doc))
+	for _, e := range doc {
+		set[e.Key] = true
+	}
+	var unset bson.D
+	t := reflect.TypeOf(settings)
+	for i := 0; i < t.NumField(); i++ {
+		field := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if !set[field] {
+			unset = append(unset, bson.E{Key: field, Value: ""})
+		}
+	}
+	update := bson.D{{"$set", append(doc, bson.E{Key: "updatedAt", Value: time.Now()})}}
+	if len(unset) > 0 {
+		update = append(update, bson.E{Key: "$unset", Value: unset})
+	}
+
+	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoApprovedNft)
+	result, err := collection.UpdateOne(ctx, nftFilter(chainId, address), update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNftNotFound
+	}
+	return nil
+}
+
+// RemoveNft removes a contract from the approved collection, its checkpoint is kept so adding it again resumes
+func RemoveNft(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address) error {
+	result, err := db.DeleteOne(mongoClient, ctx, config.MongoDb, config.MongoApprovedNft, nftFilter(chainId, address))
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNftNotFound
+	}
+	return nil
+}
+
+func nftFilter(chainId uint64, address common.Address) bson.D {
+	return bson.D{
+		{"chainId", chainId},
+		{"address", model.Address(address)},
+	}
 }
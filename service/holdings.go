@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+)
+
+// Holders holder count of a contract with a page of its largest holders
+type Holders struct {
+	Holders int64           `json:"holders"`
+	Top     []model.Holding `json:"top"`
+}
+
+// WalletHoldings the holdings of owner, the largest first, of one contract when contract is not the zero address
+func WalletHoldings(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, owner, contract common.Address) ([]model.Holding, error) {
+	filter := bson.D{{"chainId", chainId}, {"owner", model.Address(owner)}}
+	if contract != (common.Address{}) {
+		filter = append(filter, bson.E{Key: "nftAddress", Value: model.Address(contract)})
+	}
+	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoHolding)
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{"count", -1}, {"nftAddress", 1}}))
+	if err != nil {
+		return nil, err
+	}
+	holdings := []model.Holding{}
+	if err := cur.All(ctx, &holdings); err != nil {
+		return nil, err
+	}
+	return holdings, nil
+}
+
+// TopHolders the holder count of a contract and its holders from offset on, ordered by the number of tokens they hold
+func TopHolders(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, limit, offset int64) (Holders, error) {
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", model.Address(address)}}
+	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoHolding)
+
+	holders := Holders{Top: []model.Holding{}}
+	count, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return holders, err
+	}
+	holders.Holders = count
+
+	opts := options.Find().
+		SetSort(bson.D{{"count", -1}, {"owner", 1}}).
+		SetSkip(offset).
+		SetLimit(limit).
+		SetProjection(bson.D{{"tokenIds", 0}})
+	cur, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		return holders, err
+	}
+	if err := cur.All(ctx, &holders.Top); err != nil {
+		return holders, err
+	}
+	return holders, nil
+}
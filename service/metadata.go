@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"nft-event/model"
+	"nft-event/util"
+)
+
+// RequestMetadataRefresh asks the job to refetch the metadata of the tokens of a contract, all of them without tokenIds,
+// and returns the number of tokens found. Earlier failed refreshes of the tokens are forgotten.
+func RequestMetadataRefresh(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, tokenIds []string) (int64, error) {
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", model.Address(address)}}
+	if len(tokenIds) > 0 {
+		filter = append(filter, bson.E{Key: "tokenId", Value: bson.M{"$in": tokenIds}})
+	}
+	result, err := mongoClient.Database(config.MongoDb).Collection(config.MongoNft).
+		UpdateMany(ctx, filter, bson.D{
+			{"$set", bson.D{{"metadataRefresh", true}}},
+			{"$unset", bson.D{{"metadataRefreshFailures", ""}, {"metadataRetryAt", ""}}},
+		})
+	if err != nil {
+		return 0, err
+	}
+	return result.MatchedCount, nil
+}
+
+// MetadataHistory the metadata versions of a token from offset on, latest first
+func MetadataHistory(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chainId uint64, address common.Address, tokenId string, limit, offset int64) ([]model.MetadataVersion, error) {
+	filter := bson.D{{"chainId", chainId}, {"nftAddress", model.Address(address)}, {"tokenId", tokenId}}
+	opts := options.Find().SetSort(bson.D{{"observedAt", -1}}).SetSkip(offset).SetLimit(limit)
+	cur, err := mongoClient.Database(config.MongoDb).Collection(config.MongoMetadata).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	versions := []model.MetadataVersion{}
+	if err := cur.All(ctx, &versions); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"github.com/ethereum/go-ethereum/common"
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/mongo"
+	"nft-event/eth"
+	"nft-event/util"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// WatchApprovedNfts sends the contracts of chain whenever they change, starting with the current ones.
+// Changes are picked up from a change stream of the approved collection when mongo offers one,
+// and by reloading every interval in any case. The channel is closed when ctx is done.
+func WatchApprovedNfts(ctx context.Context, ethClient *eth.Client, mongoClient *mongo.Client, config *util.Config, chain util.ChainConfig, interval time.Duration) <-chan ApprovedNfts {
+	updates := make(chan ApprovedNfts)
+	changed := make(chan struct{}, 1)
+	go watchChanges(ctx, mongoClient, config, chain, changed)
+
+	go func() {
+		defer close(updates)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var current ApprovedNfts
+		first := true
+		for {
+			approved, err := GetApprovedNfts(ethClient, mongoClient, config, chain)
+			if err != nil {
+				log.Errorf("%s: %v", chain.Label(), err)
+			} else if first || !sameAddresses(current.Addresses, approved.Addresses) || !reflect.DeepEqual(current.Settings, approved.Settings) {
+				first = false
+				current = approved
+				select {
+				case updates <- approved:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-ticker.C:
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return updates
+}
+
+// watchChanges signals changed on every change of the approved collection.
+// Without a replica set there is no change stream and the watcher only reloads periodically.
+func watchChanges(ctx context.Context, mongoClient *mongo.Client, config *util.Config, chain util.ChainConfig, changed chan<- struct{}) {
+	collection := mongoClient.Database(config.MongoDb).Collection(config.MongoApprovedNft)
+	stream, err := collection.Watch(ctx, mongo.Pipeline{})
+	if err != nil {
+		log.Infof("%s: no change stream on %s, reloading contracts every %d seconds: %v", chain.Label(), config.MongoApprovedNft, config.ReloadSeconds, err)
+		return
+	}
+
+	defer func(stream *mongo.ChangeStream, ctx context.Context) {
+		err := stream.Close(ctx)
+		if err != nil {
+			return
+		}
+	}(stream, context.Background())
+
+	for stream.Next(ctx) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		log.Warnf("%s: change stream of %s ended, reloading contracts every %d seconds: %v", chain.Label(), config.MongoApprovedNft, config.ReloadSeconds, err)
+	}
+}
+
+// sameAddresses reports whether a and b hold the same addresses in any order
+func sameAddresses(a, b []common.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sorted := func(addresses []common.Address) []string {
+		hexes := make([]string, len(addresses))
+		for i, address := range addresses {
+			hexes[i] = address.Hex()
+		}
+		sort.Strings(hexes)
+		return hexes
+	}
+	x, y := sorted(a), sorted(b)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
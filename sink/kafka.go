@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"time"
+)
+
+// KafkaBatchTimeout time the writer waits for more events before sending an incomplete batch
+const KafkaBatchTimeout = 10 * time.Millisecond
+
+// kafkaWriter the part of kafka.Writer the sink uses
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Kafka publishes events to a kafka topic, partitioned by their key
+type Kafka struct {
+	writer kafkaWriter
+}
+
+// NewKafka sink writing to topic on the brokers, every event is acknowledged by all in sync replicas
+func NewKafka(brokers []string, topic string) *Kafka {
+	return &Kafka{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: KafkaBatchTimeout,
+	}}
+}
+
+func (k *Kafka) Publish(ctx context.Context, events []*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := encode(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{
+			Key:   []byte(event.Key()),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "id", Value: []byte(event.Id())},
+				{Key: "type", Value: []byte(event.Type)},
+			},
+		}
+	}
+	return k.writer.WriteMessages(ctx, messages...)
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
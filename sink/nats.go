@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+)
+
+// Nats publishes events to a jetstream stream, under the subject {stream}.{chainId}.{nftAddress}
+type Nats struct {
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+	stream string
+}
+
+// NewNats sink publishing to stream on the server at url, the stream is created when it does not exist.
+// Events carry their id as Nats-Msg-Id, so the stream drops the ones published again within its duplicate window.
+func NewNats(url, stream string) (*Nats, error) {
+	conn, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := js.StreamInfo(stream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{stream + ".>"}})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Nats{conn: conn, js: js, stream: stream}, nil
+}
+
+func (n *Nats) Publish(ctx context.Context, events []*Event) error {
+	for _, event := range events {
+		value, err := encode(event)
+		if err != nil {
+			return err
+		}
+		msg := nats.NewMsg(fmt.Sprintf("%s.%d.%s", n.stream, event.ChainId, event.NftAddress))
+		msg.Data = value
+		msg.Header.Set(nats.MsgIdHdr, event.Id())
+		msg.Header.Set("Key", event.Key())
+		msg.Header.Set("Type", event.Type)
+		// one at a time, the next event is only sent once the previous one is stored
+		if _, err := n.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Nats) Close() error {
+	return n.conn.Drain()
+}
@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+)
+
+// Redis appends events to a redis stream, every entry with the fields key, id, type and event
+type Redis struct {
+	client *redis.Client
+	stream string
+}
+
+// NewRedis sink appending to stream on the server at url, like redis://localhost:6379/0
+func NewRedis(url, stream string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{client: redis.NewClient(opts), stream: stream}, nil
+}
+
+func (r *Redis) Publish(ctx context.Context, events []*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	// one round trip, the commands of a pipeline are run in order
+	pipe := r.client.Pipeline()
+	for _, event := range events {
+		value, err := encode(event)
+		if err != nil {
+			return err
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: r.stream,
+			Values: []interface{}{"key", event.Key(), "id", event.Id(), "type", event.Type, "event", value},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,121 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/core/types"
+	"nft-event/model"
+	"nft-event/util"
+	"strings"
+	"time"
+)
+
+// EventTransfer type of a transfer between two wallets, mints and burns have the activity types
+const EventTransfer = "transfer"
+
+// ErrNotEvent the log is neither a transfer nor an approval
+var ErrNotEvent = errors.New("log is neither an erc721 transfer nor an approval")
+
+// Event decoded transfer or approval published to a sink
+type Event struct {
+	// Type transfer, mint, burn, approval or approvalForAll
+	Type       string `json:"type"`
+	ChainId    uint64 `json:"chainId"`
+	NftAddress string `json:"nftAddress"`
+	// TokenId empty for approvals of all tokens
+	TokenId string `json:"tokenId,omitempty"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	// Owner and Operator of approvals, Approved whether an approval of all tokens was granted or revoked
+	Owner       string     `json:"owner,omitempty"`
+	Operator    string     `json:"operator,omitempty"`
+	Approved    *bool      `json:"approved,omitempty"`
+	Tx          string     `json:"tx"`
+	LogIndex    uint       `json:"logIndex"`
+	BlockNumber uint64     `json:"blockNumber"`
+	BlockTime   *time.Time `json:"blockTime,omitempty"`
+}
+
+// NewEvent the event of a transfer or approval log, ErrNotEvent for other logs
+func NewEvent(chainId uint64, vLog types.Log) (*Event, error) {
+	if transfer, err := model.NewTransfer(chainId, vLog); err == nil {
+		event := &Event{
+			Type:        EventTransfer,
+			ChainId:     chainId,
+			NftAddress:  transfer.NftAddress,
+			TokenId:     transfer.TokenId,
+			From:        transfer.From,
+			To:          transfer.To,
+			Tx:          transfer.Tx,
+			LogIndex:    transfer.LogIndex,
+			BlockNumber: transfer.BlockNumber,
+		}
+		switch {
+		case transfer.From == model.ZeroAddress:
+			event.Type = model.ActivityMint
+		case transfer.To == model.ZeroAddress:
+			event.Type = model.ActivityBurn
+		}
+		return event, nil
+	}
+	approval, err := model.NewApproval(chainId, vLog)
+	if err != nil {
+		return nil, ErrNotEvent
+	}
+	return &Event{
+		Type:        approval.Type,
+		ChainId:     chainId,
+		NftAddress:  approval.NftAddress,
+		TokenId:     approval.TokenId,
+		Owner:       approval.Wallet,
+		Operator:    approval.Counterparty,
+		Approved:    approval.Approved,
+		Tx:          approval.Tx,
+		LogIndex:    approval.LogIndex,
+		BlockNumber: approval.BlockNumber,
+	}, nil
+}
+
+// Key partition key of the event, its contract and token so the events of a token go to the same partition.
+// Approvals of all tokens are keyed by their contract.
+func (e *Event) Key() string {
+	if e.TokenId == "" {
+		return fmt.Sprintf("%d:%s", e.ChainId, e.NftAddress)
+	}
+	return fmt.Sprintf("%d:%s:%s", e.ChainId, e.NftAddress, e.TokenId)
+}
+
+// Id unique id of the event, its log, for consumers to drop events delivered again
+func (e *Event) Id() string {
+	return fmt.Sprintf("%d:%s:%d", e.ChainId, e.Tx, e.LogIndex)
+}
+
+// Sink publishes events to a message broker.
+// Publish returns once the broker acknowledged every event, in order; after an error some may be published again.
+// Events of a token are not published in chain order across calls: a parked log is published when it is retried,
+// after the later events of its token, so consumers order events by BlockNumber and LogIndex.
+type Sink interface {
+	Publish(ctx context.Context, events []*Event) error
+	Close() error
+}
+
+// New sink configured with SINK, SINK_URL and SINK_TOPIC, nil without SINK
+func New(config *util.Config) (Sink, error) {
+	switch config.Sink {
+	case "":
+		return nil, nil
+	case util.SinkKafka:
+		return NewKafka(strings.Split(config.SinkUrl, ","), config.SinkTopic), nil
+	case util.SinkNats:
+		return NewNats(config.SinkUrl, config.SinkTopic)
+	case util.SinkRedis:
+		return NewRedis(config.SinkUrl, config.SinkTopic)
+	}
+	return nil, fmt.Errorf("unknown sink %q", config.Sink)
+}
+
+func encode(event *Event) ([]byte, error) {
+	return json.Marshal(event)
+}
@@ -0,0 +1,151 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"nft-event/model"
+	"testing"
+	"time"
+)
+
+var (
+	nft    = common.HexToAddress("0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d")
+	wallet = common.HexToAddress("0xab5801a7d398351b8be11c439e05c5b3259aec9b")
+)
+
+// events a mint, a transfer and an approval of all tokens
+func events(t *testing.T) []*Event {
+	logs := []types.Log{
+		{Topics: []common.Hash{model.TransferSigHash, {}, common.BytesToHash(wallet.Bytes()), common.BigToHash(big.NewInt(13))}, Index: 1},
+		{Topics: []common.Hash{model.TransferSigHash, common.BytesToHash(wallet.Bytes()), common.BytesToHash(nft.Bytes()), common.BigToHash(big.NewInt(13))}, Index: 2},
+		{Topics: []common.Hash{model.ApprovalForAllSigHash, common.BytesToHash(wallet.Bytes()), common.BytesToHash(nft.Bytes())}, Data: common.BigToHash(big.NewInt(1)).Bytes(), Index: 3},
+	}
+	var decoded []*Event
+	for _, vLog := range logs {
+		vLog.Address = nft
+		vLog.BlockNumber = 100
+		vLog.TxHash = common.HexToHash("0x01")
+		event, err := NewEvent(1, vLog)
+		assert.NoError(t, err)
+		decoded = append(decoded, event)
+	}
+	return decoded
+}
+
+func TestNewEvent(t *testing.T) {
+	decoded := events(t)
+	assert.Equal(t, model.ActivityMint, decoded[0].Type)
+	assert.Equal(t, model.ZeroAddress, decoded[0].From)
+	assert.Equal(t, EventTransfer, decoded[1].Type)
+	assert.Equal(t, model.Address(nft), decoded[1].To)
+	assert.Equal(t, model.ActivityApprovalForAll, decoded[2].Type)
+	assert.Equal(t, model.Address(wallet), decoded[2].Owner)
+	assert.True(t, *decoded[2].Approved)
+
+	// a token keeps its key across its events, approvals of all tokens are keyed by contract
+	assert.Equal(t, "1:0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d:13", decoded[0].Key())
+	assert.Equal(t, decoded[0].Key(), decoded[1].Key())
+	assert.Equal(t, "1:0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d", decoded[2].Key())
+	assert.NotEqual(t, decoded[0].Id(), decoded[1].Id())
+
+	_, err := NewEvent(1, types.Log{Address: nft, Topics: []common.Hash{model.MetadataUpdateSigHash}})
+	assert.ErrorIs(t, err, ErrNotEvent)
+}
+
+// kafkaStandIn records the messages written, failing with err when set
+type kafkaStandIn struct {
+	messages []kafka.Message
+	err      error
+}
+
+func (k *kafkaStandIn) WriteMessages(_ context.Context, msgs ...kafka.Message) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.messages = append(k.messages, msgs...)
+	return nil
+}
+
+func (k *kafkaStandIn) Close() error {
+	return nil
+}
+
+func TestKafka(t *testing.T) {
+	writer := &kafkaStandIn{}
+	sink := &Kafka{writer: writer}
+	decoded := events(t)
+	assert.NoError(t, sink.Publish(context.Background(), decoded))
+	assert.Len(t, writer.messages, 3)
+	for i, message := range writer.messages {
+		assert.Equal(t, decoded[i].Key(), string(message.Key))
+		published := &Event{}
+		assert.NoError(t, json.Unmarshal(message.Value, published))
+		assert.Equal(t, decoded[i], published)
+	}
+
+	writer.err = errors.New("not enough replicas")
+	assert.Error(t, sink.Publish(context.Background(), decoded))
+}
+
+func TestRedis(t *testing.T) {
+	server := miniredis.RunT(t)
+	sink, err := NewRedis("redis://"+server.Addr(), "nft-events")
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	decoded := events(t)
+	assert.NoError(t, sink.Publish(context.Background(), decoded))
+	entries, err := sink.client.XRange(context.Background(), "nft-events", "-", "+").Result()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	for i, entry := range entries {
+		assert.Equal(t, decoded[i].Key(), entry.Values["key"])
+		assert.Equal(t, decoded[i].Id(), entry.Values["id"])
+		published := &Event{}
+		assert.NoError(t, json.Unmarshal([]byte(entry.Values["event"].(string)), published))
+		assert.Equal(t, decoded[i], published)
+	}
+
+	server.Close()
+	assert.Error(t, sink.Publish(context.Background(), decoded))
+}
+
+func TestNats(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true, NoSigs: true})
+	assert.NoError(t, err)
+	go ns.Start()
+	defer ns.Shutdown()
+	assert.True(t, ns.ReadyForConnections(5*time.Second))
+
+	sink, err := NewNats(ns.ClientURL(), "nft-events")
+	assert.NoError(t, err)
+	defer sink.Close()
+
+	decoded := events(t)
+	assert.NoError(t, sink.Publish(context.Background(), decoded))
+	// published again after a failure, the stream drops the duplicates
+	assert.NoError(t, sink.Publish(context.Background(), decoded))
+	info, err := sink.js.StreamInfo("nft-events")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), info.State.Msgs)
+
+	sub, err := sink.js.SubscribeSync("nft-events.1."+model.Address(nft), nats.DeliverAll())
+	assert.NoError(t, err)
+	for i := range decoded {
+		msg, err := sub.NextMsg(time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, decoded[i].Key(), msg.Header.Get("Key"))
+		published := &Event{}
+		assert.NoError(t, json.Unmarshal(msg.Data, published))
+		assert.Equal(t, decoded[i], published)
+	}
+}
@@ -1,33 +1,422 @@
 package util
 
-import "github.com/spf13/viper"
+import (
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
 
 type Config struct {
-	EthUri           string `mapstructure:"ETH_URI"`
-	MongoUri         string `mapstructure:"MONGO_URI"`
-	MongoDb          string `mapstructure:"MONGO_DB"`
-	MongoEvent       string `mapstructure:"MONGO_EVENT_COLLECTION"`
-	MongoNft         string `mapstructure:"MONGO_NFT_COLLECTION"`
-	MongoApprovedNft string `mapstructure:"MONGO_APPROVED_COLLECTION"`
-	MongoBlock       string `mapstructure:"MONGO_BLOCK_COLLECTION"`
-	LogOutput        bool   `mapstructure:"LOG_OUTPUT"`
-	LogName          string `mapstructure:"LOG_NAME"`
-	NftAddress       string `mapstructure:"NFT_ADDRESS"`
+	EthUri           string   `mapstructure:"ETH_URI"`
+	EthUris          []string `mapstructure:"ETH_URIS"`
+	EthMaxHeadLag    uint64   `mapstructure:"ETH_MAX_HEAD_LAG"`
+	RpcRateLimits    string   `mapstructure:"RPC_RATE_LIMITS"`
+	RpcDefaultRate   float64  `mapstructure:"RPC_DEFAULT_RATE"`
+	RpcMaxRetries    int      `mapstructure:"RPC_MAX_RETRIES"`
+	RpcDailyBudget   int64    `mapstructure:"RPC_DAILY_CU_BUDGET"`
+	MongoUri         string   `mapstructure:"MONGO_URI"`
+	MongoDb          string   `mapstructure:"MONGO_DB"`
+	MongoEvent       string   `mapstructure:"MONGO_EVENT_COLLECTION"`
+	MongoNft         string   `mapstructure:"MONGO_NFT_COLLECTION"`
+	MongoApprovedNft string   `mapstructure:"MONGO_APPROVED_COLLECTION"`
+	MongoBlock       string   `mapstructure:"MONGO_BLOCK_COLLECTION"`
+	MongoDeadLetter  string   `mapstructure:"MONGO_DEADLETTER_COLLECTION"`
+	MongoMigration   string   `mapstructure:"MONGO_MIGRATION_COLLECTION"`
+	MongoContract    string   `mapstructure:"MONGO_CONTRACT_COLLECTION"`
+	MongoSale        string   `mapstructure:"MONGO_SALE_COLLECTION"`
+	MongoHolding     string   `mapstructure:"MONGO_HOLDING_COLLECTION"`
+	MongoStale       string   `mapstructure:"MONGO_STALE_COLLECTION"`
+	MongoHolderStats string   `mapstructure:"MONGO_HOLDER_STATS_COLLECTION"`
+	MongoAlert       string   `mapstructure:"MONGO_ALERT_COLLECTION"`
+	MongoActivity    string   `mapstructure:"MONGO_ACTIVITY_COLLECTION"`
+	MongoMetadata    string   `mapstructure:"MONGO_METADATA_COLLECTION"`
+	LogOutput        bool     `mapstructure:"LOG_OUTPUT"`
+	LogName          string   `mapstructure:"LOG_NAME"`
+	NftAddress       string   `mapstructure:"NFT_ADDRESS"`
+	ChainId          uint64   `mapstructure:"CHAIN_ID"`
+	Confirmations    uint64   `mapstructure:"CONFIRMATIONS"`
+	StartBlock       uint64   `mapstructure:"START_BLOCK"`
+	Discovery        bool     `mapstructure:"DISCOVERY"`
+	JobWorkers       int      `mapstructure:"JOB_WORKERS"`
+	JobMaxAttempts   int      `mapstructure:"JOB_MAX_ATTEMPTS"`
+	ReloadSeconds    int      `mapstructure:"CONTRACTS_RELOAD_SECONDS"`
+	RefreshSeconds   int      `mapstructure:"CONTRACT_REFRESH_SECONDS"`
+	ReportSeconds    int      `mapstructure:"HOLDER_REPORT_SECONDS"`
+	WhaleThreshold   int64    `mapstructure:"WHALE_THRESHOLD"`
+	ApiAddr          string   `mapstructure:"API_ADDR"`
+	AdminToken       string   `mapstructure:"ADMIN_TOKEN"`
+	Sink             string   `mapstructure:"SINK"`
+	SinkUrl          string   `mapstructure:"SINK_URL"`
+	SinkTopic        string   `mapstructure:"SINK_TOPIC"`
+
+	// Chains indexed by one deployment, only settable from a config file.
+	// Without chains ETH_URI, NFT_ADDRESS, CHAIN_ID, CONFIRMATIONS and START_BLOCK describe a single chain.
+	Chains []ChainConfig `mapstructure:"CHAINS"`
+}
+
+// ChainConfig one chain indexed by the deployment
+type ChainConfig struct {
+	ChainId       uint64   `mapstructure:"chain_id"`
+	Name          string   `mapstructure:"name"`
+	EthUris       []string `mapstructure:"eth_uris"`
+	Confirmations uint64   `mapstructure:"confirmations"`
+	// StartBlock first block of contracts without checkpoint, 0 starts at the current block
+	StartBlock uint64   `mapstructure:"start_block"`
+	Contracts  []string `mapstructure:"contracts"`
+	// Discovery scans all transfer logs of the chain for erc721 contracts
+	Discovery bool `mapstructure:"discovery"`
+	// Marketplaces contracts whose sale events are trusted by marketplace, added to the known ones of the chain
+	Marketplaces map[string][]string `mapstructure:"marketplaces"`
+}
+
+// defaults of all keys, every key needs one so it can be set from the environment alone
+var defaults = map[string]interface{}{
+	"ETH_URI":                       "",
+	"ETH_URIS":                      "",
+	"ETH_MAX_HEAD_LAG":              5,
+	"RPC_RATE_LIMITS":               "",
+	"RPC_DEFAULT_RATE":              0,
+	"RPC_MAX_RETRIES":               3,
+	"RPC_DAILY_CU_BUDGET":           0,
+	"MONGO_URI":                     "",
+	"MONGO_DB":                      "nft-ex",
+	"MONGO_EVENT_COLLECTION":        "events",
+	"MONGO_NFT_COLLECTION":          "nfts",
+	"MONGO_APPROVED_COLLECTION":     "approved",
+	"MONGO_BLOCK_COLLECTION":        "blocks",
+	"MONGO_DEADLETTER_COLLECTION":   "deadletters",
+	"MONGO_MIGRATION_COLLECTION":    "migrations",
+	"MONGO_CONTRACT_COLLECTION":     "contracts",
+	"MONGO_SALE_COLLECTION":         "sales",
+	"MONGO_HOLDING_COLLECTION":      "holdings",
+	"MONGO_STALE_COLLECTION":        "staleholdings",
+	"MONGO_HOLDER_STATS_COLLECTION": "holderstats",
+	"MONGO_ALERT_COLLECTION":        "alerts",
+	"MONGO_ACTIVITY_COLLECTION":     "activities",
+	"MONGO_METADATA_COLLECTION":     "metadata",
+	"LOG_OUTPUT":                    false,
+	"LOG_NAME":                      "app.log",
+	"NFT_ADDRESS":                   "",
+	"CHAIN_ID":                      1,
+	"CONFIRMATIONS":                 0,
+	"START_BLOCK":                   0,
+	"DISCOVERY":                     false,
+	"JOB_WORKERS":                   8,
+	"JOB_MAX_ATTEMPTS":              5,
+	"CONTRACTS_RELOAD_SECONDS":      30,
+	"CONTRACT_REFRESH_SECONDS":      86400,
+	"HOLDER_REPORT_SECONDS":         3600,
+	"WHALE_THRESHOLD":               20,
+	"API_ADDR":                      ":8080",
+	"ADMIN_TOKEN":                   "",
+	"SINK":                          "",
+	"SINK_URL":                      "",
+	"SINK_TOPIC":                    "nft-events",
 }
 
+// message brokers the job can publish events to with SINK
+const (
+	SinkKafka = "kafka"
+	SinkNats  = "nats"
+	SinkRedis = "redis"
+)
+
+// DefaultEnvFile read when no config file is given and it exists
+const DefaultEnvFile = ".env"
+
+// LoadConfig loads the configuration from the command line flags of the process.
+// Precedence from high to low: flags, environment, secret files, config file, defaults.
 func LoadConfig() (*Config, error) {
-	viper.SetConfigFile(".env")
-	viper.AutomaticEnv()
+	return loadConfig(pflag.CommandLine, os.Args[1:])
+}
 
-	err := viper.ReadInConfig()
-	if err != nil {
+func loadConfig(flags *pflag.FlagSet, args []string) (*Config, error) {
+	v := viper.New()
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
+
+	configFile := flags.String("config", "", "config file (yaml, toml, json or .env), defaults to "+DefaultEnvFile+" if present")
+	names := make(map[string]string)
+	for _, key := range configKeys() {
+		name := flagName(key)
+		names[key] = name
+		flags.String(name, "", key)
+	}
+	if err := flags.Parse(args); err != nil {
 		return nil, err
 	}
+	for key, name := range names {
+		if err := v.BindPFlag(key, flags.Lookup(name)); err != nil {
+			return nil, err
+		}
+	}
+	v.AutomaticEnv()
+
+	file := *configFile
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file == "" {
+		if _, err := os.Stat(DefaultEnvFile); err == nil {
+			file = DefaultEnvFile
+		}
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", file, err)
+		}
+	}
+
+	// secrets mounted as files, e.g. MONGO_URI_FILE=/run/secrets/mongo_uri
+	for key, name := range names {
+		path := v.GetString(key + "_FILE")
+		if path == "" || flags.Changed(name) || os.Getenv(key) != "" {
+			continue
+		}
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s_FILE: %w", key, err)
+		}
+		v.Set(key, strings.TrimSpace(string(secret)))
+	}
 
 	config := &Config{}
-	err = viper.Unmarshal(config)
-	if err != nil {
+	if err := v.Unmarshal(config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 	return config, nil
 }
+
+// configKeys keys of all plain config fields, lists of structs can only be set from a config file
+func configKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+		keys = append(keys, field.Tag.Get("mapstructure"))
+	}
+	return keys
+}
+
+// flagName command line flag of a key, ETH_URI is --eth-uri
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Validate checks all values, reporting every invalid one
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(key string, err error) {
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+
+	if len(c.Chains) == 0 {
+		if len(c.EthEndpoints()) == 0 {
+			check("ETH_URI", errors.New("missing, set ETH_URI or ETH_URIS"))
+		}
+		for _, uri := range c.EthEndpoints() {
+			check("ETH_URI", validateEthUri(uri))
+		}
+		if c.ChainId == 0 {
+			check("CHAIN_ID", errors.New("missing"))
+		}
+	}
+	chainIds := make(map[uint64]bool)
+	for i, chain := range c.Chains {
+		key := fmt.Sprintf("CHAINS[%d]", i)
+		if chain.ChainId == 0 {
+			check(key, errors.New("chain_id missing"))
+		} else if chainIds[chain.ChainId] {
+			check(key, fmt.Errorf("chain_id %d listed twice", chain.ChainId))
+		}
+		chainIds[chain.ChainId] = true
+		if len(chain.EthUris) == 0 {
+			check(key, errors.New("eth_uris missing"))
+		}
+		for _, uri := range chain.EthUris {
+			check(key, validateEthUri(uri))
+		}
+		for _, contract := range chain.Contracts {
+			if !common.IsHexAddress(contract) {
+				check(key, fmt.Errorf("contract %q is not an address", contract))
+			}
+		}
+		for marketplace, contracts := range chain.Marketplaces {
+			for _, contract := range contracts {
+				if !common.IsHexAddress(contract) {
+					check(key, fmt.Errorf("%s contract %q is not an address", marketplace, contract))
+				}
+			}
+		}
+	}
+	check("MONGO_URI", validateMongoUri(c.MongoUri))
+	for key, value := range map[string]string{
+		"MONGO_DB":                      c.MongoDb,
+		"MONGO_EVENT_COLLECTION":        c.MongoEvent,
+		"MONGO_NFT_COLLECTION":          c.MongoNft,
+		"MONGO_APPROVED_COLLECTION":     c.MongoApprovedNft,
+		"MONGO_BLOCK_COLLECTION":        c.MongoBlock,
+		"MONGO_DEADLETTER_COLLECTION":   c.MongoDeadLetter,
+		"MONGO_MIGRATION_COLLECTION":    c.MongoMigration,
+		"MONGO_CONTRACT_COLLECTION":     c.MongoContract,
+		"MONGO_SALE_COLLECTION":         c.MongoSale,
+		"MONGO_HOLDING_COLLECTION":      c.MongoHolding,
+		"MONGO_STALE_COLLECTION":        c.MongoStale,
+		"MONGO_HOLDER_STATS_COLLECTION": c.MongoHolderStats,
+		"MONGO_ALERT_COLLECTION":        c.MongoAlert,
+		"MONGO_ACTIVITY_COLLECTION":     c.MongoActivity,
+		"MONGO_METADATA_COLLECTION":     c.MongoMetadata,
+	} {
+		if strings.TrimSpace(value) == "" {
+			check(key, errors.New("missing"))
+		}
+	}
+	if c.NftAddress != "" && !common.IsHexAddress(c.NftAddress) {
+		check("NFT_ADDRESS", fmt.Errorf("%q is not an address", c.NftAddress))
+	}
+	if c.LogOutput && c.LogName == "" {
+		check("LOG_NAME", errors.New("missing while LOG_OUTPUT is set"))
+	}
+	if c.JobWorkers < 1 {
+		check("JOB_WORKERS", fmt.Errorf("%d must be at least 1", c.JobWorkers))
+	}
+	if c.JobMaxAttempts < 1 {
+		check("JOB_MAX_ATTEMPTS", fmt.Errorf("%d must be at least 1", c.JobMaxAttempts))
+	}
+	if c.ReloadSeconds < 1 {
+		check("CONTRACTS_RELOAD_SECONDS", fmt.Errorf("%d must be at least 1", c.ReloadSeconds))
+	}
+	if c.RefreshSeconds < 1 {
+		check("CONTRACT_REFRESH_SECONDS", fmt.Errorf("%d must be at least 1", c.RefreshSeconds))
+	}
+	if c.ReportSeconds < 1 {
+		check("HOLDER_REPORT_SECONDS", fmt.Errorf("%d must be at least 1", c.ReportSeconds))
+	}
+	if c.WhaleThreshold < 1 {
+		check("WHALE_THRESHOLD", fmt.Errorf("%d must be at least 1", c.WhaleThreshold))
+	}
+	switch c.Sink {
+	case "":
+	case SinkKafka, SinkNats, SinkRedis:
+		if c.SinkUrl == "" {
+			check("SINK_URL", fmt.Errorf("missing while SINK is %s", c.Sink))
+		}
+		if strings.TrimSpace(c.SinkTopic) == "" {
+			check("SINK_TOPIC", fmt.Errorf("missing while SINK is %s", c.Sink))
+		}
+	default:
+		check("SINK", fmt.Errorf("%q is not one of %s, %s, %s", c.Sink, SinkKafka, SinkNats, SinkRedis))
+	}
+	if c.RpcMaxRetries < 0 {
+		check("RPC_MAX_RETRIES", fmt.Errorf("%d must not be negative", c.RpcMaxRetries))
+	}
+	if c.RpcDefaultRate < 0 {
+		check("RPC_DEFAULT_RATE", fmt.Errorf("%v must not be negative", c.RpcDefaultRate))
+	}
+	if c.RpcDailyBudget < 0 {
+		check("RPC_DAILY_CU_BUDGET", fmt.Errorf("%d must not be negative", c.RpcDailyBudget))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func validateEthUri(uri string) error {
+	// ipc endpoints are file paths
+	if strings.HasPrefix(uri, "/") || strings.HasSuffix(uri, ".ipc") {
+		return nil
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("%q is not a url: %v", uri, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return fmt.Errorf("%q must use http, https, ws or wss", uri)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", uri)
+	}
+	return nil
+}
+
+func validateMongoUri(uri string) error {
+	if uri == "" {
+		return errors.New("missing")
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return errors.New("not a url")
+	}
+	if u.Scheme != "mongodb" && u.Scheme != "mongodb+srv" {
+		return errors.New("must use mongodb or mongodb+srv")
+	}
+	if u.Host == "" {
+		return errors.New("has no host")
+	}
+	return nil
+}
+
+// EthEndpoints all configured rpc endpoints, ETH_URI first followed by the comma separated ETH_URIS
+func (c *Config) EthEndpoints() []string {
+	var endpoints []string
+	seen := make(map[string]bool)
+	for _, uri := range append([]string{c.EthUri}, c.EthUris...) {
+		uri = strings.TrimSpace(uri)
+		if uri == "" || seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		endpoints = append(endpoints, uri)
+	}
+	return endpoints
+}
+
+// ChainConfigs the chains to index, the single chain of the plain settings when no chains are listed
+func (c *Config) ChainConfigs() []ChainConfig {
+	if len(c.Chains) > 0 {
+		return c.Chains
+	}
+
+	chain := ChainConfig{
+		ChainId:       c.ChainId,
+		EthUris:       c.EthEndpoints(),
+		Confirmations: c.Confirmations,
+		StartBlock:    c.StartBlock,
+		Discovery:     c.Discovery,
+	}
+	if c.NftAddress != "" {
+		chain.Contracts = []string{c.NftAddress}
+	}
+	return []ChainConfig{chain}
+}
+
+// Label name of the chain for logs, its id when unnamed
+func (c ChainConfig) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return fmt.Sprintf("chain %d", c.ChainId)
+}
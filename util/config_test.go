@@ -0,0 +1,120 @@
+package util
+
+import (
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func load(args ...string) (*Config, error) {
+	return loadConfig(pflag.NewFlagSet("test", pflag.ContinueOnError), args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+eth_uri: ws://file:8545
+mongo_uri: mongodb://file:27017
+mongo_db: file-db
+job_workers: 4
+`)
+	t.Setenv("MONGO_DB", "env-db")
+	t.Setenv("JOB_WORKERS", "6")
+
+	config, err := load("--config", file, "--job-workers", "2")
+	assert.NoError(t, err)
+	assert.Equal(t, "ws://file:8545", config.EthUri)
+	assert.Equal(t, "env-db", config.MongoDb)
+	assert.Equal(t, 2, config.JobWorkers)
+	assert.Equal(t, "events", config.MongoEvent)
+}
+
+func TestLoadConfigToml(t *testing.T) {
+	file := writeFile(t, "config.toml", `
+ETH_URIS = ["http://a:8545", "http://b:8545"]
+MONGO_URI = "mongodb+srv://cluster.example.com"
+`)
+	config, err := load("--config", file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://a:8545", "http://b:8545"}, config.EthEndpoints())
+}
+
+func TestLoadConfigSecretFile(t *testing.T) {
+	secret := writeFile(t, "mongo_uri", "mongodb://user:secret@db:27017\n")
+	t.Setenv("ETH_URI", "ws://localhost:8545")
+	t.Setenv("MONGO_URI_FILE", secret)
+
+	config, err := load()
+	assert.NoError(t, err)
+	assert.Equal(t, "mongodb://user:secret@db:27017", config.MongoUri)
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	t.Setenv("ETH_URI", "localhost:8545")
+	t.Setenv("NFT_ADDRESS", "0x123")
+
+	_, err := load("--job-workers", "0", "--sink", "rabbitmq")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "ETH_URI")
+	assert.Contains(t, err.Error(), "MONGO_URI: missing")
+	assert.Contains(t, err.Error(), "NFT_ADDRESS")
+	assert.Contains(t, err.Error(), "JOB_WORKERS")
+	assert.Contains(t, err.Error(), "SINK")
+
+	_, err = load("--sink", SinkKafka)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SINK_URL: missing while SINK is kafka")
+}
+
+func TestLoadConfigChains(t *testing.T) {
+	file := writeFile(t, "config.yaml", `
+mongo_uri: mongodb://localhost:27017
+chains:
+  - chain_id: 1
+    name: mainnet
+    eth_uris: [wss://mainnet.example.com]
+    confirmations: 12
+    contracts: ["0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D"]
+  - chain_id: 137
+    eth_uris: [https://polygon.example.com]
+`)
+	config, err := load("--config", file)
+	assert.NoError(t, err)
+	chains := config.ChainConfigs()
+	assert.Len(t, chains, 2)
+	assert.Equal(t, "mainnet", chains[0].Label())
+	assert.Equal(t, uint64(12), chains[0].Confirmations)
+	assert.Equal(t, "chain 137", chains[1].Label())
+
+	file = writeFile(t, "twice.yaml", `
+mongo_uri: mongodb://localhost:27017
+chains:
+  - chain_id: 1
+    eth_uris: [wss://a.example.com]
+  - chain_id: 1
+`)
+	_, err = load("--config", file)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "chain_id 1 listed twice")
+	assert.Contains(t, err.Error(), "eth_uris missing")
+}
+
+func TestChainConfigsLegacy(t *testing.T) {
+	t.Setenv("ETH_URI", "ws://localhost:8545")
+	t.Setenv("MONGO_URI", "mongodb://localhost:27017")
+	t.Setenv("NFT_ADDRESS", "0xBC4CA0EdA7647A8aB7C2061c2E118A18a936f13D")
+	config, err := load()
+	assert.NoError(t, err)
+	chains := config.ChainConfigs()
+	assert.Len(t, chains, 1)
+	assert.Equal(t, uint64(1), chains[0].ChainId)
+	assert.Equal(t, []string{"ws://localhost:8545"}, chains[0].EthUris)
+	assert.Equal(t, []string{config.NftAddress}, chains[0].Contracts)
+}
@@ -0,0 +1,50 @@
+package worker
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Pool runs tasks on a fixed number of workers.
+// Tasks submitted with the same key always run on the same worker, one after another in submission order.
+type Pool struct {
+	queues []chan func()
+	wg     sync.WaitGroup
+}
+
+// NewPool starts size workers, each buffering up to queue pending tasks
+func NewPool(size, queue int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+
+	p := &Pool{queues: make([]chan func(), size)}
+	for i := range p.queues {
+		p.queues[i] = make(chan func(), queue)
+		p.wg.Add(1)
+		go p.run(p.queues[i])
+	}
+	return p
+}
+
+func (p *Pool) run(queue chan func()) {
+	defer p.wg.Done()
+	for task := range queue {
+		task()
+	}
+}
+
+// Submit queues task on the worker owning key, blocking while that worker's queue is full
+func (p *Pool) Submit(key string, task func()) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	p.queues[h.Sum32()%uint32(len(p.queues))] <- task
+}
+
+// Wait stops accepting tasks and waits until all submitted tasks are done
+func (p *Pool) Wait() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolKeepsOrderPerKey(t *testing.T) {
+	p := NewPool(4, 1)
+
+	var mu sync.Mutex
+	results := make(map[string][]int)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("token-%d", i%5)
+		i := i
+		p.Submit(key, func() {
+			// later tasks finish faster, order must still hold
+			time.Sleep(time.Duration(100-i) * time.Microsecond)
+			mu.Lock()
+			results[key] = append(results[key], i)
+			mu.Unlock()
+		})
+	}
+	p.Wait()
+
+	for key, values := range results {
+		assert.Len(t, values, 20, key)
+		for j := 1; j < len(values); j++ {
+			assert.Less(t, values[j-1], values[j], key)
+		}
+	}
+}
+
+func TestPoolIsBounded(t *testing.T) {
+	p := NewPool(3, 10)
+
+	var running, max int64
+	for i := 0; i < 30; i++ {
+		p.Submit(fmt.Sprint(i), func() {
+			n := atomic.AddInt64(&running, 1)
+			for {
+				m := atomic.LoadInt64(&max)
+				if n <= m || atomic.CompareAndSwapInt64(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		})
+	}
+	p.Wait()
+
+	assert.LessOrEqual(t, max, int64(3))
+	assert.Greater(t, max, int64(0))
+}